@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codingric/moneyman/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/teambition/rrule-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// Up's API, `up` in the config overrides it
+	DefaultUpURL = "https://api.up.com.au/api/v1"
+	// Currency backend balances are summed in
+	DefaultBalanceCurrency = "AUD"
+)
+
+type Balance struct {
+	Name string `mapstructure:"name"`
+	// Where the balance comes from, `backend` (default) or `up`
+	Source string `mapstructure:"source"`
+	// Backend account number, or Up account id
+	Account string `mapstructure:"account"`
+	// Balance at the start of Since, backend transactions from then on are
+	// added to it
+	Opening float64 `mapstructure:"opening"`
+	Since   string  `mapstructure:"since"`
+	// Currency of the backend account, transactions in others are converted
+	Currency string `mapstructure:"currency"`
+	// Up personal access token, may be age encrypted
+	Token string  `mapstructure:"token"`
+	Floor float64 `mapstructure:"floor"`
+	// Days of upcoming `amount` checks to project the balance over
+	Days int `mapstructure:"days"`
+	// Names of the `amount` checks paid from the account, every one with an
+	// rrule when empty
+	Checks []string `mapstructure:"checks"`
+}
+
+// Payment expected from an `amount` check's rrule
+type Payment struct {
+	Name   string
+	Date   time.Time
+	Amount float64
+}
+
+type SummaryResponse struct {
+	Data []struct {
+		Sum float64 `json:"sum"`
+	} `json:"data"`
+}
+
+type UpAccountResponse struct {
+	Data struct {
+		Attributes struct {
+			Balance struct {
+				Value string `json:"value"`
+			} `json:"balance"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// CheckBalance alerts when the account's balance is below the floor, or is
+// projected to go below it once the upcoming payments over the next Days
+// are made
+func CheckBalance(c Balance, ctx context.Context) (msg string, err error) {
+	ctx, span := tracing.NewSpan("CheckBalance", ctx)
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("check.name", c.Name),
+		attribute.String("check.source", c.Source),
+		attribute.String("check.account", c.Account),
+		attribute.Float64("check.floor", c.Floor),
+		attribute.Int("check.days", c.Days),
+	)
+
+	balance, err := accountBalance(c, ctx)
+	if err != nil {
+		log.Error().Err(err).Msgf("Unable to get balance of %s", c.Name)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to get balance")
+		return "", err
+	}
+	span.SetAttributes(attribute.Float64("balance", balance))
+
+	if balance < c.Floor {
+		msg = fmt.Sprintf("%s balance %s below %s", c.Name, dollars(balance), dollars(c.Floor))
+		span.SetAttributes(attribute.String("result", msg))
+		return
+	}
+
+	payments, err := UpcomingPayments(c.Checks, time.Now(), c.Days)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to project balance")
+		return "", err
+	}
+	projected := balance
+	for _, p := range payments {
+		projected += p.Amount
+		if projected < c.Floor {
+			msg = fmt.Sprintf(
+				"%s balance %s projected to drop to %s on %s after %s, below %s",
+				c.Name,
+				dollars(balance),
+				dollars(projected),
+				p.Date.Format("Mon 2 Jan"),
+				p.Name,
+				dollars(c.Floor),
+			)
+			span.SetAttributes(attribute.String("result", msg))
+			return
+		}
+	}
+	return
+}
+
+// accountBalance is the balance of the account now, from Up or the opening
+// balance plus the backend's transactions since
+func accountBalance(c Balance, ctx context.Context) (float64, error) {
+	switch c.Source {
+	case "", "backend":
+		currency := c.Currency
+		if currency == "" {
+			currency = DefaultBalanceCurrency
+		}
+		params := url.Values{"account": {c.Account}, "transfers": {"true"}, "base": {currency}}
+		if c.Since != "" {
+			since, err := time.ParseInLocation("2006-01-02", c.Since, time.Local)
+			if err != nil {
+				return 0, fmt.Errorf("invalid since '%s'", c.Since)
+			}
+			params.Set("created__ge", since.Format("2006-01-02T15:04:05"))
+		}
+		url_ := fmt.Sprintf("%s/summary?%s", strings.TrimSuffix(viper.GetString("backend"), "/"), params.Encode())
+		req, _ := http.NewRequestWithContext(ctx, "GET", url_, nil)
+		var summary SummaryResponse
+		if err := getJSON(req, &summary); err != nil {
+			return 0, err
+		}
+		balance := c.Opening
+		for _, row := range summary.Data {
+			balance += row.Sum
+		}
+		return math.Round(balance*100) / 100, nil
+	case "up":
+		base := viper.GetString("up")
+		if base == "" {
+			base = DefaultUpURL
+		}
+		req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/accounts/%s", strings.TrimSuffix(base, "/"), url.PathEscape(c.Account)), nil)
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+		var account UpAccountResponse
+		if err := getJSON(req, &account); err != nil {
+			return 0, err
+		}
+		balance, err := strconv.ParseFloat(account.Data.Attributes.Balance.Value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid balance '%s'", account.Data.Attributes.Balance.Value)
+		}
+		return balance, nil
+	default:
+		return 0, fmt.Errorf("invalid source '%s'", c.Source)
+	}
+}
+
+// UpcomingPayments lists the payments the named `amount` checks, or all
+// those with an rrule when none are named, expect over the days after now in
+// date order
+func UpcomingPayments(names []string, now time.Time, days int) ([]Payment, error) {
+	payments := []Payment{}
+	if days <= 0 {
+		return payments, nil
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	end := now.AddDate(0, 0, days)
+	for _, c := range amountChecks() {
+		if len(names) > 0 && !wanted[c.Name] {
+			continue
+		}
+		delete(wanted, c.Name)
+		if c.Rrule == "" {
+			continue
+		}
+		rr, err := rrule.StrToRRule(c.Rrule)
+		if err != nil {
+			return nil, fmt.Errorf("%s rrule invalid", c.Name)
+		}
+		for _, date := range rr.Between(now, end, false) {
+			payments = append(payments, Payment{Name: c.Name, Date: date, Amount: c.Expected})
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("amount check %s not found", name)
+	}
+
+	sort.SliceStable(payments, func(i, j int) bool { return payments[i].Date.Before(payments[j].Date) })
+	return payments, nil
+}
+
+// dollars formats an amount with its sign before the dollar sign
+func dollars(v float64) string {
+	if v < 0 {
+		return fmt.Sprintf("-$%0.2f", -v)
+	}
+	return fmt.Sprintf("$%0.2f", v)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBalance(t *testing.T) {
+	config := `checks:
+  - name: Phone
+    type: amount
+    expected: -50
+    rrule: FREQ=MONTHLY;BYMONTHDAY=5;BYHOUR=9;BYMINUTE=0;BYSECOND=0;DTSTART=20000101T000000Z
+  - name: Rent
+    type: amount
+    expected: -500
+    rrule: FREQ=WEEKLY;BYDAY=FR;BYHOUR=9;BYMINUTE=0;BYSECOND=0;DTSTART=20000101T000000Z
+  - name: Fuel
+    type: amount
+    expected: -80
+  - name: Spending
+    type: balance`
+
+	type F struct {
+		args     Balance
+		response string
+		status   int
+	}
+	type E struct {
+		result string
+		err    string
+		url    string
+		auth   string
+	}
+
+	test_data := []struct {
+		name     string
+		fixture  F
+		expected E
+	}{
+		{
+			"Fine",
+			F{args: Balance{Name: "Spending", Account: "1234", Opening: 1000, Floor: 100, Days: 7}, response: `{"data":[{"sum":-100}]}`},
+			E{url: "/transactions/summary?account=1234&base=AUD&transfers=true"},
+		},
+		{
+			"BelowFloor",
+			F{args: Balance{Name: "Spending", Account: "1234", Opening: 100, Since: "2000-01-01", Floor: 100, Days: 7}, response: `{"data":[{"sum":-100.5}]}`},
+			E{
+				result: "Spending balance -$0.50 below $100.00",
+				url:    "/transactions/summary?account=1234&base=AUD&created__ge=2000-01-01T00%3A00%3A00&transfers=true",
+			},
+		},
+		{
+			"Projected",
+			F{args: Balance{Name: "Spending", Account: "1234", Opening: 690, Floor: 100, Days: 7}, response: `{"data":[{"sum":-50}]}`},
+			E{
+				result: "Spending balance $640.00 projected to drop to $90.00 on Fri 7 Jan after Rent, below $100.00",
+				url:    "/transactions/summary?account=1234&base=AUD&transfers=true",
+			},
+		},
+		{
+			"ProjectedNamed",
+			F{args: Balance{Name: "Spending", Account: "1234", Opening: 640, Floor: 100, Days: 7, Checks: []string{"Phone"}}, response: `{"data":[]}`},
+			E{
+				url: "/transactions/summary?account=1234&base=AUD&transfers=true",
+			},
+		},
+		{
+			"NotFound",
+			F{args: Balance{Name: "Spending", Account: "1234", Opening: 1000, Floor: 100, Days: 7, Checks: []string{"Gym"}}, response: `{"data":[]}`},
+			E{
+				err: "amount check Gym not found",
+				url: "/transactions/summary?account=1234&base=AUD&transfers=true",
+			},
+		},
+		{
+			"Up",
+			F{args: Balance{Name: "Spending", Source: "up", Account: "abc", Token: "secret", Floor: 100}, response: `{"data":{"attributes":{"balance":{"value":"99.99"}}}}`},
+			E{
+				result: "Spending balance $99.99 below $100.00",
+				url:    "/up/accounts/abc",
+				auth:   "Bearer secret",
+			},
+		},
+		{
+			"InvalidSource",
+			F{args: Balance{Name: "Spending", Source: "bank"}},
+			E{err: "invalid source 'bank'"},
+		},
+		{
+			"InvalidSince",
+			F{args: Balance{Name: "Spending", Since: "yesterday"}},
+			E{err: "invalid since 'yesterday'"},
+		},
+		{
+			"Currency",
+			F{args: Balance{Name: "Spending", Account: "1234", Currency: "USD", Opening: 1000, Floor: 100}, response: `{"data":[{"sum":-100}]}`},
+			E{url: "/transactions/summary?account=1234&base=USD&transfers=true"},
+		},
+		{
+			"ErrConversion",
+			F{args: Balance{Name: "Spending", Account: "1234"}, response: `{"error":"no AUD/EUR rate on 2000-01-01"}`, status: http.StatusBadRequest},
+			E{
+				err: `{"error":"no aud/eur rate on 2000-01-01"}`,
+				url: "/transactions/summary?account=1234&base=AUD&transfers=true",
+			},
+		},
+		{
+			"ErrBackend",
+			F{args: Balance{Name: "Spending", Account: "1234"}, response: "Failure", status: http.StatusInternalServerError},
+			E{
+				err: "failure",
+				url: "/transactions/summary?account=1234&base=AUD&transfers=true",
+			},
+		},
+	}
+
+	for _, test := range test_data {
+		t.Run(test.name, func(tt *testing.T) {
+			defer monkey.UnpatchAll()
+			monkey.Patch(time.Now, func() time.Time {
+				return time.Date(2000, time.January, 4, 0, 0, 0, 0, time.UTC)
+			})
+
+			var url, auth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				url, auth = r.URL.String(), r.Header.Get("Authorization")
+				if test.fixture.status != 0 {
+					w.WriteHeader(test.fixture.status)
+				}
+				w.Write([]byte(test.fixture.response))
+			}))
+			defer server.Close()
+
+			viper.Reset()
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewBufferString(config))
+			viper.Set("backend", server.URL+"/transactions")
+			viper.Set("up", server.URL+"/up")
+			httpClient = server.Client()
+
+			result, err := CheckBalance(test.fixture.args, context.Background())
+			assert.Equal(tt, test.expected.result, result)
+			assert.Equal(tt, test.expected.url, url)
+			assert.Equal(tt, test.expected.auth, auth)
+			if test.expected.err == "" {
+				assert.Nil(tt, err)
+			} else {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.expected.err, err.Error())
+				}
+			}
+		})
+	}
+}
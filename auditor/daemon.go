@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingric/moneyman/pkg/notify"
+	"github.com/codingric/moneyman/pkg/tracing"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/teambition/rrule-go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Schedule checks without one of their own, or a top level `schedule`,
+	// run on
+	DefaultSchedule = "@hourly"
+	// Reminder interval of checks without their own `remind`, or a top level
+	// one, `0` never reminds
+	DefaultRemind = "24h"
+)
+
+// Schedule returns when a check is next due after t, zero when never
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type rruleSchedule struct {
+	*rrule.RRule
+}
+
+func (r rruleSchedule) Next(t time.Time) time.Time {
+	return r.After(t, false)
+}
+
+// ParseSchedule parses a cron expression, e.g. `0 9 * * *` or `@daily`, or an
+// rrule, e.g. `FREQ=DAILY;BYHOUR=9;BYMINUTE=0;BYSECOND=0`
+func ParseSchedule(s string) (Schedule, error) {
+	if strings.Contains(strings.ToUpper(s), "FREQ=") {
+		rr, err := rrule.StrToRRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %w", s, err)
+		}
+		return rruleSchedule{rr}, nil
+	}
+	schedule, err := cron.ParseStandard(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule '%s': %w", s, err)
+	}
+	return schedule, nil
+}
+
+// Daemon runs every check on its own schedule, remembering its state between
+// runs and restarts, and serves it over HTTP. Checks are only notified when
+// they start firing, as reminders, and when they resolve. Notices carry
+// the time they're sent, so notify's 24 hour dedupe of identical messages
+// doesn't swallow reminders and repeats.
+type Daemon struct {
+	store     *Store
+	checks    Checks
+	schedules []Schedule
+	reminds   []time.Duration
+	timeouts  []time.Duration
+	// Runs of the same check are serialised
+	locks []sync.Mutex
+}
+
+// NewDaemon loads the configured checks and their schedules
+func NewDaemon(store *Store) (*Daemon, error) {
+	var checks Checks
+	if err := viper.UnmarshalKey("checks", &checks); err != nil {
+		return nil, fmt.Errorf("invalid checks: %w", err)
+	}
+
+	d := &Daemon{
+		store:     store,
+		checks:    checks,
+		schedules: make([]Schedule, len(checks)),
+		reminds:   make([]time.Duration, len(checks)),
+		timeouts:  make([]time.Duration, len(checks)),
+		locks:     make([]sync.Mutex, len(checks)),
+	}
+	names := map[string]bool{}
+	for i := range d.checks {
+		check := &d.checks[i]
+		switch {
+		case check.Name == "":
+			return nil, fmt.Errorf("check %d missing name", i)
+		case names[check.Name]:
+			return nil, fmt.Errorf("duplicate check name %s", check.Name)
+		}
+		names[check.Name] = true
+
+		if check.Schedule == "" {
+			check.Schedule = viper.GetString("schedule")
+		}
+		if check.Schedule == "" {
+			check.Schedule = DefaultSchedule
+		}
+		schedule, err := ParseSchedule(check.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %w", check.Name, err)
+		}
+		d.schedules[i] = schedule
+
+		remind := check.Remind
+		if remind == "" {
+			remind = viper.GetString("remind")
+		}
+		if remind == "" {
+			remind = DefaultRemind
+		}
+		if d.reminds[i], err = time.ParseDuration(remind); err != nil {
+			return nil, fmt.Errorf("check %s: invalid remind '%s'", check.Name, remind)
+		}
+		if d.timeouts[i], err = checkTimeout(*check); err != nil {
+			return nil, fmt.Errorf("check %s: %w", check.Name, err)
+		}
+		if check.Resolved == "" {
+			check.Resolved = fmt.Sprintf("Resolved: %s", check.Name)
+		}
+	}
+	return d, nil
+}
+
+// Run evaluates checks as they fall due until ctx is done
+func (d *Daemon) Run(ctx context.Context) error {
+	for {
+		next, err := d.RunDue(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+
+		// Without a next run there's nothing left to wait for but ctx
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if !next.IsZero() {
+			log.Debug().Msgf("Next check due %s", next.Format(time.RFC3339))
+			timer = time.NewTimer(time.Until(next))
+			wait = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		case <-wait:
+		}
+	}
+}
+
+// RunDue evaluates the checks due at now, those that never ran or whose
+// schedule changed, a few at a time, and returns when the next one falls due,
+// zero when every schedule is exhausted.
+// Checks that start failing to run are notified as a summary.
+func (d *Daemon) RunDue(ctx context.Context, now time.Time) (next time.Time, err error) {
+	states := make([]State, len(d.checks))
+	due := []int{}
+	for i, check := range d.checks {
+		if states[i], err = d.store.Get(check.Name); err != nil {
+			return next, err
+		}
+		state := states[i]
+		// Without a next run the schedule is exhausted
+		if state.LastRun == nil || state.Schedule != check.Schedule || (state.NextRun != nil && !state.NextRun.After(now)) {
+			due = append(due, i)
+		}
+	}
+
+	previous := make([]string, len(d.checks))
+	errs := make([]error, len(d.checks))
+	RunPool(len(due), concurrency(), func(j int) {
+		i := due[j]
+		previous[i] = states[i].Error
+		states[i], errs[i] = d.Evaluate(ctx, i)
+	})
+
+	var failed CheckErrors
+	for _, i := range due {
+		if errs[i] != nil {
+			return next, errs[i]
+		}
+		if states[i].Error != "" && previous[i] == "" {
+			failed = append(failed, CheckError{Name: d.checks[i].Name, Err: errors.New(states[i].Error)})
+		}
+	}
+	if len(failed) > 0 {
+		if _, err := notify.Notify(stamp(failed.Summary(), now), ctx); err != nil {
+			log.Error().Err(err).Msgf("Notify error: %s", err.Error())
+		}
+	}
+
+	for _, state := range states {
+		if state.NextRun != nil && (next.IsZero() || state.NextRun.Before(next)) {
+			next = *state.NextRun
+		}
+	}
+	return next, nil
+}
+
+// Evaluate runs the i-th check now, moves its state on, notifies any change
+// and records the result. Only failing to save the state is returned, the
+// check's own error is kept in the state.
+func (d *Daemon) Evaluate(ctx context.Context, i int) (State, error) {
+	check := d.checks[i]
+	d.locks[i].Lock()
+	defer d.locks[i].Unlock()
+
+	// Each run is a trace of its own rather than part of the daemon's
+	ctx, span := otel.Tracer(tracing.ServiceName).Start(ctx, "Evaluate", trace.WithNewRoot())
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("check.name", check.Name),
+		attribute.String("check.type", check.Type),
+	)
+
+	state, err := d.store.Get(check.Name)
+	if err != nil {
+		span.RecordError(err)
+		return state, err
+	}
+	now := time.Now()
+	state.Type, state.Schedule, state.LastRun = check.Type, check.Schedule, &now
+	state.Error = ""
+
+	log.Info().Msgf("Checking: %s (%s)", check.Name, check.Type)
+	message, err := RunCheckTimeout(ctx, i, check, d.timeouts[i])
+	state.Message = message
+	if err != nil {
+		// A check that can't run neither fires nor resolves
+		log.Error().Err(err).Msgf("Check %s failed", check.Name)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Check failed")
+		state.Error = err.Error()
+	} else if notice := state.transition(now, message, d.reminds[i], check.Resolved); notice != "" {
+		if _, err := notify.Notify(stamp(notice, now), ctx); err != nil {
+			log.Error().Err(err).Msgf("Notify error: %s", err.Error())
+			span.RecordError(err)
+			state.Error = err.Error()
+		} else {
+			state.LastAlert = &now
+		}
+	}
+	span.SetAttributes(attribute.String("check.status", state.Status))
+
+	state.NextRun = nil
+	if next := d.schedules[i].Next(now); !next.IsZero() {
+		state.NextRun = &next
+	}
+	result := Result{Time: now, Status: state.Status, Message: state.Message, Error: state.Error}
+	result.Notified = state.LastAlert != nil && state.LastAlert.Equal(now)
+	if err := d.store.AddResult(check.Name, result); err != nil {
+		span.RecordError(err)
+		return state, err
+	}
+	if err := d.store.Put(state); err != nil {
+		span.RecordError(err)
+		return state, err
+	}
+	return state, nil
+}
+
+// stamp adds when a notice is sent to it, making every notice distinct
+func stamp(notice string, t time.Time) string {
+	return fmt.Sprintf("%s\n%s", notice, t.Format("Mon 2 Jan 15:04"))
+}
+
+// Handler serves the daemon's endpoints
+//
+//	GET /healthz
+//	GET /checks
+//	POST /checks/{name}/run
+//	GET /checks/{name}/results
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", d.handleHealthz)
+	mux.HandleFunc("/checks", d.handleChecks)
+	mux.HandleFunc("/checks/", d.handleCheck)
+	return otelhttp.NewHandler(mux, "auditor")
+}
+
+func (d *Daemon) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// handleChecks lists every check with its state
+func (d *Daemon) handleChecks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+	states := []State{}
+	for _, check := range d.checks {
+		state, err := d.store.Get(check.Name)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to load state")
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "unable to load state"})
+			return
+		}
+		state.Type, state.Schedule = check.Type, check.Schedule
+		states = append(states, state)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": states})
+}
+
+// handleCheck evaluates a check on demand or lists its recent results
+func (d *Daemon) handleCheck(w http.ResponseWriter, r *http.Request) {
+	name, action := path.Split(strings.TrimPrefix(r.URL.Path, "/checks/"))
+	name = strings.TrimSuffix(name, "/")
+	method := map[string]string{"run": http.MethodPost, "results": http.MethodGet}[action]
+	if name == "" || method == "" {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+		return
+	}
+	if r.Method != method {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	i := d.index(name)
+	if i < 0 {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": fmt.Sprintf("check %s not found", name)})
+		return
+	}
+
+	if action == "results" {
+		results, err := d.store.Results(name)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to load results")
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "unable to load results"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": results})
+		return
+	}
+
+	state, err := d.Evaluate(r.Context(), i)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to save state")
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "unable to save state"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": state})
+}
+
+// index of the named check, -1 when not configured
+func (d *Daemon) index(name string) int {
+	for i, check := range d.checks {
+		if check.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RunDaemon runs the checks on their schedules and serves their state until
+// ctx is done
+func RunDaemon(ctx context.Context) error {
+	store, err := OpenStore(viper.GetString("state"))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	d, err := NewDaemon(store)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	server := &http.Server{Addr: viper.GetString("listen"), Handler: d.Handler()}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Server error")
+			cancel()
+		}
+	}()
+	log.Info().Msgf("Daemon listening on %s with %d checks", server.Addr, len(d.checks))
+
+	err = d.Run(ctx)
+	shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	server.Shutdown(shutdown)
+	return err
+}
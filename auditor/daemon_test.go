@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/codingric/moneyman/pkg/notify"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2000, time.January, 4, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		schedule string
+		next     time.Time
+		err      string
+	}{
+		{"Cron", "0 9 * * *", time.Date(2000, time.January, 5, 9, 0, 0, 0, time.UTC), ""},
+		{"Descriptor", "@hourly", time.Date(2000, time.January, 4, 10, 0, 0, 0, time.UTC), ""},
+		{"Rrule", "DTSTART:19991231T080000Z\nRRULE:FREQ=DAILY", time.Date(2000, time.January, 5, 8, 0, 0, 0, time.UTC), ""},
+		{"Exhausted", "FREQ=DAILY;COUNT=1;DTSTART=19991231T080000Z", time.Time{}, ""},
+		{"InvalidCron", "0 25 * * *", time.Time{}, "invalid schedule '0 25 * * *': end of range (25) above maximum (23): 25"},
+		{"InvalidRrule", "FREQ=SOMETIMES", time.Time{}, "invalid schedule 'FREQ=SOMETIMES': undefined frequency: SOMETIMES"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			schedule, err := ParseSchedule(test.schedule)
+			if test.err != "" {
+				if assert.Error(tt, err) {
+					assert.Equal(tt, test.err, err.Error())
+				}
+				return
+			}
+			if assert.NoError(tt, err) {
+				assert.Equal(tt, test.next, schedule.Next(from).UTC())
+			}
+		})
+	}
+}
+
+func TestNewDaemon(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		schedules []string
+		err       string
+	}{
+		{
+			"Defaults",
+			"checks:\n  - {name: a, type: amount}\n  - {name: b, type: repay, schedule: '0 9 * * *'}",
+			[]string{DefaultSchedule, "0 9 * * *"},
+			"",
+		},
+		{
+			"TopLevel",
+			"schedule: '@daily'\nchecks:\n  - {name: a, type: amount}",
+			[]string{"@daily"},
+			"",
+		},
+		{
+			"MissingName",
+			"checks:\n  - {type: amount}",
+			nil,
+			"check 0 missing name",
+		},
+		{
+			"Duplicate",
+			"checks:\n  - {name: a, type: amount}\n  - {name: a, type: repay}",
+			nil,
+			"duplicate check name a",
+		},
+		{
+			"InvalidSchedule",
+			"checks:\n  - {name: a, type: amount, schedule: never}",
+			nil,
+			"check a: invalid schedule 'never': expected exactly 5 fields, found 1: [never]",
+		},
+		{
+			"InvalidRemind",
+			"checks:\n  - {name: a, type: amount, remind: daily}",
+			nil,
+			"check a: invalid remind 'daily'",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			viper.Reset()
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewBufferString(test.config))
+
+			d, err := NewDaemon(nil)
+			if test.err != "" {
+				if assert.Error(tt, err) {
+					assert.Equal(tt, test.err, err.Error())
+				}
+				return
+			}
+			if assert.NoError(tt, err) {
+				schedules := []string{}
+				for _, c := range d.checks {
+					schedules = append(schedules, c.Schedule)
+				}
+				assert.Equal(tt, test.schedules, schedules)
+			}
+		})
+	}
+}
+
+// newTestDaemon configures checks and returns a daemon with a fresh store
+func newTestDaemon(t *testing.T, config string) *Daemon {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.ReadConfig(bytes.NewBufferString(config))
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	d, err := NewDaemon(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDaemonRunDue(t *testing.T) {
+	d := newTestDaemon(t, `checks:
+  - {name: hourly, type: amount, schedule: '@hourly'}
+  - {name: daily, type: repay, schedule: 'FREQ=DAILY;BYHOUR=9;BYMINUTE=0;BYSECOND=0;DTSTART=20000101T000000Z'}
+  - {name: count, type: amount, schedule: 'FREQ=DAILY;COUNT=2;DTSTART=20000101T090000Z'}
+  - {name: until, type: amount, schedule: 'FREQ=HOURLY;UNTIL=20000104T080000Z;DTSTART=20000101T000000Z'}`)
+
+	now := time.Date(2000, time.January, 4, 8, 30, 0, 0, time.UTC)
+	ran := []string{}
+	notified := []string{}
+	var mu sync.Mutex
+	defer monkey.UnpatchAll()
+	monkey.Patch(time.Now, func() time.Time { return now })
+	monkey.Patch(RunCheck, func(ctx context.Context, i int, check Check) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, check.Name)
+		switch check.Name {
+		case "daily":
+			return "", errors.New("backend down")
+		case "hourly":
+			return "Payment overdue", nil
+		}
+		return "", nil
+	})
+	monkey.Patch(notify.Notify, func(message string, ctx context.Context) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, message)
+		return 1, nil
+	})
+
+	// Everything runs the first time
+	next, err := d.RunDue(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2000, time.January, 4, 9, 0, 0, 0, time.UTC), next.UTC())
+	assert.ElementsMatch(t, []string{"hourly", "daily", "count", "until"}, ran)
+	assert.ElementsMatch(t, []string{"Payment overdue\nTue 4 Jan 08:30", "Unable to run checks:\ndaily: backend down\nTue 4 Jan 08:30"}, notified)
+
+	state, _ := d.store.Get("hourly")
+	assert.Equal(t, now, *state.LastRun)
+	assert.Equal(t, now, *state.LastAlert)
+	assert.Equal(t, StatusFiring, state.Status)
+	assert.Equal(t, "Payment overdue", state.Message)
+	state, _ = d.store.Get("daily")
+	assert.Equal(t, "backend down", state.Error)
+	assert.Equal(t, "", state.Status)
+	assert.Nil(t, state.LastAlert)
+	state, _ = d.store.Get("count")
+	assert.Equal(t, now, *state.LastRun)
+	assert.Nil(t, state.NextRun)
+
+	// Nothing is due yet
+	ran = []string{}
+	now = now.Add(10 * time.Minute)
+	next, err = d.RunDue(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2000, time.January, 4, 9, 0, 0, 0, time.UTC), next.UTC())
+	assert.Equal(t, []string{}, ran)
+
+	// Both fall due at 9, the message and failure were already sent, and the
+	// exhausted schedules don't run again
+	notified = []string{}
+	now = time.Date(2000, time.January, 4, 9, 0, 0, 0, time.UTC)
+	next, err = d.RunDue(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2000, time.January, 4, 10, 0, 0, 0, time.UTC), next.UTC())
+	assert.ElementsMatch(t, []string{"hourly", "daily"}, ran)
+	assert.Equal(t, []string{}, notified)
+
+	// A restarted daemon remembers when checks are due
+	ran = []string{}
+	restarted, err := NewDaemon(d.store)
+	assert.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, err = restarted.RunDue(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, ran)
+}
+
+func TestDaemonAlerts(t *testing.T) {
+	d := newTestDaemon(t, `remind: 48h
+checks:
+  - {name: AHM, type: amount, resolved: AHM paid}
+  - {name: AWS, type: amount, remind: "0"}`)
+
+	start := time.Date(2000, time.January, 4, 9, 0, 0, 0, time.UTC)
+	now := start
+	messages := map[string]string{}
+	notified := []string{}
+	failNotify := false
+	defer monkey.UnpatchAll()
+	monkey.Patch(time.Now, func() time.Time { return now })
+	monkey.Patch(RunCheck, func(ctx context.Context, i int, check Check) (string, error) {
+		return messages[check.Name], nil
+	})
+	monkey.Patch(notify.Notify, func(message string, ctx context.Context) (int, error) {
+		if failNotify {
+			return 0, errors.New("notify failed")
+		}
+		notified = append(notified, message)
+		return 1, nil
+	})
+
+	tests := []struct {
+		name     string
+		hours    int
+		messages map[string]string
+		fail     bool
+		notified []string
+		status   []string
+	}{
+		{"Ok", 0, map[string]string{}, false, []string{}, []string{StatusOK, StatusOK}},
+		{"Fire", 1, map[string]string{"AHM": "AHM overdue 1 days", "AWS": "AWS overdue"}, false, []string{"AHM overdue 1 days\nTue 4 Jan 10:00", "AWS overdue\nTue 4 Jan 10:00"}, []string{StatusFiring, StatusFiring}},
+		{"StillFiring", 24, map[string]string{"AHM": "AHM overdue 2 days", "AWS": "AWS overdue"}, false, []string{}, []string{StatusFiring, StatusFiring}},
+		{"Remind", 49, map[string]string{"AHM": "AHM overdue 3 days", "AWS": "AWS overdue"}, false, []string{"AHM overdue 3 days\nThu 6 Jan 10:00"}, []string{StatusFiring, StatusFiring}},
+		{"ResolveFailed", 50, map[string]string{"AWS": "AWS overdue"}, true, []string{}, []string{StatusResolved, StatusFiring}},
+		{"ResolveRetried", 51, map[string]string{"AWS": "AWS overdue"}, false, []string{"AHM paid\nThu 6 Jan 12:00"}, []string{StatusResolved, StatusFiring}},
+		{"BackToOk", 52, map[string]string{}, false, []string{"Resolved: AWS\nThu 6 Jan 13:00"}, []string{StatusOK, StatusResolved}},
+		{"FireAgain", 53, map[string]string{"AHM": "AHM overdue 1 days"}, false, []string{"AHM overdue 1 days\nThu 6 Jan 14:00"}, []string{StatusFiring, StatusOK}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			now = start.Add(time.Duration(test.hours) * time.Hour)
+			messages, failNotify, notified = test.messages, test.fail, []string{}
+			status := []string{}
+			for i := range d.checks {
+				state, err := d.Evaluate(context.Background(), i)
+				assert.NoError(tt, err)
+				status = append(status, state.Status)
+			}
+			assert.Equal(tt, test.notified, notified)
+			assert.Equal(tt, test.status, status)
+		})
+	}
+
+	results, err := d.store.Results("AHM")
+	assert.NoError(t, err)
+	if assert.Len(t, results, len(tests)) {
+		assert.Equal(t, Result{Time: start.Add(49 * time.Hour), Status: StatusFiring, Message: "AHM overdue 3 days", Notified: true}, results[3])
+		assert.Equal(t, Result{Time: start.Add(50 * time.Hour), Status: StatusResolved, Error: "notify failed"}, results[4])
+	}
+}
+
+func TestDaemonRemind(t *testing.T) {
+	d := newTestDaemon(t, "checks:\n  - {name: AHM, type: amount, remind: 2h}")
+
+	start := time.Date(2000, time.January, 4, 9, 0, 0, 0, time.UTC)
+	now := start
+	sent := map[string]time.Time{}
+	notified := []string{}
+	defer monkey.UnpatchAll()
+	monkey.Patch(time.Now, func() time.Time { return now })
+	monkey.Patch(RunCheck, func(ctx context.Context, i int, check Check) (string, error) {
+		return "AHM overdue", nil
+	})
+	// Like notify, an identical message is only sent once a day
+	monkey.Patch(notify.Notify, func(message string, ctx context.Context) (int, error) {
+		if at, ok := sent[message]; ok && now.Sub(at) < 24*time.Hour {
+			return 0, nil
+		}
+		sent[message] = now
+		notified = append(notified, message)
+		return 1, nil
+	})
+
+	for hour := 0; hour <= 4; hour++ {
+		now = start.Add(time.Duration(hour) * time.Hour)
+		_, err := d.Evaluate(context.Background(), 0)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"AHM overdue\nTue 4 Jan 09:00", "AHM overdue\nTue 4 Jan 11:00", "AHM overdue\nTue 4 Jan 13:00"}, notified)
+}
+
+func TestDaemonHandler(t *testing.T) {
+	d := newTestDaemon(t, "checks:\n  - {name: AHM, type: amount, schedule: '@hourly'}")
+
+	now := time.Date(2000, time.January, 4, 8, 30, 0, 0, time.UTC)
+	defer monkey.UnpatchAll()
+	monkey.Patch(time.Now, func() time.Time { return now })
+	monkey.Patch(RunCheck, func(ctx context.Context, i int, check Check) (string, error) {
+		return "", nil
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+		expect string
+	}{
+		{"Healthz", "GET", "/healthz", 200, `{"status":"ok"}`},
+		{"NeverRun", "GET", "/checks", 200, `{"data":[{"name":"AHM","type":"amount","schedule":"@hourly"}]}`},
+		{"NoResults", "GET", "/checks/AHM/results", 200, `{"data":[]}`},
+		{"Run", "POST", "/checks/AHM/run", 200, `{"data":{"name":"AHM","type":"amount","schedule":"@hourly","status":"ok","last_run":"2000-01-04T08:30:00Z","next_run":"2000-01-04T09:00:00Z"}}`},
+		{"Checks", "GET", "/checks", 200, `{"data":[{"name":"AHM","type":"amount","schedule":"@hourly","status":"ok","last_run":"2000-01-04T08:30:00Z","next_run":"2000-01-04T09:00:00Z"}]}`},
+		{"Results", "GET", "/checks/AHM/results", 200, `{"data":[{"time":"2000-01-04T08:30:00Z","status":"ok"}]}`},
+		{"RunMethod", "GET", "/checks/AHM/run", 405, `{"error":"method not allowed"}`},
+		{"ResultsMethod", "POST", "/checks/AHM/results", 405, `{"error":"method not allowed"}`},
+		{"ChecksMethod", "POST", "/checks", 405, `{"error":"method not allowed"}`},
+		{"Unknown", "POST", "/checks/AWS/run", 404, `{"error":"check AWS not found"}`},
+		{"NotFound", "GET", "/checks/AHM", 404, `{"error":"not found"}`},
+		{"UnknownAction", "GET", "/checks/AHM/history", 404, `{"error":"not found"}`},
+	}
+	handler := d.Handler()
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+			assert.Equal(tt, test.status, w.Code)
+			b, _ := io.ReadAll(w.Body)
+			assert.Equal(tt, test.expect+"\n", string(b))
+			assert.Equal(tt, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
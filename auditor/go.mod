@@ -5,12 +5,14 @@ go 1.19
 require (
 	bou.ke/monkey v1.0.2
 	github.com/codingric/moneyman/pkg v0.0.0-20230111051501-0cb3f2cc72a6
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/rs/zerolog v1.28.0
 	github.com/rzajac/zltest v0.12.0
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.14.0
 	github.com/stretchr/testify v1.8.1
 	github.com/teambition/rrule-go v1.8.1
+	go.etcd.io/bbolt v1.3.7
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.37.0
 	go.opentelemetry.io/otel v1.11.2
 )
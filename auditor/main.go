@@ -10,9 +10,12 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/codingric/moneyman/pkg/age"
@@ -44,6 +47,10 @@ func LoadConf(ctx context.Context) error {
 	flag.String("a", "/etc/auditor/age.key", "Age key")
 	flag.String("c", "/etc/auditor/config.yaml", "Config yaml")
 	flag.String("l", zerolog.InfoLevel.String(), "trace|debug|info|error|fatal|panic|disabled")
+	flag.Bool("daemon", false, "Run each check on its schedule and serve /checks")
+	flag.String("listen", ":8080", "Daemon listen address")
+	flag.String("s", "/var/lib/auditor/state.db", "Daemon state database")
+	flag.Bool("suggest", false, "Print amount checks for the recurring charges subscription checks find")
 
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
@@ -52,6 +59,7 @@ func LoadConf(ctx context.Context) error {
 	viper.RegisterAlias("loglevel", "l")
 	viper.RegisterAlias("config", "c")
 	viper.RegisterAlias("agekey", "a")
+	viper.RegisterAlias("state", "s")
 
 	cpath := viper.GetString("config")
 
@@ -104,6 +112,22 @@ func main() {
 		log.Fatal().Msg("Failed to load configuration")
 	}
 
+	if viper.GetBool("suggest") {
+		if err := SuggestChecks(ctx, os.Stdout); err != nil {
+			log.Fatal().Msgf("Failure: %s", err.Error())
+		}
+		return
+	}
+
+	if viper.GetBool("daemon") {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := RunDaemon(ctx); err != nil {
+			log.Fatal().Msgf("Failure: %s", err.Error())
+		}
+		return
+	}
+
 	if err := RunChecks(ctx); err != nil {
 		log.Fatal().Msgf("Failure: %s", err.Error())
 	}
@@ -114,6 +138,14 @@ type Checks []Check
 type Check struct {
 	Name string `mapstructure:"name"`
 	Type string `mapstructure:"type"`
+	// Cron expression or rrule the daemon runs the check on
+	Schedule string `mapstructure:"schedule"`
+	// How often the daemon repeats a firing check's message, e.g. `24h`
+	Remind string `mapstructure:"remind"`
+	// Message the daemon sends once a firing check resolves
+	Resolved string `mapstructure:"resolved"`
+	// How long the check may run, e.g. `30s`
+	Timeout string `mapstructure:"timeout"`
 }
 
 type Repay struct {
@@ -133,6 +165,36 @@ type Amount struct {
 	Rrule     string  `mapstructure:"rrule"`
 }
 
+// amountChecks are the configured `amount` checks
+func amountChecks() []Amount {
+	var checks Checks
+	viper.UnmarshalKey("checks", &checks)
+	amounts := []Amount{}
+	for i, check := range checks {
+		if check.Type != "amount" {
+			continue
+		}
+		var c Amount
+		viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c)
+		amounts = append(amounts, c)
+	}
+	return amounts
+}
+
+// threshold is how far an amount may be from expected, either a percentage
+// of it like `10%` or dollars like `$5`
+func threshold(t string, expected float64) float64 {
+	if strings.Contains(t, "%") {
+		pct, _ := strconv.ParseFloat(strings.Replace(t, "%", "", 1), 64)
+		return math.Abs(expected * (pct / 100.0))
+	}
+	thresh, _ := strconv.ParseFloat(strings.Replace(t, "$", "", 1), 64)
+	return math.Abs(thresh)
+}
+
+// RunChecks runs every check once, a few at a time, and notifies their
+// messages. Checks that can't run don't stop the others, they are notified
+// as a summary and returned together.
 func RunChecks(ctx context.Context) error {
 	ctx, span := tracing.NewSpan("RunChecks", ctx)
 
@@ -140,42 +202,72 @@ func RunChecks(ctx context.Context) error {
 	var checks Checks
 
 	viper.UnmarshalKey("checks", &checks)
-	for i, check := range checks {
-
+	messages := make([]string, len(checks))
+	errs := make([]error, len(checks))
+	RunPool(len(checks), concurrency(), func(i int) {
+		check := checks[i]
 		log.Info().Msgf("Checking: %s (%s)", check.Name, check.Type)
-		var message string
-		switch check.Type {
-		// case "repay":
-		// 	var err error
-		// 	var c Repay
-		// 	viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c)
-		// 	message, err = CheckRepay(c)
-		// 	if err != nil {
-		// 		return err
-		// 	}
-		case "amount":
-			var e error
-			var c Amount
-			viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c)
-			message, e = CheckAmount(c, ctx)
-			if e != nil {
-				return e
-			}
-		default:
-			return errors.New("Invalid check type: " + check.Type)
+		timeout, err := checkTimeout(check)
+		if err == nil {
+			messages[i], err = RunCheckTimeout(ctx, i, check, timeout)
+		}
+		errs[i] = err
+	})
+
+	var failed, notifyFailed CheckErrors
+	for i, check := range checks {
+		if errs[i] != nil {
+			log.Error().Err(errs[i]).Msgf("Check %s failed", check.label())
+			failed = append(failed, CheckError{Name: check.label(), Err: errs[i]})
+			continue
 		}
-		if message != "" {
-			_, err := notify.Notify(message, ctx)
+		if messages[i] != "" {
+			_, err := notify.Notify(messages[i], ctx)
 			if err != nil {
 				log.Error().Err(err).Msgf("Notify error: %s", err.Error())
-				return err
+				notifyFailed = append(notifyFailed, CheckError{Name: check.label(), Err: err})
 			}
 		}
+	}
 
+	if len(failed) > 0 {
+		span.RecordError(failed)
+		span.SetStatus(codes.Error, "Checks failed")
+		if _, err := notify.Notify(failed.Summary(), ctx); err != nil {
+			log.Error().Err(err).Msgf("Notify error: %s", err.Error())
+		}
+	}
+	if failed = append(failed, notifyFailed...); len(failed) > 0 {
+		return failed
 	}
 	return nil
 }
 
+// RunCheck evaluates check, the i-th of the configured checks, returning the
+// message to notify if any
+func RunCheck(ctx context.Context, i int, check Check) (message string, err error) {
+	switch check.Type {
+	case "repay":
+		var c Repay
+		viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c)
+		return CheckRepay(c, ctx)
+	case "amount":
+		var c Amount
+		viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c)
+		return CheckAmount(c, ctx)
+	case "balance":
+		var c Balance
+		viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c, viper.DecodeHook(age.AgeHookFunc(age.AgeKey)))
+		return CheckBalance(c, ctx)
+	case "subscription":
+		var c Subscription
+		viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c)
+		return CheckSubscription(c, ctx)
+	default:
+		return "", errors.New("Invalid check type: " + check.Type)
+	}
+}
+
 type APIResponse struct {
 	Data []APITransaction `json:"data"`
 }
@@ -183,7 +275,7 @@ type APIResponse struct {
 type APITransaction struct {
 	Id          int64     `json:"id"`
 	Description string    `json:"description"`
-	Amount      float32   `json:"amount"`
+	Amount      float64   `json:"amount"`
 	Account     int64     `json:"account"`
 	Created     time.Time `json:"created"`
 }
@@ -200,6 +292,13 @@ func QueryBackend(params map[string]string, ctx context.Context) (result APIResp
 	req, _ := http.NewRequestWithContext(ctx, "GET", url_, nil)
 	log.Trace().Str("params", p.Encode()).Msg("Query backend")
 
+	err = getJSON(req, &result)
+	return
+}
+
+// getJSON sends req and decodes its JSON response into v, non 200 responses
+// are returned as errors of their body
+func getJSON(req *http.Request, v interface{}) (err error) {
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query backend")
@@ -215,7 +314,7 @@ func QueryBackend(params map[string]string, ctx context.Context) (result APIResp
 		return
 	}
 
-	err = json.Unmarshal(resp_body, &result)
+	err = json.Unmarshal(resp_body, v)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to pase query result")
 		err = errors.New("failed not parse result")
@@ -258,22 +357,16 @@ func CheckAmount(c Amount, ctx context.Context) (msg string, err error) {
 		}
 	}
 
-	thresh := 0.0
 	params := map[string]string{
 		"description__like": c.Match,
 		"created__gt":       past.Format("2006-01-02T15:04:05"),
 	}
 	if c.Threshold == "" {
 		params["amount__ne"] = fmt.Sprintf("%0.2f", c.Expected)
-	} else if strings.Contains(c.Threshold, "%") {
-		t, _ := strconv.ParseFloat(strings.Replace(c.Threshold, "%", "", 1), 64)
-		thresh = math.Abs(c.Expected * (t / 100.0))
-		params["amount__lt"] = fmt.Sprintf("%0.2f", c.Expected+thresh)
-		params["amount__gt"] = fmt.Sprintf("%0.2f", c.Expected-thresh)
 	} else {
-		thresh, _ = strconv.ParseFloat(strings.Replace(c.Threshold, "$", "", 1), 64)
-		params["amount__gt"] = fmt.Sprintf("%0.2f", c.Expected-math.Abs(thresh))
-		params["amount__lt"] = fmt.Sprintf("%0.2f", c.Expected+math.Abs(thresh))
+		thresh := threshold(c.Threshold, c.Expected)
+		params["amount__gt"] = fmt.Sprintf("%0.2f", c.Expected-thresh)
+		params["amount__lt"] = fmt.Sprintf("%0.2f", c.Expected+thresh)
 	}
 
 	response, err := QueryBackend(params, ctx)
@@ -313,49 +406,74 @@ func CheckAmount(c Amount, ctx context.Context) (msg string, err error) {
 	return
 }
 
-// past := time.Now().AddDate(0, 0, c.Days*-1)
-// params := map[string]string{
-// 	"description__like": c.Match,
-// 	"created__gt":       past.Format("2006-01-02T15:04:05"),
-// 	"amount__lt":        "0.00",
-// }
-// var result []string
-
-// response, err := QueryBackend(params)
-// if err != nil {
-// 	return
-// }
-
-// for _, transaction := range response.Data {
-// 	p := map[string]string{
-// 		"created__gt":       transaction.Created.Format("2006-01-02T15:04:05"),
-// 		"description__like": c.From,
-// 		"amount":            fmt.Sprintf("%0.2f", transaction.Amount*-1),
-// 	}
-
-// 	rr, err := QueryBackend(p)
-// 	if err != nil {
-// 		return msg, err
-// 	}
-
-// 	if len(rr.Data) == 0 {
-// 		m := fmt.Sprintf("$%0.2f from %s", transaction.Amount*-1, transaction.Created.Format("Mon 2 Jan"))
-// 		result = append(result, m)
-// 	}
-// }
-
-//	if len(response.Data) > 0 {
-//		unpaid := len(result)
-//		found := len(response.Data)
-//		log.Info().Msgf("Transactions: %d/%d repaid", found-unpaid, found)
-//	}
-//
-//	if len(result) > 0 {
-//		msg = fmt.Sprintf("Move money from %s to %s:", c.From, c.To)
-//		for _, row := range result {
-//			msg = fmt.Sprintf("%s\n%s", msg, row)
-//		}
-//	}
-func CheckRepay(c Repay) (msg string, err error) {
+func CheckRepay(c Repay, ctx context.Context) (msg string, err error) {
+	ctx, span := tracing.NewSpan("CheckRepay", ctx)
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("check.match", c.Match),
+		attribute.String("check.name", c.Name),
+		attribute.String("check.from", c.From),
+		attribute.String("check.to", c.To),
+		attribute.Int("check.days", c.Days),
+	)
+
+	past := time.Now().AddDate(0, 0, -c.Days)
+	params := map[string]string{
+		"description__like": c.Match,
+		"created__gt":       past.Format("2006-01-02T15:04:05"),
+		"amount__lt":        "0.00",
+	}
+
+	response, err := QueryBackend(params, ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query backend")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to query backend")
+		return "", err
+	}
+
+	var unpaid []string
+	// A credit only repays one debit, even when several are for the same amount
+	used := map[int64]bool{}
+	for _, t := range response.Data {
+		p := map[string]string{
+			"description__like": c.From,
+			"created__gt":       t.Created.Format("2006-01-02T15:04:05"),
+			"amount":            fmt.Sprintf("%0.2f", -t.Amount),
+		}
+
+		repaid, err := QueryBackend(p, ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to query backend")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to query backend")
+			return "", err
+		}
+
+		matched := false
+		for _, r := range repaid.Data {
+			if !used[r.Id] {
+				used[r.Id], matched = true, true
+				break
+			}
+		}
+		if !matched {
+			unpaid = append(unpaid, fmt.Sprintf("$%0.2f from %s", -t.Amount, t.Created.Format("Mon 2 Jan")))
+		}
+	}
+
+	if len(response.Data) > 0 {
+		log.Info().Msgf("Transactions: %d/%d repaid", len(response.Data)-len(unpaid), len(response.Data))
+	}
+
+	if len(unpaid) > 0 {
+		msg = fmt.Sprintf("Move money from %s to %s:", c.From, c.To)
+		for _, row := range unpaid {
+			msg = fmt.Sprintf("%s\n%s", msg, row)
+		}
+		span.SetAttributes(attribute.String("result", msg))
+	}
+
 	return
 }
@@ -3,11 +3,16 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -315,11 +320,13 @@ func TestRunChecks(t *testing.T) {
 	type F struct {
 		config      string
 		checkamount R
+		checkrepay  R
 		notify      N
 	}
 	type E struct {
 		err         string
 		checkamount Amount
+		checkrepay  Repay
 		notify      string
 	}
 
@@ -332,6 +339,13 @@ func TestRunChecks(t *testing.T) {
     threshold: 20%
     match: pineapple
     days: 3`,
+		"repay": `checks:
+  - type: repay
+    name: groceries
+    match: WOOLWORTHS
+    days: 3
+    from: Food
+    to: Spending`,
 		"invalid": `checks:
   - type: invalid`,
 		"error": "*}}--ss",
@@ -354,8 +368,32 @@ func TestRunChecks(t *testing.T) {
 				checkamount: R{err: errors.New("something failed")},
 			},
 			expect: E{
-				err:         "something failed",
+				err:         "test: something failed",
 				checkamount: Amount{Name: "test", Match: "pineapple", Days: 3, Expected: 65, Threshold: "20%", Rrule: ""},
+				notify:      "Unable to run checks:\ntest: something failed",
+			},
+		},
+		{
+			name: "ErrorRepay",
+			fixture: F{
+				config:     configs["repay"],
+				checkrepay: R{err: errors.New("something failed")},
+			},
+			expect: E{
+				err:        "groceries: something failed",
+				checkrepay: Repay{Name: "groceries", Match: "WOOLWORTHS", Days: 3, From: "Food", To: "Spending"},
+				notify:     "Unable to run checks:\ngroceries: something failed",
+			},
+		},
+		{
+			name: "Repay",
+			fixture: F{
+				config:     configs["repay"],
+				checkrepay: R{result: "Move money"},
+			},
+			expect: E{
+				checkrepay: Repay{Name: "groceries", Match: "WOOLWORTHS", Days: 3, From: "Food", To: "Spending"},
+				notify:     "Move money",
 			},
 		},
 		{
@@ -363,7 +401,7 @@ func TestRunChecks(t *testing.T) {
 			fixture: F{
 				config: configs["invalid"],
 			},
-			expect: E{err: "Invalid check type: invalid"},
+			expect: E{err: "invalid: Invalid check type: invalid", notify: "Unable to run checks:\ninvalid: Invalid check type: invalid"},
 		},
 		{
 			name: "NotifyError",
@@ -375,7 +413,7 @@ func TestRunChecks(t *testing.T) {
 			expect: E{
 				checkamount: Amount{Name: "test", Match: "pineapple", Days: 3, Expected: 65, Threshold: "20%", Rrule: ""},
 				notify:      "something OK",
-				err:         "notify failed",
+				err:         "test: notify failed",
 			},
 		},
 		{
@@ -395,6 +433,7 @@ func TestRunChecks(t *testing.T) {
 		t.Run(test.name, func(st *testing.T) {
 			var (
 				checkamount_params Amount
+				checkrepay_params  Repay
 				notify_params      string
 			)
 			viper.SetConfigType("yaml")
@@ -405,6 +444,10 @@ func TestRunChecks(t *testing.T) {
 				checkamount_params = Amount(p)
 				return test.fixture.checkamount.result, test.fixture.checkamount.err
 			})
+			monkey.Patch(CheckRepay, func(p Repay, c context.Context) (msg string, err error) {
+				checkrepay_params = p
+				return test.fixture.checkrepay.result, test.fixture.checkrepay.err
+			})
 			monkey.Patch(notify.Notify, func(message string, c context.Context) (sent int, err error) {
 				notify_params = message
 				return test.fixture.notify.sent, test.fixture.notify.err
@@ -421,110 +464,188 @@ func TestRunChecks(t *testing.T) {
 				}
 			}
 			assert.Equal(st, test.expect.checkamount, checkamount_params, "CheckAmount parameters")
+			assert.Equal(st, test.expect.checkrepay, checkrepay_params, "CheckRepay parameters")
 			assert.Equal(st, test.expect.notify, notify_params, "Notify paramaters")
 		})
 	}
 }
 
-// func TestCheckRepay(t *testing.T) {
-// 	monkey.Patch(time.Now, func() time.Time {
-// 		return time.Date(2000, time.January, 4, 0, 0, 0, 0, time.UTC)
-// 	})
-// 	defer monkey.UnpatchAll()
-
-// 	type A struct {
-// 		match string
-// 		from  string
-// 		to    string
-// 		days  int
-// 	}
-
-// 	type E struct {
-// 		err_message string
-// 		calls       int
-// 	}
-
-// 	test_data := []struct {
-// 		name            string
-// 		args            A
-// 		expected        string
-// 		expected_params []map[string]string
-// 		err             *E
-// 	}{
-// 		{
-// 			"Empty",
-// 			F{args: Amount{"NOTFOUND", "NotFound", "NotFound", -3},
-// 			"",
-// 			[]map[string]string{{"amount__lt": "0.00", "created__gt": "2000-01-07T00:00:00", "description__like": "NOTFOUND"}},
-// 			nil,
-// 		},
-// 		{
-// 			"Matches",
-// 			F{args: Amount{"WOOLWORTHS", "Food", "Spending", 3},
-// 			"Move money from Food to Spending:\n$2.22 from Sun 2 Jan\n$3.33 from Mon 3 Jan",
-// 			[]map[string]string{
-// 				{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
-// 				{"amount": "1.11", "created__gt": "2000-01-01T00:00:01", "description__like": "Food"},
-// 				{"amount": "2.22", "created__gt": "2000-01-02T00:00:01", "description__like": "Food"},
-// 				{"amount": "3.33", "created__gt": "2000-01-03T00:00:01", "description__like": "Food"},
-// 			},
-// 			nil,
-// 		},
-// 		{
-// 			"SingleMatch",
-// 			F{args: Amount{"WOOLWORTHS", "Food", "Spending", 1},
-// 			"Move money from Food to Spending:\n$3.33 from Mon 3 Jan",
-// 			[]map[string]string{
-// 				{"amount__lt": "0.00", "created__gt": "2000-01-03T00:00:00", "description__like": "WOOLWORTHS"},
-// 				{"amount": "3.33", "created__gt": "2000-01-03T00:00:01", "description__like": "Food"},
-// 			},
-// 			nil,
-// 		},
-// 		{
-// 			"ErrInitial",
-// 			F{args: Amount{"WOOLWORTHS", "Food", "Spending", 3},
-// 			"",
-// 			[]map[string]string{
-// 				{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
-// 			},
-// 			&E{"Failure", 0},
-// 		},
-// 		{
-// 			"ErrSubsequent",
-// 			F{args: Amount{"WOOLWORTHS", "Food", "Spending", 3},
-// 			"",
-// 			[]map[string]string{
-// 				{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
-// 				{"amount": "1.11", "created__gt": "2000-01-01T00:00:01", "description__like": "Food"},
-// 			},
-// 			&E{"Failure", 1},
-// 		},
-// 	}
-
-// 	for _, td := range test_data {
-// 		t.Run(td.name, func(s *testing.T) {
-// 			var called []map[string]string
-// 			monkey.Patch(QueryBackend, func(p map[string]string) (a APIResponse, err error) {
-// 				called = append(called, p)
-// 				if td.err != nil && len(called) > td.err.calls {
-// 					err = errors.New(td.err.err_message)
-// 					return a, err
-// 				}
-// 				a.Data = FilterTransactions(p)
-// 				return
-// 			})
-// 			defer monkey.Unpatch(QueryBackend)
-
-// 			result, err := CheckRepay(Repay{Match: td.args.match, Days: td.args.days, From: td.args.from, To: td.args.to})
-// 			if td.err == nil {
-// 				assert.Nil(s, err)
-// 				assert.Equal(s, td.expected, result)
-// 				assert.Equal(s, td.expected_params, called, "Incorrect QueryBackend params")
-// 				return
-// 			}
-// 			assert.NotNil(s, err)
-// 			assert.Equal(s, td.err.err_message, err.Error())
-// 			assert.Equal(s, td.expected_params, called, "Incorrect QueryBackend params")
-// 		})
-// 	}
-// }
+func TestCheckRepay(t *testing.T) {
+	transactions := []APITransaction{
+		{Id: 1, Description: "WOOLWORTHS 1234", Amount: -1.11, Created: time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)},
+		{Id: 2, Description: "Transfer from Food", Amount: 1.11, Created: time.Date(2000, time.January, 1, 13, 0, 0, 0, time.UTC)},
+		{Id: 3, Description: "WOOLWORTHS 1234", Amount: -2.22, Created: time.Date(2000, time.January, 2, 12, 0, 0, 0, time.UTC)},
+		{Id: 4, Description: "Transfer from Food", Amount: 3.33, Created: time.Date(2000, time.January, 2, 13, 0, 0, 0, time.UTC)},
+		{Id: 5, Description: "WOOLWORTHS 1234", Amount: -3.33, Created: time.Date(2000, time.January, 3, 12, 0, 0, 0, time.UTC)},
+	}
+
+	type F struct {
+		args         Repay
+		transactions []APITransaction
+		failures     int
+	}
+	type E struct {
+		result string
+		err    string
+		params []map[string]string
+	}
+
+	test_data := []struct {
+		name     string
+		fixture  F
+		expected E
+	}{
+		{
+			"Empty",
+			F{args: Repay{Match: "NOTFOUND", From: "NotFound", To: "NotFound", Days: 3}},
+			E{
+				params: []map[string]string{
+					{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "NOTFOUND"},
+				},
+			},
+		},
+		{
+			"Matches",
+			F{args: Repay{Match: "WOOLWORTHS", From: "Food", To: "Spending", Days: 3}},
+			E{
+				result: "Move money from Food to Spending:\n$2.22 from Sun 2 Jan\n$3.33 from Mon 3 Jan",
+				params: []map[string]string{
+					{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
+					{"amount": "1.11", "created__gt": "2000-01-01T12:00:00", "description__like": "Food"},
+					{"amount": "2.22", "created__gt": "2000-01-02T12:00:00", "description__like": "Food"},
+					{"amount": "3.33", "created__gt": "2000-01-03T12:00:00", "description__like": "Food"},
+				},
+			},
+		},
+		{
+			"SingleMatch",
+			F{args: Repay{Match: "WOOLWORTHS", From: "Food", To: "Spending", Days: 1}},
+			E{
+				result: "Move money from Food to Spending:\n$3.33 from Mon 3 Jan",
+				params: []map[string]string{
+					{"amount__lt": "0.00", "created__gt": "2000-01-03T00:00:00", "description__like": "WOOLWORTHS"},
+					{"amount": "3.33", "created__gt": "2000-01-03T12:00:00", "description__like": "Food"},
+				},
+			},
+		},
+		{
+			"AllRepaid",
+			F{args: Repay{Match: "WOOLWORTHS", From: "Food", To: "Spending", Days: 3}, transactions: transactions[:2]},
+			E{
+				params: []map[string]string{
+					{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
+					{"amount": "1.11", "created__gt": "2000-01-01T12:00:00", "description__like": "Food"},
+				},
+			},
+		},
+		{
+			"EqualDebits",
+			F{
+				args: Repay{Match: "WOOLWORTHS", From: "Food", To: "Spending", Days: 3},
+				transactions: []APITransaction{
+					{Id: 1, Description: "WOOLWORTHS 1234", Amount: -5.55, Created: time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)},
+					{Id: 2, Description: "WOOLWORTHS 1234", Amount: -5.55, Created: time.Date(2000, time.January, 2, 12, 0, 0, 0, time.UTC)},
+					{Id: 3, Description: "Transfer from Food", Amount: 5.55, Created: time.Date(2000, time.January, 2, 13, 0, 0, 0, time.UTC)},
+				},
+			},
+			E{
+				result: "Move money from Food to Spending:\n$5.55 from Sun 2 Jan",
+				params: []map[string]string{
+					{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
+					{"amount": "5.55", "created__gt": "2000-01-01T12:00:00", "description__like": "Food"},
+					{"amount": "5.55", "created__gt": "2000-01-02T12:00:00", "description__like": "Food"},
+				},
+			},
+		},
+		{
+			"ErrInitial",
+			F{args: Repay{Match: "WOOLWORTHS", From: "Food", To: "Spending", Days: 3}, failures: 1},
+			E{
+				err: "failure",
+				params: []map[string]string{
+					{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
+				},
+			},
+		},
+		{
+			"ErrSubsequent",
+			F{args: Repay{Match: "WOOLWORTHS", From: "Food", To: "Spending", Days: 3}, failures: 2},
+			E{
+				err: "failure",
+				params: []map[string]string{
+					{"amount__lt": "0.00", "created__gt": "2000-01-01T00:00:00", "description__like": "WOOLWORTHS"},
+					{"amount": "1.11", "created__gt": "2000-01-01T12:00:00", "description__like": "Food"},
+				},
+			},
+		},
+	}
+
+	for _, test := range test_data {
+		t.Run(test.name, func(tt *testing.T) {
+			defer monkey.UnpatchAll()
+			monkey.Patch(time.Now, func() time.Time {
+				return time.Date(2000, time.January, 4, 0, 0, 0, 0, time.UTC)
+			})
+
+			fixtures := transactions
+			if test.fixture.transactions != nil {
+				fixtures = test.fixture.transactions
+			}
+
+			var called []map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p := map[string]string{}
+				for k, v := range r.URL.Query() {
+					p[k] = v[0]
+				}
+				called = append(called, p)
+				if len(called) == test.fixture.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte("Failure"))
+					return
+				}
+				json.NewEncoder(w).Encode(APIResponse{Data: filterTransactions(fixtures, p)})
+			}))
+			defer server.Close()
+			viper.Set("backend", server.URL)
+			httpClient = server.Client()
+
+			result, err := CheckRepay(test.fixture.args, context.Background())
+			assert.Equal(tt, test.expected.result, result)
+			assert.Equal(tt, test.expected.params, called, "Incorrect QueryBackend params")
+			if test.expected.err == "" {
+				assert.Nil(tt, err)
+			} else {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.expected.err, err.Error())
+				}
+			}
+		})
+	}
+}
+
+// filterTransactions applies the subset of the backend filter grammar used by
+// the checks to a fixture list of transactions.
+func filterTransactions(transactions []APITransaction, params map[string]string) (result []APITransaction) {
+	for _, t := range transactions {
+		match := true
+		for k, v := range params {
+			switch k {
+			case "description__like":
+				match = match && strings.Contains(strings.ToLower(t.Description), strings.ToLower(v))
+			case "created__gt":
+				d, _ := time.Parse("2006-01-02T15:04:05", v)
+				match = match && t.Created.After(d)
+			case "amount__lt":
+				f, _ := strconv.ParseFloat(v, 64)
+				match = match && t.Amount < f
+			case "amount":
+				match = match && fmt.Sprintf("%0.2f", t.Amount) == v
+			}
+		}
+		if match {
+			result = append(result, t)
+		}
+	}
+	return
+}
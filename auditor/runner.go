@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// Checks run at once unless `concurrency` says otherwise
+	DefaultConcurrency = 4
+	// How long a check may run without its own `timeout`, or a top level one
+	DefaultTimeout = "30s"
+)
+
+// CheckError is a check that couldn't run
+type CheckError struct {
+	Name string
+	Err  error
+}
+
+// CheckErrors collects every check that couldn't run, rather than stopping
+// at the first
+type CheckErrors []CheckError
+
+func (e CheckErrors) Error() string {
+	failures := []string{}
+	for _, f := range e {
+		failures = append(failures, fmt.Sprintf("%s: %s", f.Name, f.Err))
+	}
+	return strings.Join(failures, "; ")
+}
+
+// Summary is the message notified for the failed checks
+func (e CheckErrors) Summary() string {
+	msg := "Unable to run checks:"
+	for _, f := range e {
+		msg = fmt.Sprintf("%s\n%s: %s", msg, f.Name, f.Err)
+	}
+	return msg
+}
+
+// label names a check in reports, falling back to its type
+func (c Check) label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+// concurrency is the number of checks run at once
+func concurrency() int {
+	if n := viper.GetInt("concurrency"); n > 0 {
+		return n
+	}
+	return DefaultConcurrency
+}
+
+// checkTimeout is how long check may run for
+func checkTimeout(check Check) (time.Duration, error) {
+	timeout := check.Timeout
+	if timeout == "" {
+		timeout = viper.GetString("timeout")
+	}
+	if timeout == "" {
+		timeout = DefaultTimeout
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout '%s'", timeout)
+	}
+	return d, nil
+}
+
+// RunCheckTimeout runs check like RunCheck, giving up once timeout passes
+// even when the check doesn't honour its context
+func RunCheckTimeout(ctx context.Context, i int, check Check, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		message string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		message, err := RunCheck(ctx, i, check)
+		done <- result{message, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return r.message, r.err
+		}
+	case <-ctx.Done():
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", ctx.Err()
+		}
+	}
+	return "", fmt.Errorf("timed out after %s", timeout)
+}
+
+// RunPool calls run with 0 to n-1, at most workers at a time, and waits for
+// them all
+func RunPool(n, workers int, run func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				run(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
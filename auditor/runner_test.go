@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/codingric/moneyman/pkg/notify"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPool(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	ran := make([]bool, 10)
+	RunPool(len(ran), 3, func(i int) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		ran[i] = true
+		mu.Unlock()
+	})
+	assert.Equal(t, 3, most)
+	assert.Equal(t, []bool{true, true, true, true, true, true, true, true, true, true}, ran)
+
+	RunPool(0, 3, func(i int) { t.Error("nothing to run") })
+}
+
+func TestCheckTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		check   Check
+		timeout time.Duration
+		err     string
+	}{
+		{"Default", "", Check{}, 30 * time.Second, ""},
+		{"TopLevel", "timeout: 1m", Check{}, time.Minute, ""},
+		{"Check", "timeout: 1m", Check{Timeout: "5s"}, 5 * time.Second, ""},
+		{"Invalid", "", Check{Timeout: "soon"}, 0, "invalid timeout 'soon'"},
+		{"Zero", "", Check{Timeout: "0s"}, 0, "invalid timeout '0s'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			viper.Reset()
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewBufferString(test.config))
+
+			timeout, err := checkTimeout(test.check)
+			assert.Equal(tt, test.timeout, timeout)
+			if test.err == "" {
+				assert.NoError(tt, err)
+			} else if assert.Error(tt, err) {
+				assert.Equal(tt, test.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunCheckTimeout(t *testing.T) {
+	defer monkey.UnpatchAll()
+	monkey.Patch(RunCheck, func(ctx context.Context, i int, check Check) (string, error) {
+		if check.Name == "slow" {
+			// Ignores ctx on purpose
+			time.Sleep(100 * time.Millisecond)
+		}
+		return "done", nil
+	})
+
+	message, err := RunCheckTimeout(context.Background(), 0, Check{Name: "fast"}, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "done", message)
+
+	message, err = RunCheckTimeout(context.Background(), 0, Check{Name: "slow"}, 10*time.Millisecond)
+	if assert.Error(t, err) {
+		assert.Equal(t, "timed out after 10ms", err.Error())
+	}
+	assert.Equal(t, "", message)
+}
+
+func TestRunChecksAggregates(t *testing.T) {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.ReadConfig(bytes.NewBufferString(`concurrency: 2
+checks:
+  - {name: AHM, type: amount}
+  - {name: AWS, type: amount, timeout: 10ms}
+  - {name: Gowrie, type: amount}
+  - {name: Helen, type: amount}`))
+
+	var mu sync.Mutex
+	notified := []string{}
+	defer monkey.UnpatchAll()
+	monkey.Patch(RunCheck, func(ctx context.Context, i int, check Check) (string, error) {
+		switch check.Name {
+		case "AHM":
+			return "", errors.New("backend down")
+		case "AWS":
+			<-ctx.Done()
+			return "", ctx.Err()
+		case "Gowrie":
+			return "Payment for Gowrie overdue", nil
+		}
+		return "", nil
+	})
+	monkey.Patch(notify.Notify, func(message string, ctx context.Context) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, message)
+		return 1, nil
+	})
+
+	err := RunChecks(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, "AHM: backend down; AWS: timed out after 10ms", err.Error())
+	}
+	assert.Equal(t, []string{
+		"Payment for Gowrie overdue",
+		"Unable to run checks:\nAHM: backend down\nAWS: timed out after 10ms",
+	}, notified)
+}
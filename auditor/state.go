@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+
+	// Results kept per check, older ones are dropped
+	MaxResults = 100
+)
+
+var (
+	checksBucket  = []byte("checks")
+	resultsBucket = []byte("results")
+)
+
+// State is what the daemon remembers about a check between runs
+type State struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Schedule   string     `json:"schedule"`
+	Status     string     `json:"status,omitempty"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	NextRun    *time.Time `json:"next_run,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	LastAlert  *time.Time `json:"last_alert,omitempty"`
+}
+
+// Result of a single run of a check
+type Result struct {
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status"`
+	Message  string    `json:"message,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Notified bool      `json:"notified,omitempty"`
+}
+
+// transition moves the state on given the message of a run at now, returning
+// what to notify if anything. A check fires when it has a message and
+// resolves when it no longer has one. Firing is notified once, then again
+// every remind while it keeps firing (never when remind is 0), and resolving
+// is notified with resolved. Notices stay due until LastAlert records them
+// as sent.
+func (s *State) transition(now time.Time, message string, remind time.Duration, resolved string) string {
+	switch {
+	case message != "":
+		if s.Status != StatusFiring {
+			s.Status, s.FiredAt, s.ResolvedAt = StatusFiring, &now, nil
+		}
+		if s.LastAlert == nil || s.LastAlert.Before(*s.FiredAt) {
+			return message
+		}
+		if remind > 0 && now.Sub(*s.LastAlert) >= remind {
+			return message
+		}
+	case s.Status == StatusFiring && (s.LastAlert == nil || s.LastAlert.Before(*s.FiredAt)):
+		// Nobody heard it fire, so there's nothing to resolve
+		s.Status = StatusOK
+	case s.Status == StatusFiring:
+		s.Status, s.ResolvedAt = StatusResolved, &now
+		return resolved
+	case s.Status == StatusResolved && s.LastAlert != nil && s.LastAlert.Before(*s.ResolvedAt):
+		return resolved
+	default:
+		s.Status = StatusOK
+	}
+	return ""
+}
+
+// Store keeps the state and recent results of every check in a local bolt
+// database
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens the store at path, creating it if missing
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening state %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{checksBucket, resultsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get the state of the named check, empty when it never ran
+func (s *Store) Get(name string) (state State, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(checksBucket).Get([]byte(name))
+		if v == nil {
+			state.Name = name
+			return nil
+		}
+		return json.Unmarshal(v, &state)
+	})
+	return
+}
+
+// Put saves the state of a check
+func (s *Store) Put(state State) error {
+	v, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checksBucket).Put([]byte(state.Name), v)
+	})
+}
+
+// AddResult records a run of the named check, keeping the last MaxResults
+func (s *Store) AddResult(name string, result Result) error {
+	v, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(resultsBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, v); err != nil {
+			return err
+		}
+		var keys [][]byte
+		b.ForEach(func(k, _ []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		})
+		for i := 0; i < len(keys)-MaxResults; i++ {
+			if err := b.Delete(keys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Results of the named check, oldest first
+func (s *Store) Results(name string) (results []Result, err error) {
+	results = []Result{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultsBucket).Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var result Result
+			if err := json.Unmarshal(v, &result); err != nil {
+				return err
+			}
+			results = append(results, result)
+			return nil
+		})
+	})
+	return
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "state.db"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.Close()
+
+	state, err := store.Get("AHM")
+	assert.NoError(t, err)
+	assert.Equal(t, State{Name: "AHM"}, state)
+
+	run := time.Date(2000, time.January, 4, 9, 0, 0, 0, time.UTC)
+	next := run.Add(time.Hour)
+	saved := State{Name: "AHM", Type: "amount", Schedule: "@hourly", LastRun: &run, NextRun: &next, Message: "Payment overdue"}
+	assert.NoError(t, store.Put(saved))
+
+	state, err = store.Get("AHM")
+	assert.NoError(t, err)
+	assert.Equal(t, saved, state)
+
+	results, err := store.Results("AHM")
+	assert.NoError(t, err)
+	assert.Equal(t, []Result{}, results)
+	for i := 0; i < MaxResults+5; i++ {
+		assert.NoError(t, store.AddResult("AHM", Result{Time: run.Add(time.Duration(i) * time.Hour), Status: StatusOK}))
+	}
+	results, err = store.Results("AHM")
+	assert.NoError(t, err)
+	if assert.Len(t, results, MaxResults) {
+		assert.Equal(t, run.Add(5*time.Hour), results[0].Time)
+		assert.Equal(t, run.Add((MaxResults+4)*time.Hour), results[MaxResults-1].Time)
+	}
+
+	_, err = OpenStore("/nonexistant/state.db")
+	if assert.Error(t, err) {
+		assert.Equal(t, "opening state /nonexistant/state.db: open /nonexistant/state.db: no such file or directory", err.Error())
+	}
+}
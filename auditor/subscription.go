@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codingric/moneyman/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/teambition/rrule-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// History looked through, long enough to catch two yearly charges
+	DefaultSubscriptionDays = 400
+	// Charges before a merchant counts as recurring, yearly ones only need two
+	DefaultSubscriptionCount = 3
+	// Days a charge may come early or late
+	DefaultSubscriptionTolerance = 3
+	// How far a price may move before it's reported
+	DefaultSubscriptionThreshold = "10%"
+)
+
+type Subscription struct {
+	Name string `mapstructure:"name"`
+	// Only look at charges matching, every charge when empty
+	Match     string `mapstructure:"match"`
+	Days      int    `mapstructure:"days"`
+	Count     int    `mapstructure:"count"`
+	Tolerance int    `mapstructure:"tolerance"`
+	Threshold string `mapstructure:"threshold"`
+	// Descriptions never reported
+	Ignore []string `mapstructure:"ignore"`
+}
+
+// Recurring is a merchant charged at a regular interval for a stable amount
+type Recurring struct {
+	Description string
+	Match       string
+	// Oldest first
+	Charges []APITransaction
+	// Days between charges
+	Interval int
+	Rrule    string
+	// When the next charge is due
+	Next time.Time
+}
+
+var (
+	notWord = regexp.MustCompile(`[^a-z0-9]+`)
+	digits  = regexp.MustCompile(`[0-9]`)
+)
+
+// merchant is the part of a description that stays the same from charge to
+// charge, dropping references, card numbers and punctuation
+func merchant(description string) string {
+	words := []string{}
+	for _, w := range notWord.Split(strings.ToLower(description), -1) {
+		if w != "" && !digits.MatchString(w) {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func (c *Subscription) defaults() {
+	if c.Days <= 0 {
+		c.Days = DefaultSubscriptionDays
+	}
+	if c.Count <= 0 {
+		c.Count = DefaultSubscriptionCount
+	}
+	if c.Tolerance <= 0 {
+		c.Tolerance = DefaultSubscriptionTolerance
+	}
+	if c.Threshold == "" {
+		c.Threshold = DefaultSubscriptionThreshold
+	}
+}
+
+// FindRecurring mines the backend's charges for recurring merchants, leaving
+// out those an `amount` check already covers and those ignored
+func FindRecurring(c Subscription, ctx context.Context) (found []Recurring, err error) {
+	ctx, span := tracing.NewSpan("FindRecurring", ctx)
+	defer span.End()
+
+	c.defaults()
+	params := map[string]string{
+		"created__gt": time.Now().AddDate(0, 0, -c.Days).Format("2006-01-02T15:04:05"),
+		"amount__lt":  "0.00",
+	}
+	if c.Match != "" {
+		params["description__like"] = c.Match
+	}
+	response, err := QueryBackend(params, ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query backend")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to query backend")
+		return nil, err
+	}
+
+	covered := []string{}
+	for _, a := range amountChecks() {
+		if a.Match != "" {
+			covered = append(covered, strings.ToLower(a.Match))
+		}
+	}
+	for _, i := range c.Ignore {
+		covered = append(covered, strings.ToLower(i))
+	}
+
+	groups := map[string][]APITransaction{}
+	for _, t := range response.Data {
+		if key := merchant(t.Description); key != "" {
+			groups[key] = append(groups[key], t)
+		}
+	}
+
+	found = []Recurring{}
+	for _, charges := range groups {
+		sort.SliceStable(charges, func(i, j int) bool { return charges[i].Created.Before(charges[j].Created) })
+		r, ok := recurring(charges, c)
+		if !ok || isCovered(r.Description, covered) {
+			continue
+		}
+		found = append(found, r)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Description < found[j].Description })
+	span.SetAttributes(attribute.Int("recurring", len(found)))
+	return found, nil
+}
+
+func isCovered(description string, matches []string) bool {
+	description = strings.ToLower(description)
+	for _, m := range matches {
+		if strings.Contains(description, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// recurring decides whether the charges, oldest first, are regular enough
+// to be a subscription. Every gap must be within Tolerance days of the usual
+// one, which must be at least a week, or of a whole number of them when
+// charges were missed, and the price may change but on average only once
+// every Count charges.
+func recurring(charges []APITransaction, c Subscription) (r Recurring, ok bool) {
+	if len(charges) < 2 {
+		return
+	}
+
+	gaps := []int{}
+	for i := 1; i < len(charges); i++ {
+		gaps = append(gaps, int(math.Round(charges[i].Created.Sub(charges[i-1].Created).Hours()/24)))
+	}
+	shortest := gaps[0]
+	for _, gap := range gaps {
+		if gap < shortest {
+			shortest = gap
+		}
+	}
+	if shortest < 7 {
+		return
+	}
+	// Gaps spanning missed charges are split before taking the median
+	per := []float64{}
+	for _, gap := range gaps {
+		per = append(per, float64(gap)/math.Round(float64(gap)/float64(shortest)))
+	}
+	sort.Float64s(per)
+	interval := int(math.Round(per[len(per)/2]))
+	for _, gap := range gaps {
+		n := math.Round(float64(gap) / float64(interval))
+		if n < 1 || math.Abs(float64(gap)-n*float64(interval)) > float64(c.Tolerance) {
+			return
+		}
+	}
+	if len(charges) < c.needed(interval) {
+		return
+	}
+
+	changes := 0
+	for i := 1; i < len(charges); i++ {
+		if priceChanged(charges[i-1].Amount, charges[i].Amount, c.Threshold) {
+			changes++
+		}
+	}
+	if changes*c.Count > len(charges)-1 {
+		return
+	}
+
+	last := charges[len(charges)-1]
+	start := time.Date(last.Created.Year(), last.Created.Month(), last.Created.Day(), 0, 0, 0, 0, time.UTC)
+	rule := recurringRrule(start, interval, c.Tolerance)
+	rr, err := rrule.StrToRRule(rule)
+	if err != nil {
+		return
+	}
+
+	return Recurring{
+		Description: last.Description,
+		Match:       matchFor(charges),
+		Charges:     charges,
+		Interval:    interval,
+		Rrule:       rule,
+		Next:        rr.After(start, false),
+	}, true
+}
+
+// needed is the number of charges every interval days before they count as
+// recurring, two for yearly charges as a third would take years to see
+func (c Subscription) needed(interval int) int {
+	if interval >= 365-c.Tolerance && c.Count > 2 {
+		return 2
+	}
+	return c.Count
+}
+
+func priceChanged(from, to float64, t string) bool {
+	return math.Abs(to-from) > threshold(t, from)
+}
+
+// recurringRrule describes charges every interval days from start, in
+// months or years when the interval is near enough to them
+func recurringRrule(start time.Time, interval, tolerance int) string {
+	dtstart := start.Format("20060102T150405Z")
+	if interval%7 == 0 {
+		return fmt.Sprintf("FREQ=WEEKLY;INTERVAL=%d;DTSTART=%s", interval/7, dtstart)
+	}
+	if years := math.Round(float64(interval) / 365.25); years >= 1 && math.Abs(float64(interval)-years*365.25) <= float64(tolerance) {
+		return fmt.Sprintf("FREQ=YEARLY;INTERVAL=%d;DTSTART=%s", int(years), dtstart)
+	}
+	if months := math.Round(float64(interval) / 30.44); months >= 1 && math.Abs(float64(interval)-months*30.44) <= float64(tolerance) {
+		return fmt.Sprintf("FREQ=MONTHLY;INTERVAL=%d;DTSTART=%s", int(months), dtstart)
+	}
+	return fmt.Sprintf("FREQ=DAILY;INTERVAL=%d;DTSTART=%s", interval, dtstart)
+}
+
+const separators = " *#-/."
+
+// matchFor is the start every charge's description shares, without any
+// trailing reference, or the latest description when they share nothing
+func matchFor(charges []APITransaction) string {
+	prefix := charges[0].Description
+	for _, t := range charges[1:] {
+		for !strings.HasPrefix(t.Description, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	// Don't stop part way through a word
+	for _, t := range charges {
+		if len(t.Description) > len(prefix) && !strings.ContainsAny(t.Description[len(prefix):len(prefix)+1], separators) {
+			prefix = prefix[:strings.LastIndexAny(prefix, separators)+1]
+			break
+		}
+	}
+	prefix = strings.TrimRight(prefix, separators+"0123456789")
+	if merchant(prefix) == "" {
+		return charges[len(charges)-1].Description
+	}
+	return prefix
+}
+
+// CheckSubscription alerts when a recurring charge is skipped, changes
+// price by more than the threshold, or first appears
+func CheckSubscription(c Subscription, ctx context.Context) (msg string, err error) {
+	ctx, span := tracing.NewSpan("CheckSubscription", ctx)
+	defer span.End()
+
+	c.defaults()
+	span.SetAttributes(
+		attribute.String("check.name", c.Name),
+		attribute.String("check.match", c.Match),
+		attribute.String("check.threshold", c.Threshold),
+		attribute.Int("check.days", c.Days),
+		attribute.Int("check.count", c.Count),
+		attribute.Int("check.tolerance", c.Tolerance),
+	)
+
+	found, err := FindRecurring(c, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to find subscriptions")
+		return "", err
+	}
+
+	now := time.Now()
+	alerts := []string{}
+	for _, r := range found {
+		last := r.Charges[len(r.Charges)-1]
+		late := r.Next.AddDate(0, 0, c.Tolerance)
+		switch {
+		case now.After(late.AddDate(0, 0, r.Interval)):
+			// Missed twice, so it was cancelled
+		case now.After(late):
+			alerts = append(alerts, fmt.Sprintf(
+				"%s %s skipped, expected %s",
+				r.Description,
+				dollars(-last.Amount),
+				r.Next.Format("Mon 2 Jan"),
+			))
+		case priceChanged(r.Charges[len(r.Charges)-2].Amount, last.Amount, c.Threshold):
+			alerts = append(alerts, fmt.Sprintf(
+				"%s price changed from %s to %s on %s",
+				r.Description,
+				dollars(-r.Charges[len(r.Charges)-2].Amount),
+				dollars(-last.Amount),
+				last.Created.Format("Mon 2 Jan"),
+			))
+		case len(r.Charges) == c.needed(r.Interval):
+			alerts = append(alerts, fmt.Sprintf(
+				"%s new %s every %d days",
+				r.Description,
+				dollars(-last.Amount),
+				r.Interval,
+			))
+		}
+	}
+
+	if len(alerts) > 0 {
+		msg = "Subscriptions:\n" + strings.Join(alerts, "\n")
+		span.SetAttributes(attribute.String("result", msg))
+	}
+	return
+}
+
+// SuggestChecks writes an `amount` check for every recurring charge the
+// `subscription` checks find, ready to paste into the config's checks
+func SuggestChecks(ctx context.Context, w io.Writer) error {
+	ctx, span := tracing.NewSpan("SuggestChecks", ctx)
+	defer span.End()
+
+	var checks Checks
+	viper.UnmarshalKey("checks", &checks)
+	for i, check := range checks {
+		if check.Type != "subscription" {
+			continue
+		}
+		var c Subscription
+		viper.UnmarshalKey(fmt.Sprintf("checks.%d", i), &c)
+		c.defaults()
+		found, err := FindRecurring(c, ctx)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		for _, r := range found {
+			fmt.Fprintf(w, "  - type: amount\n")
+			fmt.Fprintf(w, "    name: %q\n", r.Match)
+			fmt.Fprintf(w, "    expected: %0.2f\n", r.Charges[len(r.Charges)-1].Amount)
+			fmt.Fprintf(w, "    threshold: %s\n", c.Threshold)
+			fmt.Fprintf(w, "    days: %d\n", c.Tolerance)
+			fmt.Fprintf(w, "    match: %q\n", r.Match)
+			fmt.Fprintf(w, "    rrule: %s\n", r.Rrule)
+		}
+	}
+	return nil
+}
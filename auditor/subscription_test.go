@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func charge(description string, amount float64, year int, month time.Month, day int) APITransaction {
+	return APITransaction{Description: description, Amount: amount, Created: time.Date(year, month, day, 9, 0, 0, 0, time.UTC)}
+}
+
+var subscriptionCharges = []APITransaction{
+	charge("NETFLIX.COM 1234", -16.99, 1999, time.September, 12),
+	charge("NETFLIX.COM 2345", -16.99, 1999, time.October, 12),
+	charge("NETFLIX.COM 3456", -16.99, 1999, time.November, 12),
+	charge("NETFLIX.COM 4567", -16.99, 1999, time.December, 12),
+	charge("SPOTIFY P0A1", -11.99, 1999, time.October, 2),
+	charge("SPOTIFY P0B2", -11.99, 1999, time.November, 2),
+	charge("SPOTIFY P0C3", -11.99, 1999, time.December, 2),
+	charge("SPOTIFY P0D4", -13.99, 2000, time.January, 2),
+	charge("GYM", -30, 1999, time.November, 1),
+	charge("GYM", -30, 1999, time.November, 15),
+	charge("GYM", -30, 1999, time.November, 29),
+	charge("GYM", -30, 1999, time.December, 13),
+	charge("DISNEY PLUS", -13.99, 1999, time.October, 20),
+	charge("DISNEY PLUS", -13.99, 1999, time.November, 20),
+	charge("DISNEY PLUS", -13.99, 1999, time.December, 20),
+	charge("HOYTS", -20, 1999, time.August, 5),
+	charge("HOYTS", -20, 1999, time.September, 5),
+	charge("HOYTS", -20, 1999, time.October, 5),
+	charge("AHM 1", -387.91, 1999, time.October, 12),
+	charge("AHM 2", -387.91, 1999, time.November, 12),
+	charge("AHM 3", -387.91, 1999, time.December, 12),
+	charge("WOOLWORTHS 1", -85.20, 1999, time.November, 6),
+	charge("WOOLWORTHS 2", -12.50, 1999, time.November, 13),
+	charge("WOOLWORTHS 3", -143.10, 1999, time.November, 20),
+	charge("WOOLWORTHS 4", -64.00, 1999, time.November, 27),
+}
+
+const subscriptionConfig = `checks:
+  - name: AHM
+    type: amount
+    match: AHM
+  - name: Subscriptions
+    type: subscription
+    ignore:
+      - hoyts`
+
+func TestCheckSubscription(t *testing.T) {
+	type F struct {
+		args    Subscription
+		charges []APITransaction
+		err     error
+	}
+	type E struct {
+		result string
+		err    string
+		params map[string]string
+	}
+
+	test_data := []struct {
+		name     string
+		fixture  F
+		expected E
+	}{
+		{
+			"Alerts",
+			F{args: Subscription{Name: "Subscriptions"}, charges: subscriptionCharges},
+			E{
+				result: "Subscriptions:\nDISNEY PLUS new $13.99 every 31 days\nGYM $30.00 skipped, expected Mon 27 Dec\nSPOTIFY P0D4 price changed from $11.99 to $13.99 on Sun 2 Jan",
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1998-11-30T00:00:00"},
+			},
+		},
+		{
+			"Threshold",
+			F{args: Subscription{Name: "Subscriptions", Threshold: "$2"}, charges: subscriptionCharges},
+			E{
+				result: "Subscriptions:\nDISNEY PLUS new $13.99 every 31 days\nGYM $30.00 skipped, expected Mon 27 Dec",
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1998-11-30T00:00:00"},
+			},
+		},
+		{
+			"Ignore",
+			F{args: Subscription{Name: "Subscriptions", Ignore: []string{"Disney", "gym"}}, charges: subscriptionCharges},
+			E{
+				result: "Subscriptions:\nSPOTIFY P0D4 price changed from $11.99 to $13.99 on Sun 2 Jan",
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1998-11-30T00:00:00"},
+			},
+		},
+		{
+			"Yearly",
+			F{args: Subscription{Name: "Subscriptions"}, charges: []APITransaction{
+				charge("AMAZON PRIME", -79, 1998, time.December, 20),
+				charge("AMAZON PRIME", -79, 1999, time.December, 20),
+			}},
+			E{
+				result: "Subscriptions:\nAMAZON PRIME new $79.00 every 365 days",
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1998-11-30T00:00:00"},
+			},
+		},
+		{
+			"Missed",
+			F{args: Subscription{Name: "Subscriptions"}, charges: []APITransaction{
+				charge("STAN", -10, 1999, time.August, 15),
+				charge("STAN", -10, 1999, time.September, 15),
+				charge("STAN", -10, 1999, time.October, 15),
+				charge("STAN", -12, 1999, time.December, 15),
+			}},
+			E{
+				result: "Subscriptions:\nSTAN price changed from $10.00 to $12.00 on Wed 15 Dec",
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1998-11-30T00:00:00"},
+			},
+		},
+		{
+			"Match",
+			F{args: Subscription{Name: "Subscriptions", Match: "NETFLIX", Days: 180}, charges: subscriptionCharges[:4]},
+			E{
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1999-07-08T00:00:00", "description__like": "NETFLIX"},
+			},
+		},
+		{
+			"Cancelled",
+			F{args: Subscription{Name: "Subscriptions"}, charges: subscriptionCharges[15:18]},
+			E{
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1998-11-30T00:00:00"},
+			},
+		},
+		{
+			"QueryError",
+			F{args: Subscription{Name: "Subscriptions"}, err: errors.New("something went wrong")},
+			E{
+				err:    "something went wrong",
+				params: map[string]string{"amount__lt": "0.00", "created__gt": "1998-11-30T00:00:00"},
+			},
+		},
+	}
+
+	for _, test := range test_data {
+		t.Run(test.name, func(tt *testing.T) {
+			defer monkey.UnpatchAll()
+			var called map[string]string
+			monkey.Patch(QueryBackend, func(p map[string]string, c context.Context) (a APIResponse, err error) {
+				called = map[string]string{}
+				for k, v := range p {
+					called[k] = v
+				}
+				return APIResponse{Data: append([]APITransaction{}, test.fixture.charges...)}, test.fixture.err
+			})
+			monkey.Patch(time.Now, func() time.Time {
+				return time.Date(2000, time.January, 4, 0, 0, 0, 0, time.UTC)
+			})
+			viper.Reset()
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewBufferString(subscriptionConfig))
+
+			result, err := CheckSubscription(test.fixture.args, context.Background())
+			assert.Equal(tt, test.expected.result, result)
+			assert.Equal(tt, test.expected.params, called)
+			if test.expected.err == "" {
+				assert.Nil(tt, err)
+			} else {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.expected.err, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestChecks(t *testing.T) {
+	defer monkey.UnpatchAll()
+	monkey.Patch(QueryBackend, func(p map[string]string, c context.Context) (a APIResponse, err error) {
+		return APIResponse{Data: append([]APITransaction{}, subscriptionCharges...)}, nil
+	})
+	monkey.Patch(time.Now, func() time.Time {
+		return time.Date(2000, time.January, 4, 0, 0, 0, 0, time.UTC)
+	})
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.ReadConfig(bytes.NewBufferString(subscriptionConfig))
+
+	buf := bytes.NewBufferString("")
+	assert.NoError(t, SuggestChecks(context.Background(), buf))
+	assert.Equal(t, `  - type: amount
+    name: "DISNEY PLUS"
+    expected: -13.99
+    threshold: 10%
+    days: 3
+    match: "DISNEY PLUS"
+    rrule: FREQ=MONTHLY;INTERVAL=1;DTSTART=19991220T000000Z
+  - type: amount
+    name: "GYM"
+    expected: -30.00
+    threshold: 10%
+    days: 3
+    match: "GYM"
+    rrule: FREQ=WEEKLY;INTERVAL=2;DTSTART=19991213T000000Z
+  - type: amount
+    name: "NETFLIX.COM"
+    expected: -16.99
+    threshold: 10%
+    days: 3
+    match: "NETFLIX.COM"
+    rrule: FREQ=MONTHLY;INTERVAL=1;DTSTART=19991212T000000Z
+  - type: amount
+    name: "SPOTIFY"
+    expected: -13.99
+    threshold: 10%
+    days: 3
+    match: "SPOTIFY"
+    rrule: FREQ=MONTHLY;INTERVAL=1;DTSTART=20000102T000000Z
+`, buf.String())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm/clause"
+)
+
+// Category is a node of the category tree, e.g. Up's `restaurants-and-cafes`
+// under `good-life`. Transaction categories are matched on ID.
+type Category struct {
+	ID     string `json:"id" gorm:"primary_key"`
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty" gorm:"index"`
+	// Where the category was mirrored from, empty for our own
+	Source string `json:"source,omitempty"`
+}
+
+type SaveCategoriesInput struct {
+	Data []Category `json:"data" binding:"required"`
+}
+
+// GET /categories
+// Find all categories
+func FindCategories(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "category.FindCategories")
+	defer span.End()
+
+	var categories []Category
+	if err := DB.WithContext(ctx).Order("parent, id").Find(&categories).Error; err != nil {
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": categories})
+}
+
+// POST /categories
+// Create or update categories, e.g. to mirror Up's category tree
+func SaveCategories(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "category.SaveCategories")
+	defer span.End()
+
+	var input SaveCategoriesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid request parameters")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request parameters"})
+		return
+	}
+	for _, category := range input.Data {
+		msg := ""
+		switch {
+		case category.ID == "":
+			msg = "category missing id"
+		case category.Parent == category.ID:
+			msg = fmt.Sprintf("category %s is its own parent", category.ID)
+		}
+		if msg != "" {
+			span.SetStatus(codes.Error, msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+
+	if len(input.Data) > 0 {
+		if err := DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&input.Data).Error; err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to save categories")
+			log.Error().Caller().Err(err).Msg("Failed to save categories")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save categories"})
+			return
+		}
+	}
+	span.AddEvent("Categories saved", trace.WithAttributes(
+		attribute.Int("result.count", len(input.Data)),
+	))
+	c.JSON(http.StatusOK, gin.H{"data": input.Data})
+}
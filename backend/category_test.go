@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveCategories(t *testing.T) {
+	defer useDatabase()()
+
+	tests := []struct {
+		name        string
+		body        string
+		status_code int
+		expect      string
+	}{
+		{
+			"Invalid",
+			`{}`,
+			400,
+			`{"error":"Invalid request parameters"}`,
+		},
+		{
+			"MissingID",
+			`{"data":[{"name":"Good Life"}]}`,
+			400,
+			`{"error":"category missing id"}`,
+		},
+		{
+			"OwnParent",
+			`{"data":[{"id":"good-life","parent":"good-life"}]}`,
+			400,
+			`{"error":"category good-life is its own parent"}`,
+		},
+		{
+			"Created",
+			`{"data":[{"id":"good-life","name":"Good Life","source":"up"},{"id":"restaurants-and-cafes","name":"Restaurants","parent":"good-life","source":"up"}]}`,
+			200,
+			`{"data":[{"id":"good-life","name":"Good Life","source":"up"},{"id":"restaurants-and-cafes","name":"Restaurants","parent":"good-life","source":"up"}]}`,
+		},
+		{
+			"Updated",
+			`{"data":[{"id":"restaurants-and-cafes","name":"Restaurants and Cafes","parent":"good-life","source":"up"}]}`,
+			200,
+			`{"data":[{"id":"restaurants-and-cafes","name":"Restaurants and Cafes","parent":"good-life","source":"up"}]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/categories", bytes.NewBufferString(test.body))
+
+			SaveCategories(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/categories", nil)
+	FindCategories(c)
+	b, _ := ioutil.ReadAll(w.Body)
+	assert.Equal(t, `{"data":[{"id":"good-life","name":"Good Life","source":"up"},{"id":"restaurants-and-cafes","name":"Restaurants and Cafes","parent":"good-life","source":"up"}]}`, string(b))
+}
+
+func TestCreateTransactionSourceCategory(t *testing.T) {
+	defer useDatabase()()
+	DB.Create(&Rule{Pattern: "AHM", Category: "health"})
+
+	tests := []struct {
+		name   string
+		body   string
+		expect string
+	}{
+		{
+			"RuleWins",
+			`{"created":"2023-01-01T09:00:00+11:00","amount":"-387.91","description":"AHM","account":"1234","source":"up","external_id":"up-1","source_category":"insurance","source_parent_category":"personal","tags":["family"]}`,
+			`{"data":{"id":1,"description":"AHM","amount":-387.91,"currency":"AUD","account":1234,"created":"2023-01-01T09:00:00+11:00","category":"health","tags":["family"],"source":"up","external_id":"up-1","source_category":"insurance","source_parent_category":"personal"}}`,
+		},
+		{
+			"Fallback",
+			`{"created":"2023-01-02T09:00:00+11:00","amount":"-12.00","description":"CAFE","account":"1234","source":"up","external_id":"up-2","status":"HELD","raw_text":"CAFE 123 MELBOURNE","message":"lunch","source_category":"takeaway","source_parent_category":"good-life"}`,
+			`{"data":{"id":2,"description":"CAFE","amount":-12,"currency":"AUD","account":1234,"created":"2023-01-02T09:00:00+11:00","category":"takeaway","source":"up","external_id":"up-2","status":"HELD","raw_text":"CAFE 123 MELBOURNE","message":"lunch","source_category":"takeaway","source_parent_category":"good-life"}}`,
+		},
+		{
+			"SettledRecategorised",
+			`{"created":"2023-01-02T09:00:00+11:00","amount":"-12.00","description":"CAFE","account":"1234","source":"up","external_id":"up-2","status":"SETTLED","raw_text":"CAFE 123 MELBOURNE","source_category":"restaurants-and-cafes","source_parent_category":"good-life","tags":["work"]}`,
+			`{"data":{"id":2,"description":"CAFE","amount":-12,"currency":"AUD","account":1234,"created":"2023-01-02T09:00:00+11:00","category":"restaurants-and-cafes","tags":["work"],"source":"up","external_id":"up-2","status":"SETTLED","raw_text":"CAFE 123 MELBOURNE","source_category":"restaurants-and-cafes","source_parent_category":"good-life"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/transactions", bytes.NewBufferString(test.body))
+
+			CreateTransaction(c)
+
+			assert.Equal(st, 200, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+}
+
+func TestSummariseParentCategory(t *testing.T) {
+	defer useDatabase()()
+
+	DB.Create(&Category{ID: "good-life", Name: "Good Life"})
+	DB.Create(&Category{ID: "takeaway", Name: "Takeaway", Parent: "good-life"})
+	DB.Create(&Category{ID: "restaurants-and-cafes", Name: "Restaurants", Parent: "good-life"})
+	created := time.Date(2023, time.January, 1, 9, 0, 0, 0, time.FixedZone("AEDT", 11*60*60))
+	DB.Create(&Transaction{Md5: "1", Description: "CAFE", Amount: -1200, Account: 1, Category: "restaurants-and-cafes", Created: created})
+	DB.Create(&Transaction{Md5: "2", Description: "PIZZA", Amount: -2500, Account: 1, Category: "takeaway", Created: created})
+	DB.Create(&Transaction{Md5: "3", Description: "AHM", Amount: -38791, Account: 1, Category: "health", Created: created})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "x?group_by=parent_category", nil)
+	SummariseTransactions(c)
+
+	assert.Equal(t, 200, w.Code)
+	b, _ := ioutil.ReadAll(w.Body)
+	assert.Equal(t, `{"data":[{"avg":-18.5,"count":2,"max":-12,"min":-25,"parent_category":"good-life","sum":-37},{"avg":-387.91,"count":1,"max":-387.91,"min":-387.91,"parent_category":"health","sum":-387.91}]}`, string(b))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/codingric/moneyman/backend/exporter"
+	"github.com/codingric/moneyman/backend/filter"
+	"github.com/codingric/moneyman/backend/fx"
+	"github.com/codingric/moneyman/backend/money"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const ParamFormat = "format"
+
+// GET /transactions/export
+// Stream transactions matching the filters as csv (default), ofx, ledger or
+// beancount
+func ExportTransactions(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transaction.ExportTransactions")
+	defer span.End()
+
+	filters := c.Request.URL.Query()
+	format, err := exporter.Lookup(c.DefaultQuery(ParamFormat, "csv"))
+	if err != nil {
+		log.Error().Msg(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	filters.Del(ParamFormat)
+	base := filters.Get(ParamBase)
+	filters.Del(ParamBase)
+
+	parsed, err := filter.Parse(filters, TransactionFields)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	var rates *fx.Table
+	if base != "" {
+		if base, err = money.ParseCurrency(base); err != nil {
+			msg := fmt.Sprintf("invalid base %s", c.Query(ParamBase))
+			log.Error().Msg(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			span.SetStatus(codes.Error, msg)
+			return
+		}
+		if rates, err = LoadRates(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Unable to retreive data")
+			return
+		}
+	}
+
+	// Accounts are few, so names are looked up in memory rather than joined
+	// in SQL where they would make the filter columns ambiguous
+	var accounts []Account
+	if err := DB.WithContext(ctx).Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		return
+	}
+	names := map[int64]string{}
+	for _, a := range accounts {
+		names[a.ID] = a.Name
+	}
+
+	if rates != nil {
+		// Headers can't be taken back once rows are streaming, so a missing
+		// rate is found before any are sent
+		err := checkRates(filter.Apply(DB.WithContext(ctx).Model(&Transaction{}), parsed), rates, base)
+		var convErr conversionError
+		if errors.As(err, &convErr) {
+			log.Error().Msg(err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Unable to retreive data")
+			return
+		}
+	}
+
+	query := filter.Apply(DB.WithContext(ctx).Model(&Transaction{}), parsed)
+	if format.ByAccount {
+		query = query.Order("account")
+	}
+	rows, err := query.Order("created").Order("id").Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions.%s"`, format.Extension))
+	c.Status(http.StatusOK)
+
+	writer := format.New(c.Writer)
+	count := 0
+	for rows.Next() {
+		var t Transaction
+		if err := DB.ScanRows(rows, &t); err != nil {
+			// Headers are already sent, all that can be done is stop
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Unable to read transaction")
+			log.Error().Err(err).Msg("Unable to read transaction")
+			return
+		}
+		row := exporter.Row{
+			ID:              t.ID,
+			Created:         t.Created,
+			Description:     t.Description,
+			Amount:          t.Amount,
+			Currency:        t.Currency,
+			ForeignAmount:   t.ForeignAmount,
+			ForeignCurrency: t.ForeignCurrency,
+			Account:         t.Account,
+			AccountName:     names[t.Account],
+			Category:        t.Category,
+		}
+		if rates != nil {
+			// Rates were checked up front, so this only fails when rows change
+			// mid export
+			if row.Amount, err = rates.Convert(t.Amount, t.Currency, base, t.Created); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				log.Error().Err(err).Uint("id", t.ID).Msg("Unable to convert transaction")
+				return
+			}
+			row.Currency = base
+		}
+		if err := writer.Write(row); err != nil {
+			span.RecordError(err)
+			log.Error().Err(err).Msg("Unable to write export")
+			return
+		}
+		if count++; count%100 == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := writer.Close(); err != nil {
+		span.RecordError(err)
+		log.Error().Err(err).Msg("Unable to write export")
+	}
+
+	span.AddEvent("Transactions exported", trace.WithAttributes(
+		attribute.Int("result.count", count),
+	))
+}
+
+// checkRates returns a conversionError for the first currency and day
+// matched by query that has no rate to base
+func checkRates(query *gorm.DB, rates *fx.Table, base string) error {
+	var rows []struct {
+		Currency string
+		Created  time.Time
+	}
+	if err := query.Distinct("currency", "created").Where("currency <> ?", base).Scan(&rows).Error; err != nil {
+		return err
+	}
+	checked := map[string]bool{}
+	for _, r := range rows {
+		key := r.Currency + " " + r.Created.Format(fx.DateFormat)
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+		if _, err := rates.Rate(r.Currency, base, r.Created); err != nil {
+			return conversionError{err}
+		}
+	}
+	return nil
+}
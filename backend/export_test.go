@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codingric/moneyman/backend/fx"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportTransactions(t *testing.T) {
+	defer useDatabase()()
+
+	loc := time.FixedZone("AEDT", 11*60*60)
+	DB.Create(&Account{ID: 1, Name: "Spending"})
+	DB.Create(&Transaction{Md5: "1", Description: "WOOLWORTHS", Amount: -2010, Account: 1, Category: "groceries", Created: time.Date(2023, time.January, 1, 0, 30, 0, 0, loc)})
+	DB.Create(&Transaction{Md5: "2", Description: "SALARY", Amount: 480000, Account: 2, Created: time.Date(2023, time.January, 2, 0, 0, 0, 0, loc)})
+	StoreRates(context.Background(), []fx.Rate{{Date: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), From: "USD", To: "AUD", Rate: 1.5}})
+	DB.Create(&Transaction{Md5: "3", Description: "AHM", Amount: -38791, Account: 1, Category: "health", Created: time.Date(2023, time.January, 3, 0, 0, 0, 0, loc)})
+
+	tests := []struct {
+		name         string
+		url          string
+		status_code  int
+		content_type string
+		expect       string
+	}{
+		{
+			"CSV",
+			"x",
+			200,
+			"text/csv; charset=utf-8",
+			`id,date,description,amount,currency,foreign_amount,foreign_currency,account,account_name,category
+1,2023-01-01T00:30:00+11:00,WOOLWORTHS,-20.10,AUD,,,1,Spending,groceries
+2,2023-01-02T00:00:00+11:00,SALARY,4800.00,AUD,,,2,,
+3,2023-01-03T00:00:00+11:00,AHM,-387.91,AUD,,,1,Spending,health
+`,
+		},
+		{
+			"LedgerFiltered",
+			"x?format=ledger&amount__lt=0",
+			200,
+			"text/plain; charset=utf-8",
+			`2023/01/01 WOOLWORTHS
+    Assets:Spending                              -20.10 AUD
+    Expenses:groceries
+
+2023/01/03 AHM
+    Assets:Spending                             -387.91 AUD
+    Expenses:health
+
+`,
+		},
+		{
+			"Base",
+			"x?base=USD&amount__lt=0",
+			200,
+			"text/csv; charset=utf-8",
+			`id,date,description,amount,currency,foreign_amount,foreign_currency,account,account_name,category
+1,2023-01-01T00:30:00+11:00,WOOLWORTHS,-13.40,USD,,,1,Spending,groceries
+3,2023-01-03T00:00:00+11:00,AHM,-258.61,USD,,,1,Spending,health
+`,
+		},
+		{
+			"MissingRate",
+			"x?base=EUR",
+			400,
+			"application/json; charset=utf-8",
+			`{"error":"no AUD/EUR rate on 2023-01-01"}`,
+		},
+		{
+			"InvalidBase",
+			"x?base=1",
+			400,
+			"application/json; charset=utf-8",
+			`{"error":"invalid base 1"}`,
+		},
+		{
+			"InvalidFormat",
+			"x?format=xls",
+			400,
+			"application/json; charset=utf-8",
+			`{"error":"invalid format xls"}`,
+		},
+		{
+			"InvalidFilter",
+			"x?format=csv&md5=1",
+			400,
+			"application/json; charset=utf-8",
+			`{"error":"invalid field md5"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", test.url, nil)
+
+			ExportTransactions(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			assert.Equal(st, test.content_type, w.Header().Get("Content-Type"))
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSV(w io.Writer) Writer {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write([]string{"id", "date", "description", "amount", "currency", "foreign_amount", "foreign_currency", "account", "account_name", "category"})
+}
+
+func (c *csvWriter) Write(r Row) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	foreign := ""
+	if r.ForeignAmount != nil {
+		foreign = r.ForeignAmount.String()
+	}
+	err := c.w.Write([]string{
+		fmt.Sprint(r.ID),
+		r.Created.Format("2006-01-02T15:04:05Z07:00"),
+		r.Description,
+		r.Amount.String(),
+		r.currency(),
+		foreign,
+		r.ForeignCurrency,
+		fmt.Sprint(r.Account),
+		r.AccountName,
+		r.Category,
+	})
+	c.w.Flush()
+	return err
+}
+
+func (c *csvWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,104 @@
+// Package exporter writes backend transactions as CSV, OFX, ledger or
+// beancount, one row at a time so exports can be streamed.
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/codingric/moneyman/backend/money"
+)
+
+type Row struct {
+	ID          uint
+	Created     time.Time
+	Description string
+	Amount      money.Amount
+	Currency    string
+	// Original amount of transactions made in another currency
+	ForeignAmount   *money.Amount
+	ForeignCurrency string
+	Account         int64
+	AccountName     string
+	Category        string
+}
+
+type Writer interface {
+	Write(Row) error
+	// Close writes any trailer, it does not close the underlying writer
+	Close() error
+}
+
+type Format struct {
+	ContentType string
+	Extension   string
+	// Rows must be ordered by account for this format
+	ByAccount bool
+	New       func(io.Writer) Writer
+}
+
+var Formats = map[string]Format{
+	"csv":       {ContentType: "text/csv; charset=utf-8", Extension: "csv", New: newCSV},
+	"ofx":       {ContentType: "application/x-ofx", Extension: "ofx", ByAccount: true, New: newOFX},
+	"ledger":    {ContentType: "text/plain; charset=utf-8", Extension: "ledger", New: newLedger},
+	"beancount": {ContentType: "text/plain; charset=utf-8", Extension: "beancount", New: newBeancount},
+}
+
+func Lookup(format string) (Format, error) {
+	f, ok := Formats[format]
+	if !ok {
+		return f, fmt.Errorf("invalid format %s", format)
+	}
+	return f, nil
+}
+
+// Name of the account, its id when it has no name
+func (r Row) account() string {
+	if r.AccountName != "" {
+		return r.AccountName
+	}
+	return fmt.Sprint(r.Account)
+}
+
+func (r Row) currency() string {
+	if r.Currency != "" {
+		return r.Currency
+	}
+	return money.DefaultCurrency
+}
+
+var nonWord = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// Ledger style account path, the category decides the counter account
+func (r Row) accounts() (own, other string) {
+	own = "Assets:" + r.account()
+	category := r.Category
+	if category == "" {
+		category = "Uncategorised"
+	}
+	if r.Amount < 0 {
+		return own, "Expenses:" + category
+	}
+	return own, "Income:" + category
+}
+
+// Beancount account components must start with a capital and contain only
+// letters, numbers and dashes
+func beancountAccount(account string) string {
+	parts := strings.Split(account, ":")
+	for i, p := range parts {
+		p = strings.Trim(nonWord.ReplaceAllString(p, "-"), "-")
+		if p == "" {
+			p = "X"
+		}
+		p = strings.ToUpper(p[:1]) + p[1:]
+		if p[0] >= '0' && p[0] <= '9' {
+			p = "A" + p
+		}
+		parts[i] = p
+	}
+	return strings.Join(parts, ":")
+}
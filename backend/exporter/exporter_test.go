@@ -0,0 +1,172 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/codingric/moneyman/backend/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriters(t *testing.T) {
+	aedt := time.FixedZone("AEDT", 11*60*60)
+	usd := money.Amount(-25000)
+	rows := []Row{
+		{ID: 1, Created: time.Date(2023, time.February, 1, 9, 30, 0, 0, aedt), Description: "WOOLWORTHS, MELBOURNE", Amount: -1250, Account: 62863432, AccountName: "Spending", Category: "groceries"},
+		{ID: 2, Created: time.Date(2023, time.February, 2, 0, 0, 0, 0, aedt), Description: "SALARY", Amount: 480000, Account: 62863432, AccountName: "Spending"},
+		{ID: 3, Created: time.Date(2023, time.February, 3, 0, 0, 0, 0, aedt), Description: "AHM & CO", Amount: -38791, Currency: "AUD", ForeignAmount: &usd, ForeignCurrency: "USD", Account: 37366510, Category: "health"},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		rows   []Row
+		expect string
+	}{
+		{
+			"CSV",
+			"csv",
+			rows,
+			`id,date,description,amount,currency,foreign_amount,foreign_currency,account,account_name,category
+1,2023-02-01T09:30:00+11:00,"WOOLWORTHS, MELBOURNE",-12.50,AUD,,,62863432,Spending,groceries
+2,2023-02-02T00:00:00+11:00,SALARY,4800.00,AUD,,,62863432,Spending,
+3,2023-02-03T00:00:00+11:00,AHM & CO,-387.91,AUD,-250.00,USD,37366510,,health
+`,
+		},
+		{
+			"CSVEmpty",
+			"csv",
+			nil,
+			"id,date,description,amount,currency,foreign_amount,foreign_currency,account,account_name,category\n",
+		},
+		{
+			"Ledger",
+			"ledger",
+			rows,
+			`2023/02/01 WOOLWORTHS, MELBOURNE
+    Assets:Spending                              -12.50 AUD
+    Expenses:groceries
+
+2023/02/02 SALARY
+    Assets:Spending                             4800.00 AUD
+    Income:Uncategorised
+
+2023/02/03 AHM & CO
+    Assets:37366510                             -387.91 AUD
+    Expenses:health
+
+`,
+		},
+		{
+			"Beancount",
+			"beancount",
+			rows,
+			`1970-01-01 open Assets:Spending
+
+1970-01-01 open Expenses:Groceries
+
+2023-02-01 * "WOOLWORTHS, MELBOURNE"
+  Assets:Spending                              -12.50 AUD
+  Expenses:Groceries
+
+1970-01-01 open Income:Uncategorised
+
+2023-02-02 * "SALARY"
+  Assets:Spending                             4800.00 AUD
+  Income:Uncategorised
+
+1970-01-01 open Assets:A37366510
+
+1970-01-01 open Expenses:Health
+
+2023-02-03 * "AHM & CO"
+  Assets:A37366510                            -387.91 AUD
+  Expenses:Health
+
+`,
+		},
+		{
+			"OFX",
+			"ofx",
+			rows,
+			`<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>62863432</TRNUID>
+<STMTRS>
+<CURDEF>AUD</CURDEF>
+<BANKACCTFROM>
+<BANKID>0</BANKID>
+<ACCTID>62863432</ACCTID>
+<ACCTTYPE>CHECKING</ACCTTYPE>
+</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT</TRNTYPE>
+<DTPOSTED>20230201093000[11]</DTPOSTED>
+<TRNAMT>-12.50</TRNAMT>
+<FITID>1</FITID>
+<NAME>WOOLWORTHS, MELBOURNE</NAME>
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT</TRNTYPE>
+<DTPOSTED>20230202000000[11]</DTPOSTED>
+<TRNAMT>4800.00</TRNAMT>
+<FITID>2</FITID>
+<NAME>SALARY</NAME>
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS>
+</STMTTRNRS>
+<STMTTRNRS>
+<TRNUID>37366510</TRNUID>
+<STMTRS>
+<CURDEF>AUD</CURDEF>
+<BANKACCTFROM>
+<BANKID>0</BANKID>
+<ACCTID>37366510</ACCTID>
+<ACCTTYPE>CHECKING</ACCTTYPE>
+</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT</TRNTYPE>
+<DTPOSTED>20230203000000[11]</DTPOSTED>
+<TRNAMT>-387.91</TRNAMT>
+<FITID>3</FITID>
+<NAME>AHM &amp; CO</NAME>
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			format, err := Lookup(test.format)
+			if !assert.NoError(tt, err) {
+				return
+			}
+			buf := &bytes.Buffer{}
+			w := format.New(buf)
+			for _, r := range test.rows {
+				assert.NoError(tt, w.Write(r))
+			}
+			assert.NoError(tt, w.Close())
+			assert.Equal(tt, test.expect, buf.String())
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	_, err := Lookup("xls")
+	if assert.Error(t, err) {
+		assert.Equal(t, "invalid format xls", err.Error())
+	}
+}
@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type ledgerWriter struct {
+	w io.Writer
+}
+
+func newLedger(w io.Writer) Writer {
+	return &ledgerWriter{w: w}
+}
+
+func (l *ledgerWriter) Write(r Row) error {
+	own, other := r.accounts()
+	_, err := fmt.Fprintf(l.w, "%s %s\n    %-40s %10s %s\n    %s\n\n",
+		r.Created.Format("2006/01/02"),
+		strings.ReplaceAll(r.Description, "\n", " "),
+		own, r.Amount, r.currency(),
+		other,
+	)
+	return err
+}
+
+func (l *ledgerWriter) Close() error {
+	return nil
+}
+
+type beancountWriter struct {
+	w      io.Writer
+	opened map[string]bool
+}
+
+func newBeancount(w io.Writer) Writer {
+	return &beancountWriter{w: w, opened: map[string]bool{}}
+}
+
+// Beancount sorts directives by date, so accounts are opened when first seen
+// with a date that precedes every transaction
+func (b *beancountWriter) open(account string) error {
+	if b.opened[account] {
+		return nil
+	}
+	b.opened[account] = true
+	_, err := fmt.Fprintf(b.w, "1970-01-01 open %s\n\n", account)
+	return err
+}
+
+func (b *beancountWriter) Write(r Row) error {
+	own, other := r.accounts()
+	own, other = beancountAccount(own), beancountAccount(other)
+	for _, a := range []string{own, other} {
+		if err := b.open(a); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(b.w, "%s * %q\n  %-40s %10s %s\n  %s\n\n",
+		r.Created.Format("2006-01-02"),
+		strings.ReplaceAll(r.Description, "\n", " "),
+		own, r.Amount, r.currency(),
+		other,
+	)
+	return err
+}
+
+func (b *beancountWriter) Close() error {
+	return nil
+}
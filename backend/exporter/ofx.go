@@ -0,0 +1,102 @@
+package exporter
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ofxHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<BANKMSGSRSV1>
+`
+
+const ofxFooter = `</BANKMSGSRSV1>
+</OFX>
+`
+
+// ofxWriter emits one statement per account, rows must arrive ordered by
+// account
+type ofxWriter struct {
+	w       io.Writer
+	started bool
+	account *int64
+}
+
+func newOFX(w io.Writer) Writer {
+	return &ofxWriter{w: w}
+}
+
+func (o *ofxWriter) begin() error {
+	if o.started {
+		return nil
+	}
+	o.started = true
+	_, err := io.WriteString(o.w, ofxHeader)
+	return err
+}
+
+func (o *ofxWriter) endStatement() error {
+	if o.account == nil {
+		return nil
+	}
+	_, err := io.WriteString(o.w, "</BANKTRANLIST>\n</STMTRS>\n</STMTTRNRS>\n")
+	return err
+}
+
+func (o *ofxWriter) Write(r Row) error {
+	if err := o.begin(); err != nil {
+		return err
+	}
+	if o.account == nil || *o.account != r.Account {
+		if err := o.endStatement(); err != nil {
+			return err
+		}
+		account := r.Account
+		o.account = &account
+		_, err := fmt.Fprintf(o.w, "<STMTTRNRS>\n<TRNUID>%d</TRNUID>\n<STMTRS>\n<CURDEF>%s</CURDEF>\n<BANKACCTFROM>\n<BANKID>0</BANKID>\n<ACCTID>%d</ACCTID>\n<ACCTTYPE>CHECKING</ACCTTYPE>\n</BANKACCTFROM>\n<BANKTRANLIST>\n", account, r.currency(), account)
+		if err != nil {
+			return err
+		}
+	}
+
+	trntype := "CREDIT"
+	if r.Amount < 0 {
+		trntype = "DEBIT"
+	}
+	_, err := fmt.Fprintf(o.w, "<STMTTRN>\n<TRNTYPE>%s</TRNTYPE>\n<DTPOSTED>%s</DTPOSTED>\n<TRNAMT>%s</TRNAMT>\n<FITID>%d</FITID>\n<NAME>%s</NAME>\n</STMTTRN>\n",
+		trntype,
+		ofxTime(r.Created),
+		r.Amount,
+		r.ID,
+		escape(r.Description),
+	)
+	return err
+}
+
+func (o *ofxWriter) Close() error {
+	if err := o.begin(); err != nil {
+		return err
+	}
+	if err := o.endStatement(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(o.w, ofxFooter)
+	return err
+}
+
+// 20230201120000[+11]
+func ofxTime(t time.Time) string {
+	_, offset := t.Zone()
+	return fmt.Sprintf("%s[%s]", t.Format("20060102150405"), strconv.FormatFloat(float64(offset)/3600, 'f', -1, 64))
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
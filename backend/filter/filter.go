@@ -0,0 +1,179 @@
+// Package filter parses the `field__op=value` query grammar used by the
+// backend list endpoints into typed, whitelisted SQL conditions.
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codingric/moneyman/backend/money"
+	"gorm.io/gorm"
+)
+
+type Type int
+
+const (
+	String Type = iota
+	Number
+	Time
+	// Decimal amount compared against a column of integer cents
+	Money
+)
+
+// Fields maps the filterable columns of a model to their type
+type Fields map[string]Type
+
+// Layouts accepted for Time values
+var TimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02"}
+
+var comparisons = map[string]string{"eq": "=", "ne": "!=", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+type Filter struct {
+	Field string
+	Op    string
+	Type  Type
+	// Raw values, several values for the same field and operator are OR'd
+	Values []string
+}
+
+// Parse query values into filters, only fields present in fields are allowed
+func Parse(values url.Values, fields Fields) (filters []Filter, err error) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, op := key, "eq"
+		if p := strings.SplitN(key, "__", 2); len(p) > 1 {
+			field, op = p[0], p[1]
+		}
+
+		t, ok := fields[field]
+		if !ok {
+			return nil, fmt.Errorf("invalid field %s", field)
+		}
+
+		f := Filter{Field: field, Op: op, Type: t, Values: values[key]}
+		for _, v := range f.Values {
+			if _, _, err := f.clause(v); err != nil {
+				return nil, err
+			}
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// Apply filters as WHERE conditions on query
+func Apply(query *gorm.DB, filters []Filter) *gorm.DB {
+	for _, f := range filters {
+		sql, args := f.Clause()
+		query = query.Where(sql, args...)
+	}
+	return query
+}
+
+// Clause returns the SQL condition and its arguments for the filter
+func (f Filter) Clause() (string, []interface{}) {
+	var (
+		sqls []string
+		args []interface{}
+	)
+	for _, v := range f.Values {
+		s, a, _ := f.clause(v)
+		sqls = append(sqls, s)
+		args = append(args, a...)
+	}
+	if len(sqls) == 1 {
+		return sqls[0], args
+	}
+	return "(" + strings.Join(sqls, " OR ") + ")", args
+}
+
+func (f Filter) clause(raw string) (string, []interface{}, error) {
+	switch f.Op {
+	case "eq", "ne", "gt", "ge", "lt", "le":
+		v, err := convert(f.Type, raw)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("%s %s ?", f.Field, comparisons[f.Op]), []interface{}{v}, nil
+	case "like":
+		return f.Field + " LIKE ?", []interface{}{"%" + raw + "%"}, nil
+	case "ilike":
+		return "LOWER(" + f.Field + ") LIKE LOWER(?)", []interface{}{"%" + raw + "%"}, nil
+	case "startswith":
+		return f.Field + " LIKE ?", []interface{}{raw + "%"}, nil
+	case "in":
+		var list []interface{}
+		for _, r := range strings.Split(raw, ",") {
+			v, err := convert(f.Type, r)
+			if err != nil {
+				return "", nil, err
+			}
+			list = append(list, v)
+		}
+		return f.Field + " IN ?", []interface{}{list}, nil
+	case "between":
+		p := strings.Split(raw, ",")
+		if len(p) != 2 {
+			return "", nil, fmt.Errorf("invalid value %s for between", raw)
+		}
+		lo, err := convert(f.Type, p[0])
+		if err != nil {
+			return "", nil, err
+		}
+		hi, err := convert(f.Type, p[1])
+		if err != nil {
+			return "", nil, err
+		}
+		return f.Field + " BETWEEN ? AND ?", []interface{}{lo, hi}, nil
+	case "isnull":
+		null, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid value %s for isnull", raw)
+		}
+		if null {
+			return f.Field + " IS NULL", nil, nil
+		}
+		return f.Field + " IS NOT NULL", nil, nil
+	case "regex":
+		if _, err := regexp.Compile(raw); err != nil {
+			return "", nil, fmt.Errorf("invalid regex %s", raw)
+		}
+		return f.Field + " REGEXP ?", []interface{}{raw}, nil
+	}
+	return "", nil, fmt.Errorf("invalid operator %s", f.Op)
+}
+
+func convert(t Type, raw string) (interface{}, error) {
+	switch t {
+	case Number:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", raw)
+		}
+		return n, nil
+	case Money:
+		a, err := money.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		return int64(a), nil
+	case Time:
+		for _, layout := range TimeLayouts {
+			if d, err := time.Parse(layout, raw); err == nil {
+				return d.Format("2006-01-02 15:04:05"), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid time %s", raw)
+	}
+	return raw, nil
+}
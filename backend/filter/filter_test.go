@@ -0,0 +1,65 @@
+package filter
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	fields := Fields{"id": Number, "description": String, "created": Time, "amount": Money}
+
+	type E struct {
+		sql  string
+		args []interface{}
+		err  string
+	}
+	tests := []struct {
+		name   string
+		query  string
+		expect E
+	}{
+		{"Equal", "id=1", E{sql: "id = ?", args: []interface{}{1.0}}},
+		{"NotEqual", "id__ne=1", E{sql: "id != ?", args: []interface{}{1.0}}},
+		{"Time", "created__gt=2000-01-01T10:00:00", E{sql: "created > ?", args: []interface{}{"2000-01-01 10:00:00"}}},
+		{"Date", "created__le=2000-01-01", E{sql: "created <= ?", args: []interface{}{"2000-01-01 00:00:00"}}},
+		{"Money", "amount__ne=-994.86", E{sql: "amount != ?", args: []interface{}{int64(-99486)}}},
+		{"MoneyBetween", "amount__between=-10,10.5", E{sql: "amount BETWEEN ? AND ?", args: []interface{}{int64(-1000), int64(1050)}}},
+		{"Like", "description__like=abc", E{sql: "description LIKE ?", args: []interface{}{"%abc%"}}},
+		{"ILike", "description__ilike=abc", E{sql: "LOWER(description) LIKE LOWER(?)", args: []interface{}{"%abc%"}}},
+		{"StartsWith", "description__startswith=abc", E{sql: "description LIKE ?", args: []interface{}{"abc%"}}},
+		{"In", "id__in=1,2", E{sql: "id IN ?", args: []interface{}{[]interface{}{1.0, 2.0}}}},
+		{"Between", "created__between=2000-01-01,2000-02-01", E{sql: "created BETWEEN ? AND ?", args: []interface{}{"2000-01-01 00:00:00", "2000-02-01 00:00:00"}}},
+		{"IsNull", "description__isnull=1", E{sql: "description IS NULL"}},
+		{"IsNotNull", "description__isnull=false", E{sql: "description IS NOT NULL"}},
+		{"Regex", "description__regex=^a.*b$", E{sql: "description REGEXP ?", args: []interface{}{"^a.*b$"}}},
+		{"Or", "description__like=abc&description__like=xyz", E{sql: "(description LIKE ? OR description LIKE ?)", args: []interface{}{"%abc%", "%xyz%"}}},
+		{"UnknownField", "md5=abc", E{err: "invalid field md5"}},
+		{"InvalidOperator", "id__xx=1", E{err: "invalid operator xx"}},
+		{"InvalidNumber", "id__in=1,x", E{err: "invalid number x"}},
+		{"InvalidMoney", "amount__lt=1.005", E{err: "invalid amount 1.005"}},
+		{"InvalidTime", "created__gt=x", E{err: "invalid time x"}},
+		{"InvalidBetween", "id__between=1,2,3", E{err: "invalid value 1,2,3 for between"}},
+		{"InvalidIsNull", "id__isnull=maybe", E{err: "invalid value maybe for isnull"}},
+		{"InvalidRegex", "description__regex=(", E{err: "invalid regex ("}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			values, _ := url.ParseQuery(test.query)
+			filters, err := Parse(values, fields)
+			if test.expect.err != "" {
+				if assert.Error(tt, err) {
+					assert.Equal(tt, test.expect.err, err.Error())
+				}
+				return
+			}
+			if assert.NoError(tt, err) && assert.Len(tt, filters, 1) {
+				sql, args := filters[0].Clause()
+				assert.Equal(tt, test.expect.sql, sql)
+				assert.Equal(tt, test.expect.args, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Package fx converts amounts between currencies using a table of dated
+// exchange rates.
+package fx
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codingric/moneyman/backend/money"
+)
+
+const DateFormat = "2006-01-02"
+
+// Rate is the price of one From in To on Date, e.g. 1 USD = 1.52 AUD
+type Rate struct {
+	ID   uint      `json:"-" gorm:"primary_key"`
+	Date time.Time `json:"date" gorm:"uniqueIndex:idx_rates_pair"`
+	From string    `json:"from" gorm:"column:from_currency;uniqueIndex:idx_rates_pair"`
+	To   string    `json:"to" gorm:"column:to_currency;uniqueIndex:idx_rates_pair"`
+	Rate float64   `json:"rate"`
+}
+
+// Load rates from CSV with a `date,from,to,rate` header, dates are
+// YYYY-MM-DD
+func Load(r io.Reader) ([]Rate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range []string{"date", "from", "to", "rate"} {
+		if strings.ToLower(strings.TrimSpace(header[i])) != h {
+			return nil, fmt.Errorf("invalid header %s, expected date,from,to,rate", strings.Join(header, ","))
+		}
+	}
+
+	var rates []Rate
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		date, err := time.Parse(DateFormat, record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %s", line, record[0])
+		}
+		rate, err := strconv.ParseFloat(record[3], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("line %d: invalid rate %s", line, record[3])
+		}
+		rates = append(rates, Rate{
+			Date: date,
+			From: strings.ToUpper(record[1]),
+			To:   strings.ToUpper(record[2]),
+			Rate: rate,
+		})
+	}
+}
+
+type pair struct{ from, to string }
+
+// Table looks up the rate in effect on a day
+type Table struct {
+	// Rates of each pair ordered by date
+	pairs map[pair][]Rate
+}
+
+func NewTable(rates []Rate) *Table {
+	t := &Table{pairs: map[pair][]Rate{}}
+	for _, r := range rates {
+		p := pair{r.From, r.To}
+		t.pairs[p] = append(t.pairs[p], r)
+	}
+	for _, list := range t.pairs {
+		sort.Slice(list, func(i, j int) bool { return list[i].Date.Before(list[j].Date) })
+	}
+	return t
+}
+
+// Most recent rate of from in to published on or before on's date
+func (t *Table) direct(from, to string, on time.Time) (float64, bool) {
+	list := t.pairs[pair{from, to}]
+	day := time.Date(on.Year(), on.Month(), on.Day(), 0, 0, 0, 0, time.UTC)
+	i := sort.Search(len(list), func(i int) bool { return list[i].Date.After(day) })
+	if i == 0 {
+		return 0, false
+	}
+	return list[i-1].Rate, true
+}
+
+// Rate of from in to on the day, using the inverse pair or crossing through
+// the default currency when there is no direct rate
+func (t *Table) Rate(from, to string, on time.Time) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if r, ok := t.direct(from, to, on); ok {
+		return r, nil
+	}
+	if r, ok := t.direct(to, from, on); ok {
+		return 1 / r, nil
+	}
+	if from != money.DefaultCurrency && to != money.DefaultCurrency {
+		a, errA := t.Rate(from, money.DefaultCurrency, on)
+		b, errB := t.Rate(money.DefaultCurrency, to, on)
+		if errA == nil && errB == nil {
+			return a * b, nil
+		}
+	}
+	return 0, fmt.Errorf("no %s/%s rate on %s", from, to, on.Format(DateFormat))
+}
+
+// Convert amount in from to to, rounded to the cent
+func (t *Table) Convert(amount money.Amount, from, to string, on time.Time) (money.Amount, error) {
+	r, err := t.Rate(from, to, on)
+	if err != nil {
+		return 0, err
+	}
+	return money.FromFloat(amount.Float() * r), nil
+}
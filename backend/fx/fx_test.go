@@ -0,0 +1,79 @@
+package fx
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/codingric/moneyman/backend/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	rates, err := Load(bytes.NewBufferString("date,from,to,rate\n2023-01-01,usd,AUD,1.47\n2023-01-02, USD, AUD, 1.45\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Rate{
+		{Date: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), From: "USD", To: "AUD", Rate: 1.47},
+		{Date: time.Date(2023, time.January, 2, 0, 0, 0, 0, time.UTC), From: "USD", To: "AUD", Rate: 1.45},
+	}, rates)
+
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"InvalidHeader", "day,from,to,rate\n", "invalid header day,from,to,rate, expected date,from,to,rate"},
+		{"InvalidDate", "date,from,to,rate\n01/01/2023,USD,AUD,1.47\n", "line 2: invalid date 01/01/2023"},
+		{"InvalidRate", "date,from,to,rate\n2023-01-01,USD,AUD,0\n", "line 2: invalid rate 0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			_, err := Load(bytes.NewBufferString(test.body))
+			if assert.Error(tt, err) {
+				assert.Equal(tt, test.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, time.January, d, 0, 0, 0, 0, time.UTC) }
+	table := NewTable([]Rate{
+		{Date: day(10), From: "USD", To: "AUD", Rate: 1.5},
+		{Date: day(1), From: "USD", To: "AUD", Rate: 1.4},
+		{Date: day(1), From: "AUD", To: "JPY", Rate: 90},
+	})
+	aedt := time.FixedZone("AEDT", 11*60*60)
+
+	tests := []struct {
+		name   string
+		amount money.Amount
+		from   string
+		to     string
+		on     time.Time
+		expect money.Amount
+		err    string
+	}{
+		{"Same", -1000, "AUD", "AUD", day(1), -1000, ""},
+		{"Direct", -1000, "USD", "AUD", day(5), -1400, ""},
+		{"Latest", -1000, "USD", "AUD", day(10), -1500, ""},
+		{"LocalDay", -1000, "USD", "AUD", time.Date(2023, time.January, 10, 0, 30, 0, 0, aedt), -1500, ""},
+		{"Inverse", -1500, "AUD", "USD", day(12), -1000, ""},
+		{"Cross", 100, "USD", "JPY", day(2), 12600, ""},
+		{"TooEarly", 100, "USD", "AUD", time.Date(2022, time.December, 31, 0, 0, 0, 0, time.UTC), 0, "no USD/AUD rate on 2022-12-31"},
+		{"Missing", 100, "EUR", "AUD", day(2), 0, "no EUR/AUD rate on 2023-01-02"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			a, err := table.Convert(test.amount, test.from, test.to, test.on)
+			if test.err != "" {
+				if assert.Error(tt, err) {
+					assert.Equal(tt, test.err, err.Error())
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expect, a)
+		})
+	}
+}
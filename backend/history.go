@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
+)
+
+const (
+	HistoryCreate = "create"
+	HistoryUpdate = "update"
+	HistoryDelete = "delete"
+
+	// Header naming who made a change, there is no authentication to take it
+	// from
+	HeaderActor  = "X-Actor"
+	DefaultActor = "api"
+)
+
+// Clock of history entries, replaced in tests
+var now = time.Now
+
+// Values are the JSON fields of a transaction, stored as a JSON object
+type Values map[string]interface{}
+
+func (v Values) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (v *Values) Scan(value interface{}) error {
+	switch s := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(s), v)
+	case []byte:
+		return json.Unmarshal(s, v)
+	}
+	return fmt.Errorf("unable to scan %T into Values", value)
+}
+
+// TransactionHistory records who changed a transaction, when, and the values
+// of the fields that changed before and after
+type TransactionHistory struct {
+	ID            uint      `json:"id" gorm:"primary_key"`
+	TransactionID uint      `json:"transaction_id" gorm:"index"`
+	Action        string    `json:"action"`
+	Actor         string    `json:"actor"`
+	Changed       time.Time `json:"changed"`
+	Old           Values    `json:"old,omitempty" gorm:"type:text"`
+	New           Values    `json:"new,omitempty" gorm:"type:text"`
+}
+
+func (TransactionHistory) TableName() string {
+	return "transaction_history"
+}
+
+// Actor of the request, from the X-Actor header
+func Actor(c *gin.Context) string {
+	if c.Request != nil {
+		if actor := c.GetHeader(HeaderActor); actor != "" {
+			return actor
+		}
+	}
+	return DefaultActor
+}
+
+// values of t as they are returned by the API, without the id
+func values(t Transaction) Values {
+	b, _ := json.Marshal(t)
+	var v Values
+	json.Unmarshal(b, &v)
+	delete(v, "id")
+	return v
+}
+
+// diff returns the fields that differ between old and new
+func diff(old, new Values) (Values, Values) {
+	o, n := Values{}, Values{}
+	for k, v := range old {
+		if !reflect.DeepEqual(v, new[k]) {
+			o[k] = v
+			n[k] = new[k]
+		}
+	}
+	for k, v := range new {
+		if _, ok := old[k]; !ok {
+			n[k] = v
+		}
+	}
+	return o, n
+}
+
+// recordHistory stores the change of a transaction from old to new, either
+// may be nil for creates and deletes. Nothing is stored when no field changed.
+func recordHistory(tx *gorm.DB, actor, action string, old, new *Transaction) error {
+	h := TransactionHistory{Action: action, Actor: actor, Changed: now()}
+	var o, n Values
+	if old != nil {
+		h.TransactionID = old.ID
+		o = values(*old)
+	}
+	if new != nil {
+		h.TransactionID = new.ID
+		n = values(*new)
+	}
+	if old != nil && new != nil {
+		if o, n = diff(o, n); len(n) == 0 {
+			return nil
+		}
+	}
+	h.Old, h.New = o, n
+	return tx.Create(&h).Error
+}
+
+// GET /transaction/:id/history
+// Changes made to a transaction, including deleted ones
+func FindTransactionHistory(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "history.FindTransactionHistory")
+	defer span.End()
+
+	var transaction Transaction
+	if err := DB.WithContext(ctx).Unscoped().Where("id = ?", c.Param("id")).First(&transaction).Error; err != nil {
+		span.SetStatus(codes.Error, "Record not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	history := []TransactionHistory{}
+	if err := DB.WithContext(ctx).Where("transaction_id = ?", transaction.ID).Order("id").Find(&history).Error; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": history})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionHistory(t *testing.T) {
+	defer useDatabase()()
+	saved := now
+	defer func() { now = saved }()
+	now = func() time.Time { return time.Date(2023, time.February, 1, 9, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name        string
+		method      string
+		id          string
+		body        string
+		handler     gin.HandlerFunc
+		status_code int
+		expect      string
+	}{
+		{
+			"Create",
+			"POST",
+			"",
+			`{"created":"2023-01-31T00:00:00+11:00","amount":"-12.50","description":"WOOLWORHTS","account":"1234"}`,
+			CreateTransaction,
+			200,
+			`{"data":{"id":1,"description":"WOOLWORHTS","amount":-12.5,"currency":"AUD","account":1234,"created":"2023-01-31T00:00:00+11:00"}}`,
+		},
+		{
+			"Update",
+			"PATCH",
+			"1",
+			`{"description":"WOOLWORTHS","category":"groceries","tags":["food"]}`,
+			UpdateTransaction,
+			200,
+			`{"data":{"id":1,"description":"WOOLWORTHS","amount":-12.5,"currency":"AUD","account":1234,"created":"2023-01-31T00:00:00+11:00","category":"groceries","tags":["food"]}}`,
+		},
+		{
+			"Unchanged",
+			"PATCH",
+			"1",
+			`{"amount":"-12.5"}`,
+			UpdateTransaction,
+			200,
+			`{"data":{"id":1,"description":"WOOLWORTHS","amount":-12.5,"currency":"AUD","account":1234,"created":"2023-01-31T00:00:00+11:00","category":"groceries","tags":["food"]}}`,
+		},
+		{
+			"InvalidUpdate",
+			"PATCH",
+			"1",
+			`{"amount":"twelve"}`,
+			UpdateTransaction,
+			400,
+			`{"error":"invalid amount twelve"}`,
+		},
+		{
+			"ForeignCurrencyOnly",
+			"PATCH",
+			"1",
+			`{"foreign_currency":"USD"}`,
+			UpdateTransaction,
+			400,
+			`{"error":"foreign_amount and foreign_currency must be set together"}`,
+		},
+		{
+			"UpdateMissing",
+			"PATCH",
+			"2",
+			`{"description":"x"}`,
+			UpdateTransaction,
+			404,
+			`{"error":"Record not found"}`,
+		},
+		{
+			"Delete",
+			"DELETE",
+			"1",
+			"",
+			DeleteTransaction,
+			200,
+			`{"data":true}`,
+		},
+		{
+			"DeleteAgain",
+			"DELETE",
+			"1",
+			"",
+			DeleteTransaction,
+			404,
+			`{"error":"Record not found"}`,
+		},
+		{
+			"FindDeleted",
+			"GET",
+			"1",
+			"",
+			FindTransaction,
+			404,
+			`{"error":"Record not found"}`,
+		},
+		{
+			"History",
+			"GET",
+			"1",
+			"",
+			FindTransactionHistory,
+			200,
+			`{"data":[` +
+				`{"id":1,"transaction_id":1,"action":"create","actor":"api","changed":"2023-02-01T09:00:00Z","new":{"account":1234,"amount":-12.5,"created":"2023-01-31T00:00:00+11:00","currency":"AUD","description":"WOOLWORHTS"}},` +
+				`{"id":2,"transaction_id":1,"action":"update","actor":"alice","changed":"2023-02-01T09:00:00Z","old":{"description":"WOOLWORHTS"},"new":{"category":"groceries","description":"WOOLWORTHS","tags":["food"]}},` +
+				`{"id":3,"transaction_id":1,"action":"delete","actor":"alice","changed":"2023-02-01T09:00:00Z","old":{"account":1234,"amount":-12.5,"category":"groceries","created":"2023-01-31T00:00:00+11:00","currency":"AUD","description":"WOOLWORTHS","tags":["food"]}}` +
+				`]}`,
+		},
+		{
+			"HistoryMissing",
+			"GET",
+			"2",
+			"",
+			FindTransactionHistory,
+			404,
+			`{"error":"Record not found"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(test.method, "/transaction/"+test.id, bytes.NewBufferString(test.body))
+			if test.method != "POST" {
+				c.Request.Header.Set(HeaderActor, "alice")
+			}
+			c.Params = []gin.Param{{Key: "id", Value: test.id}}
+
+			test.handler(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	// Deleted transactions are still known to imports
+	var count int64
+	DB.Unscoped().Model(&Transaction{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+	DB.Model(&Transaction{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
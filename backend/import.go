@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/codingric/moneyman/backend/importer"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// Source of imported transactions
+	SourceImport = "import"
+
+	ImportCreated   = "created"
+	ImportDuplicate = "duplicate"
+	ImportRejected  = "rejected"
+)
+
+var (
+	importCmd        = kingpin.Command("import", "Import a CSV, OFX or QIF bank statement")
+	importFile       = importCmd.Arg("file", "Statement to import").Required().ExistingFile()
+	importFormat     = importCmd.Flag("format", "csv|ofx|qif, guessed from the file extension by default").Short('f').String()
+	importAccount    = importCmd.Flag("account", "Account for rows that don't name one").Short('a').String()
+	importDateFormat = importCmd.Flag("date-format", "Go time layout of CSV and QIF dates").Default(importer.DefaultDateFormat).String()
+	importColumns    = importCmd.Flag("column", "CSV column mapping, e.g. --column amount=Amount").StringMap()
+	importTimezone   = importCmd.Flag("timezone", "Timezone of dates without one").Default("Local").String()
+)
+
+type ImportResult struct {
+	Line        int          `json:"line"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+}
+
+type ImportReport struct {
+	Created   int            `json:"created"`
+	Duplicate int            `json:"duplicate"`
+	Rejected  int            `json:"rejected"`
+	Rows      []ImportResult `json:"rows"`
+}
+
+func (r *ImportReport) add(result ImportResult) {
+	switch result.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportDuplicate:
+		r.Duplicate++
+	case ImportRejected:
+		r.Rejected++
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+// Columns from a field => header mapping, unmapped fields keep their default
+func importColumnMapping(mapping map[string]string) (importer.Columns, error) {
+	columns := importer.DefaultColumns
+	for field, header := range mapping {
+		switch field {
+		case "date":
+			columns.Date = header
+		case "amount":
+			columns.Amount = header
+		case "debit":
+			columns.Debit = header
+		case "credit":
+			columns.Credit = header
+		case "description":
+			columns.Description = header
+		case "account":
+			columns.Account = header
+		default:
+			return columns, fmt.Errorf("invalid column %s", field)
+		}
+	}
+	return columns, nil
+}
+
+// ImportRows stores rows as transactions made by actor, rows already stored,
+// even if since deleted, are reported as duplicates using the same md5 key as
+// CreateTransaction
+func ImportRows(ctx context.Context, actor string, rows []importer.Row) (report ImportReport, err error) {
+	ctx, span := otel.Tracer("").Start(ctx, "import.ImportRows")
+	defer span.End()
+
+	rules, err := LoadRules(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return report, err
+	}
+
+	report.Rows = []ImportResult{}
+	created := []uint{}
+	for _, row := range rows {
+		result := ImportResult{Line: row.Line, Status: ImportRejected}
+		if row.Err == nil {
+			if _, e := strconv.ParseInt(row.Account, 10, 64); e != nil {
+				row.Err = fmt.Errorf("invalid account %s", row.Account)
+			} else if row.Description == "" {
+				row.Err = errors.New("missing description")
+			}
+		}
+		if row.Err != nil {
+			result.Error = row.Err.Error()
+			report.add(result)
+			continue
+		}
+
+		input := CreateTransactionInput{Created: row.Created, Amount: row.Amount, Description: row.Description, Account: row.Account, Source: SourceImport}
+		transaction, e := input.Transaction()
+		if e != nil {
+			result.Error = e.Error()
+			report.add(result)
+			continue
+		}
+
+		var count int64
+		if err = DB.WithContext(ctx).Unscoped().Model(&Transaction{}).Where("md5 = ?", transaction.Md5).Count(&count).Error; err != nil {
+			span.RecordError(err)
+			return report, err
+		}
+		if count > 0 {
+			result.Status = ImportDuplicate
+			report.add(result)
+			continue
+		}
+
+		transaction.Categorise(rules)
+		e = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&transaction).Error; err != nil {
+				return err
+			}
+			return recordHistory(tx, actor, HistoryCreate, nil, &transaction)
+		})
+		if e != nil {
+			log.Error().Caller().Err(e).Int("line", row.Line).Msg("Failed to create transaction")
+			result.Error = "Failed to create transaction"
+			report.add(result)
+			continue
+		}
+		result.Status = ImportCreated
+		result.Transaction = &transaction
+		report.add(result)
+		created = append(created, transaction.ID)
+	}
+	if len(created) > 0 {
+		if _, e := DetectTransfers(ctx, DefaultTransferDays, created...); e != nil {
+			span.RecordError(e)
+			log.Error().Caller().Err(e).Msg("Unable to detect transfers")
+		}
+	}
+
+	span.AddEvent("Transactions imported", trace.WithAttributes(
+		attribute.Int("result.created", report.Created),
+		attribute.Int("result.duplicate", report.Duplicate),
+		attribute.Int("result.rejected", report.Rejected),
+	))
+	return report, nil
+}
+
+// POST /transactions/import
+// Import a statement uploaded as the multipart `file` field
+func ImportTransactions(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transaction.ImportTransactions")
+	defer span.End()
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Missing file")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+		return
+	}
+
+	columns, err := importColumnMapping(c.PostFormMap("column"))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	timezone := c.DefaultPostForm("timezone", "Local")
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		span.SetStatus(codes.Error, "Invalid timezone")
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid timezone %s", timezone)})
+		return
+	}
+
+	format := c.DefaultPostForm("format", importer.Format(header.Filename))
+	opts := importer.Options{
+		Account:    c.PostForm("account"),
+		DateFormat: c.PostForm("date_format"),
+		Columns:    columns,
+		Location:   loc,
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to read file")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to read file"})
+		return
+	}
+	defer file.Close()
+
+	rows, err := importer.Parse(format, file, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := ImportRows(ctx, Actor(c), rows)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to import transactions")
+		log.Error().Err(err).Msg("Unable to import transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to import transactions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// runImport imports the statement given on the command line and prints the
+// report as JSON
+func runImport(ctx context.Context) error {
+	columns, err := importColumnMapping(*importColumns)
+	if err != nil {
+		return err
+	}
+
+	loc, err := time.LoadLocation(*importTimezone)
+	if err != nil {
+		return err
+	}
+
+	format := *importFormat
+	if format == "" {
+		format = importer.Format(*importFile)
+	}
+
+	file, err := os.Open(*importFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rows, err := importer.Parse(format, file, importer.Options{
+		Account:    *importAccount,
+		DateFormat: *importDateFormat,
+		Columns:    columns,
+		Location:   loc,
+	})
+	if err != nil {
+		return err
+	}
+
+	report, err := ImportRows(ctx, "import", rows)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Imported %s: %d created, %d duplicate, %d rejected", *importFile, report.Created, report.Duplicate, report.Rejected)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
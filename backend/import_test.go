@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportTransactions(t *testing.T) {
+	defer useDatabase()()
+
+	statement := "Date,Description,Amount\n01/02/2023,WOOLWORTHS,-12.50\n02/02/2023,SALARY,4800\nxx,BAD DATE,1\n"
+
+	tests := []struct {
+		name        string
+		filename    string
+		fields      map[string]string
+		status_code int
+		expect      string
+	}{
+		{
+			"MissingFile",
+			"",
+			nil,
+			400,
+			`{"error":"Missing file"}`,
+		},
+		{
+			"InvalidFormat",
+			"statement.xls",
+			nil,
+			400,
+			`{"error":"invalid format xls"}`,
+		},
+		{
+			"InvalidColumn",
+			"statement.csv",
+			map[string]string{"column[balance]": "Balance"},
+			400,
+			`{"error":"invalid column balance"}`,
+		},
+		{
+			"InvalidTimezone",
+			"statement.csv",
+			map[string]string{"timezone": "Mars/Olympus"},
+			400,
+			`{"error":"invalid timezone Mars/Olympus"}`,
+		},
+		{
+			"MissingAccount",
+			"statement.csv",
+			nil,
+			200,
+			`{"data":{"created":0,"duplicate":0,"rejected":3,"rows":[{"line":2,"status":"rejected","error":"invalid account "},{"line":3,"status":"rejected","error":"invalid account "},{"line":4,"status":"rejected","error":"invalid date xx"}]}}`,
+		},
+		{
+			"Created",
+			"statement.csv",
+			map[string]string{"account": "1234", "timezone": "Australia/Melbourne"},
+			200,
+			`{"data":{"created":2,"duplicate":0,"rejected":1,"rows":[{"line":2,"status":"created","transaction":{"id":1,"description":"WOOLWORTHS","amount":-12.5,"currency":"AUD","account":1234,"created":"2023-02-01T00:00:00+11:00","source":"import"}},{"line":3,"status":"created","transaction":{"id":2,"description":"SALARY","amount":4800,"currency":"AUD","account":1234,"created":"2023-02-02T00:00:00+11:00","source":"import"}},{"line":4,"status":"rejected","error":"invalid date xx"}]}}`,
+		},
+		{
+			"Duplicate",
+			"statement.txt",
+			map[string]string{"account": "1234", "format": "csv", "timezone": "Australia/Melbourne"},
+			200,
+			`{"data":{"created":0,"duplicate":2,"rejected":1,"rows":[{"line":2,"status":"duplicate"},{"line":3,"status":"duplicate"},{"line":4,"status":"rejected","error":"invalid date xx"}]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			for k, v := range test.fields {
+				writer.WriteField(k, v)
+			}
+			if test.filename != "" {
+				part, _ := writer.CreateFormFile("file", test.filename)
+				part.Write([]byte(statement))
+			}
+			writer.Close()
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/transactions/import", body)
+			c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+
+			ImportTransactions(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+}
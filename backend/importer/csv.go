@@ -0,0 +1,105 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+func parseCSV(r io.Reader, opts Options) (rows []Row, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %w", err)
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		index[strings.TrimSpace(h)] = i
+	}
+
+	column := func(name string, required bool) (int, error) {
+		if name == "" {
+			return -1, nil
+		}
+		i, ok := index[name]
+		if !ok && required {
+			return -1, fmt.Errorf("missing column %s", name)
+		}
+		if !ok {
+			return -1, nil
+		}
+		return i, nil
+	}
+
+	c := opts.Columns
+	date, err := column(c.Date, true)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := column(c.Description, true)
+	if err != nil {
+		return nil, err
+	}
+	amt, err := column(c.Amount, c.Debit == "" && c.Credit == "")
+	if err != nil {
+		return nil, err
+	}
+	debit, _ := column(c.Debit, false)
+	credit, _ := column(c.Credit, false)
+	account, _ := column(c.Account, false)
+	if amt < 0 && debit < 0 && credit < 0 {
+		return nil, fmt.Errorf("missing column %s", c.Amount)
+	}
+
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		row := Row{Line: line, Account: opts.Account}
+		if err != nil {
+			row.Err = err
+			rows = append(rows, row)
+			continue
+		}
+
+		row.Description = field(record, desc)
+		if a := field(record, account); a != "" {
+			row.Account = a
+		}
+
+		row.Created, err = time.ParseInLocation(opts.DateFormat, field(record, date), opts.Location)
+		if err != nil {
+			row.Err = fmt.Errorf("invalid date %s", field(record, date))
+			rows = append(rows, row)
+			continue
+		}
+
+		switch {
+		case field(record, amt) != "":
+			row.Amount, row.Err = amount(field(record, amt))
+		case field(record, debit) != "":
+			row.Amount, row.Err = amount("-" + strings.TrimPrefix(field(record, debit), "-"))
+		case field(record, credit) != "":
+			row.Amount, row.Err = amount(field(record, credit))
+		default:
+			row.Err = fmt.Errorf("missing amount")
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
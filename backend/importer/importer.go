@@ -0,0 +1,90 @@
+// Package importer reads bank statement exports (CSV, OFX and QIF) into rows
+// ready to be stored as backend transactions.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codingric/moneyman/backend/money"
+)
+
+// Row is a single statement line, Err is set when the line could not be read
+type Row struct {
+	Line        int
+	Created     time.Time
+	Amount      string
+	Description string
+	Account     string
+	Err         error
+}
+
+// Columns maps CSV header names onto transaction fields. Either Amount or
+// Debit/Credit must be set.
+type Columns struct {
+	Date        string
+	Amount      string
+	Debit       string
+	Credit      string
+	Description string
+	Account     string
+}
+
+type Options struct {
+	// Account used when the statement doesn't name one
+	Account string
+	// Go time layout for CSV and QIF dates
+	DateFormat string
+	Columns    Columns
+	// Location dates without a zone are read in
+	Location *time.Location
+}
+
+var DefaultColumns = Columns{Date: "Date", Amount: "Amount", Description: "Description"}
+
+const DefaultDateFormat = "02/01/2006"
+
+var Formats = []string{"csv", "ofx", "qif"}
+
+// Format guesses the statement format from a file name
+func Format(name string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+}
+
+// Parse reads every row of a statement in format
+func Parse(format string, r io.Reader, opts Options) ([]Row, error) {
+	if opts.DateFormat == "" {
+		opts.DateFormat = DefaultDateFormat
+	}
+	if opts.Columns == (Columns{}) {
+		opts.Columns = DefaultColumns
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+
+	switch strings.ToLower(format) {
+	case "csv":
+		return parseCSV(r, opts)
+	case "ofx":
+		return parseOFX(r, opts)
+	case "qif":
+		return parseQIF(r, opts)
+	}
+	return nil, fmt.Errorf("invalid format %s", format)
+}
+
+// amount normalises a statement amount to two decimal places, the same way
+// Up reports values. Amounts with more decimals are rejected rather than
+// rounded.
+func amount(raw string) (string, error) {
+	s := strings.NewReplacer(",", "", "$", "", " ", "").Replace(strings.TrimSpace(raw))
+	a, err := money.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid amount %s", raw)
+	}
+	return a.String(), nil
+}
@@ -0,0 +1,147 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var aedt = time.FixedZone("AEDT", 11*60*60)
+
+func TestParse(t *testing.T) {
+	type E struct {
+		rows []Row
+		err  string
+	}
+	tests := []struct {
+		name   string
+		format string
+		body   string
+		opts   Options
+		expect E
+	}{
+		{
+			"InvalidFormat",
+			"xls",
+			"",
+			Options{},
+			E{err: "invalid format xls"},
+		},
+		{
+			"CSV",
+			"csv",
+			"Date,Description,Amount\n01/02/2023,WOOLWORTHS 1234,-12.5\n02/02/2023,SALARY,\"4,800.00\"\n03/02/2023,COLES,-1.005\n",
+			Options{Account: "1234", Location: aedt},
+			E{rows: []Row{
+				{Line: 2, Created: time.Date(2023, time.February, 1, 0, 0, 0, 0, aedt), Amount: "-12.50", Description: "WOOLWORTHS 1234", Account: "1234"},
+				{Line: 3, Created: time.Date(2023, time.February, 2, 0, 0, 0, 0, aedt), Amount: "4800.00", Description: "SALARY", Account: "1234"},
+				{Line: 4, Created: time.Date(2023, time.February, 3, 0, 0, 0, 0, aedt), Description: "COLES", Account: "1234", Err: errors.New("invalid amount -1.005")},
+			}},
+		},
+		{
+			"CSVMapping",
+			"csv",
+			"When,Details,Debit,Credit,Acct\n2023-02-01,WOOLWORTHS,12.50,,5678\n2023-02-02,SALARY,,4800,5678\n2023-02-03,NOTHING,,,5678\nyesterday,BAD DATE,1,,5678\n",
+			Options{Location: aedt, DateFormat: "2006-01-02", Columns: Columns{Date: "When", Description: "Details", Debit: "Debit", Credit: "Credit", Account: "Acct"}},
+			E{rows: []Row{
+				{Line: 2, Created: time.Date(2023, time.February, 1, 0, 0, 0, 0, aedt), Amount: "-12.50", Description: "WOOLWORTHS", Account: "5678"},
+				{Line: 3, Created: time.Date(2023, time.February, 2, 0, 0, 0, 0, aedt), Amount: "4800.00", Description: "SALARY", Account: "5678"},
+				{Line: 4, Created: time.Date(2023, time.February, 3, 0, 0, 0, 0, aedt), Description: "NOTHING", Account: "5678", Err: errors.New("missing amount")},
+				{Line: 5, Description: "BAD DATE", Account: "5678", Err: errors.New("invalid date yesterday")},
+			}},
+		},
+		{
+			"CSVMissingColumn",
+			"csv",
+			"Date,Amount\n01/02/2023,1.00\n",
+			Options{},
+			E{err: "missing column Description"},
+		},
+		{
+			"OFXSGML",
+			"ofx",
+			`OFXHEADER:100
+DATA:OFXSGML
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<BANKACCTFROM>
+<BANKID>923100
+<ACCTID>62863432
+</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20230201120000.000[+11:AEDT]
+<TRNAMT>-12.5
+<FITID>1
+<NAME>WOOLWORTHS
+<MEMO>WOOLWORTHS 1234 MELBOURNE
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20230202
+<TRNAMT>4800
+<NAME>SALARY
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20230203
+<TRNAMT>abc
+<NAME>BROKEN
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`,
+			Options{Location: aedt},
+			E{rows: []Row{
+				{Line: 11, Created: time.Date(2023, time.February, 1, 12, 0, 0, 0, time.FixedZone("", 11*60*60)), Amount: "-12.50", Description: "WOOLWORTHS 1234 MELBOURNE", Account: "62863432"},
+				{Line: 19, Created: time.Date(2023, time.February, 2, 0, 0, 0, 0, aedt), Amount: "4800.00", Description: "SALARY", Account: "62863432"},
+				{Line: 25, Created: time.Date(2023, time.February, 3, 0, 0, 0, 0, aedt), Description: "BROKEN", Account: "62863432", Err: errors.New("invalid amount abc")},
+			}},
+		},
+		{
+			"OFXXML",
+			"ofx",
+			`<?xml version="1.0"?><OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS><CCACCTFROM><ACCTID>4321</ACCTID></CCACCTFROM><BANKTRANLIST><STMTTRN><DTPOSTED>20230201</DTPOSTED><TRNAMT>-8.94</TRNAMT><NAME>AMAZON</NAME><MEMO>AWS</MEMO></STMTTRN></BANKTRANLIST></CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>`,
+			Options{Location: aedt},
+			E{rows: []Row{
+				{Line: 1, Created: time.Date(2023, time.February, 1, 0, 0, 0, 0, aedt), Amount: "-8.94", Description: "AMAZON AWS", Account: "4321"},
+			}},
+		},
+		{
+			"QIF",
+			"qif",
+			"!Type:Bank\nD01/02/2023\nT-12.50\nPWOOLWORTHS\n^\nD02/02/2023\nT4,800.00\nMSALARY\n^\nD03/02/2023\nPNO AMOUNT\n^\n",
+			Options{Account: "1234", Location: aedt},
+			E{rows: []Row{
+				{Line: 2, Created: time.Date(2023, time.February, 1, 0, 0, 0, 0, aedt), Amount: "-12.50", Description: "WOOLWORTHS", Account: "1234"},
+				{Line: 6, Created: time.Date(2023, time.February, 2, 0, 0, 0, 0, aedt), Amount: "4800.00", Description: "SALARY", Account: "1234"},
+				{Line: 10, Created: time.Date(2023, time.February, 3, 0, 0, 0, 0, aedt), Description: "NO AMOUNT", Account: "1234", Err: errors.New("missing amount")},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			rows, err := Parse(test.format, bytes.NewBufferString(test.body), test.opts)
+			if test.expect.err != "" {
+				if assert.Error(tt, err) {
+					assert.Equal(tt, test.expect.err, err.Error())
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expect.rows, rows)
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "csv", Format("/tmp/statement.CSV"))
+	assert.Equal(t, "ofx", Format("statement.ofx"))
+	assert.Equal(t, "", Format("statement"))
+}
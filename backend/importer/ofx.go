@@ -0,0 +1,110 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DTPOSTED values look like 20230105120000.000[+11:AEDT]
+var ofxDate = regexp.MustCompile(`^(\d{8})(\d{6})?(?:\.\d+)?(?:\[([+-]?\d+(?:\.\d+)?)(?::\w+)?\])?`)
+
+// parseOFX handles both SGML (OFX 1.x, leaf elements without closing tags)
+// and XML (OFX 2.x) statements by scanning tags rather than decoding a tree
+func parseOFX(r io.Reader, opts Options) (rows []Row, err error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body := string(raw)
+
+	account := opts.Account
+	var (
+		row       *Row
+		name      string
+		memo      string
+		inAccount bool
+	)
+	line := 1
+	for _, token := range strings.Split(body, "<") {
+		nl := strings.Count(token, "\n")
+		end := strings.Index(token, ">")
+		if end < 0 {
+			line += nl
+			continue
+		}
+		tag := strings.ToUpper(strings.TrimSpace(token[:end]))
+		value := strings.TrimSpace(token[end+1:])
+
+		switch tag {
+		case "BANKACCTFROM", "CCACCTFROM":
+			inAccount = true
+		case "/BANKACCTFROM", "/CCACCTFROM":
+			inAccount = false
+		case "ACCTID":
+			if inAccount && value != "" {
+				account = value
+			}
+		case "STMTTRN":
+			row = &Row{Line: line, Account: account}
+			name, memo = "", ""
+		case "DTPOSTED":
+			if row != nil {
+				if row.Created, err = ofxTime(value, opts.Location); err != nil && row.Err == nil {
+					row.Err = err
+				}
+			}
+		case "TRNAMT":
+			if row != nil {
+				if row.Amount, err = amount(value); err != nil && row.Err == nil {
+					row.Err = err
+				}
+			}
+		case "NAME":
+			name = value
+		case "MEMO":
+			memo = value
+		case "/STMTTRN":
+			if row != nil {
+				row.Description = description(name, memo)
+				if row.Amount == "" && row.Err == nil {
+					row.Err = fmt.Errorf("missing amount")
+				}
+				rows = append(rows, *row)
+				row = nil
+			}
+		}
+		line += nl
+	}
+	if row != nil {
+		row.Err = fmt.Errorf("unterminated transaction")
+		rows = append(rows, *row)
+	}
+	return rows, nil
+}
+
+func ofxTime(value string, loc *time.Location) (time.Time, error) {
+	m := ofxDate.FindStringSubmatch(value)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("invalid date %s", value)
+	}
+	if m[3] != "" {
+		h, _ := strconv.ParseFloat(m[3], 64)
+		loc = time.FixedZone("", int(h*3600))
+	}
+	return time.ParseInLocation("20060102150405", m[1]+m[2]+strings.Repeat("0", 6-len(m[2])), loc)
+}
+
+// Banks truncate NAME and often repeat it at the start of MEMO
+func description(name, memo string) string {
+	switch {
+	case memo == "" || strings.Contains(name, memo):
+		return name
+	case strings.HasPrefix(memo, name):
+		return memo
+	}
+	return strings.TrimSpace(name + " " + memo)
+}
@@ -0,0 +1,64 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// QIF records are a series of lines prefixed with a field code and terminated
+// by a line containing only `^`
+func parseQIF(r io.Reader, opts Options) (rows []Row, err error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	row := Row{Account: opts.Account}
+	var memo string
+	started := false
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "!") {
+			continue
+		}
+		if !started {
+			row.Line = line
+			started = true
+		}
+
+		code, value := text[0], strings.TrimSpace(text[1:])
+		switch code {
+		case 'D':
+			d, err := time.ParseInLocation(opts.DateFormat, strings.ReplaceAll(value, "'", "/"), opts.Location)
+			if err != nil && row.Err == nil {
+				row.Err = fmt.Errorf("invalid date %s", value)
+			}
+			row.Created = d
+		case 'T', 'U':
+			a, err := amount(value)
+			if err != nil && row.Err == nil {
+				row.Err = err
+			}
+			row.Amount = a
+		case 'P':
+			row.Description = value
+		case 'M':
+			memo = value
+		case '^':
+			if row.Description == "" {
+				row.Description = memo
+			}
+			if row.Amount == "" && row.Err == nil {
+				row.Err = fmt.Errorf("missing amount")
+			}
+			rows = append(rows, row)
+			row, memo, started = Row{Account: opts.Account}, "", false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of rows linked to the transaction they were derived from
+const (
+	// Money moved to a saver by rounding up a purchase
+	KindRoundUp = "round_up"
+	// Money returned for a purchase
+	KindCashback = "cashback"
+)
+
+// linked returns the rows derived from t, keyed by kind
+func (t Transaction) linked() map[string]Transaction {
+	rows := map[string]Transaction{}
+	row := func(kind, description string) Transaction {
+		r := Transaction{
+			Md5:         fmt.Sprintf("%x", md5.Sum([]byte(t.Md5+"!"+kind))),
+			Description: description,
+			Currency:    t.Currency,
+			Account:     t.Account,
+			Created:     t.Created,
+			Source:      t.Source,
+			Status:      t.Status,
+			SettledAt:   t.SettledAt,
+			ParentID:    &t.ID,
+			Kind:        kind,
+		}
+		if t.ExternalID != "" {
+			r.ExternalID = t.ExternalID + "/" + kind
+		}
+		return r
+	}
+	if t.RoundUp != nil && *t.RoundUp != 0 {
+		r := row(KindRoundUp, "Round Up")
+		r.Amount = *t.RoundUp
+		rows[KindRoundUp] = r
+	}
+	if t.Cashback != nil && *t.Cashback != 0 {
+		description := t.CashbackDescription
+		if description == "" {
+			description = "Cashback"
+		}
+		r := row(KindCashback, description)
+		r.Amount = *t.Cashback
+		rows[KindCashback] = r
+	}
+	return rows
+}
+
+// saveLinked creates, updates and deletes the rows linked to t so they match
+// its round up and cashback
+func saveLinked(tx *gorm.DB, actor string, t *Transaction) error {
+	var stored []Transaction
+	if err := tx.Where("parent_id = ?", t.ID).Find(&stored).Error; err != nil {
+		return err
+	}
+	wanted := t.linked()
+	for i := range stored {
+		old := stored[i]
+		want, ok := wanted[old.Kind]
+		if !ok {
+			if err := tx.Delete(&stored[i]).Error; err != nil {
+				return err
+			}
+			if err := recordHistory(tx, actor, HistoryDelete, &old, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(wanted, old.Kind)
+		stored[i].Settle(want)
+		stored[i].ParentID, stored[i].Kind = want.ParentID, want.Kind
+		if err := tx.Save(&stored[i]).Error; err != nil {
+			return err
+		}
+		if err := recordHistory(tx, actor, HistoryUpdate, &old, &stored[i]); err != nil {
+			return err
+		}
+	}
+	for _, kind := range []string{KindRoundUp, KindCashback} {
+		row, ok := wanted[kind]
+		if !ok {
+			continue
+		}
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+		if err := recordHistory(tx, actor, HistoryCreate, nil, &row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLinked soft deletes the rows linked to t along with it
+func deleteLinked(tx *gorm.DB, actor string, t *Transaction) error {
+	var stored []Transaction
+	if err := tx.Where("parent_id = ?", t.ID).Find(&stored).Error; err != nil {
+		return err
+	}
+	for i := range stored {
+		if err := tx.Delete(&stored[i]).Error; err != nil {
+			return err
+		}
+		if err := recordHistory(tx, actor, HistoryDelete, &stored[i], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
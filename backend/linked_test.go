@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkedTransactions(t *testing.T) {
+	defer useDatabase()()
+
+	post := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/transactions", bytes.NewBufferString(body))
+		CreateTransaction(c)
+		b, _ := ioutil.ReadAll(w.Body)
+		return w.Code, string(b)
+	}
+	linked := func() string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/transactions?parent_id__isnull=false&order_by=id&fields=id,description,amount,external_id,status,parent_id,kind", nil)
+		FindTransactions(c)
+		b, _ := ioutil.ReadAll(w.Body)
+		return string(b)
+	}
+
+	code, body := post(`{"created":"2023-01-02T09:00:00+11:00","amount":"-4.50","description":"CAFE","account":"1234","source":"up","external_id":"up-1","round_up":"abc"}`)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, `{"error":"invalid round_up abc"}`, body)
+
+	code, body = post(`{"created":"2023-01-02T09:00:00+11:00","amount":"-4.50","description":"CAFE","account":"1234","source":"up","external_id":"up-1","status":"HELD","hold_amount":"-4.50","round_up":"-0.50","cashback":"0.45","cashback_description":"Cafe cashback"}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, `{"data":{"id":1,"description":"CAFE","amount":-4.5,"currency":"AUD","account":1234,"created":"2023-01-02T09:00:00+11:00","source":"up","external_id":"up-1","status":"HELD","hold_amount":-4.5,"round_up":-0.5,"cashback":0.45,"cashback_description":"Cafe cashback"}}`, body)
+	assert.Equal(t, `{"data":[`+
+		`{"amount":-0.5,"description":"Round Up","external_id":"up-1/round_up","id":2,"kind":"round_up","parent_id":1,"status":"HELD"},`+
+		`{"amount":0.45,"description":"Cafe cashback","external_id":"up-1/cashback","id":3,"kind":"cashback","parent_id":1,"status":"HELD"}`+
+		`],"next":null,"prev":null,"total":2}`, linked())
+
+	// Settling with a boosted round up and no cashback
+	code, _ = post(`{"created":"2023-01-02T09:00:00+11:00","amount":"-4.60","description":"CAFE","account":"1234","source":"up","external_id":"up-1","status":"SETTLED","hold_amount":"-4.50","round_up":"-1.40","round_up_boost":"-1.00"}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, `{"data":[`+
+		`{"amount":-1.4,"description":"Round Up","external_id":"up-1/round_up","id":2,"kind":"round_up","parent_id":1,"status":"SETTLED"}`+
+		`],"next":null,"prev":null,"total":1}`, linked())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Request, _ = http.NewRequest("DELETE", "/transaction/1", nil)
+	DeleteTransaction(c)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"data":[],"next":null,"prev":null,"total":0}`, linked())
+
+	var history []TransactionHistory
+	DB.Where("transaction_id = ?", 2).Order("id").Find(&history)
+	actions := []string{}
+	for _, h := range history {
+		actions = append(actions, h.Action)
+	}
+	assert.Equal(t, []string{HistoryCreate, HistoryUpdate, HistoryDelete}, actions)
+}
@@ -18,6 +18,8 @@ var (
 	database_path = kingpin.Flag("database", "Backend database").Default("backend.db").Short('d').String()
 	verbose       = kingpin.Flag("verbose", "Verbosity").Short('v').Bool()
 	port          = kingpin.Flag("port", "Port").Short('p').Default("8080").String()
+
+	serveCmd = kingpin.Command("serve", "Run the API server").Default()
 )
 
 func main() {
@@ -34,7 +36,7 @@ func main() {
 	_, span := tracer.Start(ctx, "main")
 	defer span.End()
 
-	kingpin.Parse()
+	command := kingpin.Parse()
 	zerolog.DurationFieldUnit = time.Millisecond
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	ConnectDatabase(ctx, *database_path, *verbose)
@@ -42,7 +44,32 @@ func main() {
 		zerolog.SetGlobalLevel(zerolog.TraceLevel)
 	}
 
-	// Connect to database
+	if command == importCmd.FullCommand() {
+		if err := runImport(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Import failed")
+		}
+		return
+	}
+	if command == ratesCmd.FullCommand() {
+		if err := runRates(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Loading rates failed")
+		}
+		return
+	}
+
+	if command == reconcileCmd.FullCommand() {
+		if err := runReconcile(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Reconcile failed")
+		}
+		return
+	}
+
+	if command == transfersCmd.FullCommand() {
+		if err := runTransfers(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Transfer detection failed")
+		}
+		return
+	}
 
 	// Run the server
 	log.Info().Msgf("Server running on port %s", *port)
@@ -61,9 +88,31 @@ func setupServer(debug bool) *gin.Engine {
 	r.GET("/accounts/:id", FindAccount)
 	r.POST("/accounts", CreateAccount)
 	r.PATCH("/accounts/:id", UpdateAccount)
+	r.DELETE("/accounts/:id", DeleteAccount)
 	r.GET("/transactions", FindTransactions)
+	r.GET("/transactions/summary", SummariseTransactions)
+	r.GET("/transactions/export", ExportTransactions)
 	r.GET("/transaction/:id", FindTransaction)
+	r.PATCH("/transaction/:id", UpdateTransaction)
+	r.DELETE("/transaction/:id", DeleteTransaction)
+	r.GET("/transaction/:id/history", FindTransactionHistory)
 	r.POST("/transactions", CreateTransaction)
+	r.POST("/transactions/import", ImportTransactions)
+	r.POST("/transactions/reconcile", ReconcileTransactions)
+	r.DELETE("/transactions", DeleteTransactions)
+	r.GET("/duplicates", FindDuplicates)
+	r.PATCH("/duplicates/:id", UpdateDuplicate)
+	r.GET("/transfers", FindTransfers)
+	r.POST("/transfers/detect", DetectTransfersHandler)
+	r.PATCH("/transfers/:id", UpdateTransfer)
+	r.GET("/rules", FindRules)
+	r.POST("/rules", CreateRule)
+	r.DELETE("/rules/:id", DeleteRule)
+	r.POST("/categorise", Categorise)
+	r.GET("/categories", FindCategories)
+	r.POST("/categories", SaveCategories)
+	r.GET("/rates", FindRates)
+	r.POST("/rates", LoadRatesFile)
 
 	return r
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codingric/moneyman/backend/fx"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Migrate the schema, converting databases from before amounts were stored
+// as cents on the way
+func migrate(db *gorm.DB) error {
+	legacy := db.Migrator().HasTable(&Transaction{}) && !db.Migrator().HasColumn(&Transaction{}, "currency")
+	if legacy {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migrateCents(tx, &Transaction{}, "transactions", map[string]string{"currency": "'AUD'"}, "amount"); err != nil {
+				return err
+			}
+			if tx.Migrator().HasTable(&Rule{}) {
+				return migrateCents(tx, &Rule{}, "rules", nil, "amount_min", "amount_max")
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("migrating amounts to cents: %w", err)
+		}
+		log.Info().Msg("Migrated amounts to cents")
+	}
+	unsourced := db.Migrator().HasTable(&Transaction{}) && !db.Migrator().HasColumn(&Transaction{}, "source")
+
+	for _, model := range []interface{}{&Account{}, &Transaction{}, &Rule{}, &fx.Rate{}, &TransactionHistory{}, &Duplicate{}, &Category{}, &Transfer{}} {
+		if err := db.AutoMigrate(model); err != nil {
+			return err
+		}
+	}
+
+	// Only the Up webhook sent external ids before sources were recorded
+	if unsourced {
+		if err := db.Model(&Transaction{}).Unscoped().Where("COALESCE(external_id, '') <> ''").Update("source", "up").Error; err != nil {
+			return fmt.Errorf("migrating sources: %w", err)
+		}
+	}
+	return nil
+}
+
+// migrateCents rebuilds table for model with the given dollar columns
+// converted to integer cents. SQLite can't change a column's type in place,
+// so the rows are copied into a freshly migrated table. Columns missing from
+// the old table are filled from defaults.
+func migrateCents(tx *gorm.DB, model interface{}, table string, defaults map[string]string, columns ...string) error {
+	old := table + "_float"
+	var indexes []string
+	if err := tx.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).Scan(&indexes).Error; err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err := tx.Exec(fmt.Sprintf("DROP INDEX `%s`", index)).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Migrator().RenameTable(table, old); err != nil {
+		return err
+	}
+	if err := tx.AutoMigrate(model); err != nil {
+		return err
+	}
+
+	existing, err := tx.Migrator().ColumnTypes(old)
+	if err != nil {
+		return err
+	}
+	has := map[string]bool{}
+	for _, c := range existing {
+		has[c.Name()] = true
+	}
+	current, err := tx.Migrator().ColumnTypes(model)
+	if err != nil {
+		return err
+	}
+
+	var names, values []string
+	for _, c := range current {
+		name := c.Name()
+		switch {
+		case has[name] && contains(columns, name):
+			values = append(values, fmt.Sprintf("CAST(ROUND(`%s` * 100) AS INTEGER)", name))
+		case has[name]:
+			values = append(values, fmt.Sprintf("`%s`", name))
+		case defaults[name] != "":
+			values = append(values, defaults[name])
+		default:
+			continue
+		}
+		names = append(names, fmt.Sprintf("`%s`", name))
+	}
+	copy := fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s`", table, strings.Join(names, ","), strings.Join(values, ","), old)
+	if err := tx.Exec(copy).Error; err != nil {
+		return err
+	}
+	return tx.Migrator().DropTable(old)
+}
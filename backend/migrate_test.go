@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateCents(t *testing.T) {
+	saved := DB
+	defer func() { DB = saved }()
+
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open(driverName, path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE `transactions` (`id` integer,`md5` text UNIQUE,`description` text,`amount` real,`account` integer,`created` datetime,`category` text,`tags` text,PRIMARY KEY (`id`))",
+		"CREATE INDEX `idx_transactions_category` ON `transactions`(`category`)",
+		"CREATE TABLE `rules` (`id` integer,`priority` integer,`pattern` text,`amount_min` real,`amount_max` real,`account` integer,`category` text,`tags` text,PRIMARY KEY (`id`))",
+		"INSERT INTO `transactions` VALUES (1,'a','AHM',-994.86,1,'2000-01-01 00:00:00','health','')",
+		"INSERT INTO `transactions` VALUES (2,'b','SALARY',4800,1,'2000-01-02 00:00:00',NULL,NULL)",
+		"INSERT INTO `rules` VALUES (1,0,'AHM',-1000.5,NULL,NULL,'health','')",
+	} {
+		if _, err := legacy.Exec(stmt); !assert.NoError(t, err, stmt) {
+			return
+		}
+	}
+	legacy.Close()
+
+	ConnectDatabase(context.Background(), path, false)
+
+	var transactions []Transaction
+	assert.NoError(t, DB.Order("id").Find(&transactions).Error)
+	if assert.Len(t, transactions, 2) {
+		assert.Equal(t, "-994.86", transactions[0].Amount.String())
+		assert.Equal(t, "AUD", transactions[0].Currency)
+		assert.Equal(t, "health", transactions[0].Category)
+		assert.Equal(t, "4800.00", transactions[1].Amount.String())
+	}
+
+	var count int64
+	DB.Model(&Transaction{}).Where("amount = ?", -99486).Count(&count)
+	assert.Equal(t, int64(1), count)
+	assert.True(t, DB.Migrator().HasIndex(&Transaction{}, "idx_transactions_category"))
+
+	var rule Rule
+	assert.NoError(t, DB.First(&rule).Error)
+	if assert.NotNil(t, rule.AmountMin) {
+		assert.Equal(t, "-1000.50", rule.AmountMin.String())
+	}
+	assert.Nil(t, rule.AmountMax)
+
+	// Already migrated databases are left alone
+	ConnectDatabase(context.Background(), path, false)
+	assert.NoError(t, DB.Order("id").Find(&transactions).Error)
+	assert.Equal(t, "-994.86", transactions[0].Amount.String())
+}
+
+func TestMigrateSource(t *testing.T) {
+	saved := DB
+	defer func() { DB = saved }()
+
+	path := filepath.Join(t.TempDir(), "unsourced.db")
+	legacy, err := sql.Open(driverName, path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE `transactions` (`id` integer,`md5` text UNIQUE,`description` text,`amount` integer,`currency` text,`account` integer,`created` datetime,`category` text,`tags` text,`foreign_amount` integer,`foreign_currency` text,`external_id` text,`status` text,`settled_at` datetime,`deleted_at` datetime,PRIMARY KEY (`id`))",
+		"INSERT INTO `transactions` (`id`,`md5`,`description`,`amount`,`currency`,`account`,`created`,`external_id`) VALUES (1,'a','WOOLWORTHS',-1250,'AUD',1,'2000-01-01 00:00:00','up-1')",
+		"INSERT INTO `transactions` (`id`,`md5`,`description`,`amount`,`currency`,`account`,`created`,`external_id`) VALUES (2,'b','SALARY',480000,'AUD',1,'2000-01-02 00:00:00',NULL)",
+	} {
+		if _, err := legacy.Exec(stmt); !assert.NoError(t, err, stmt) {
+			return
+		}
+	}
+	legacy.Close()
+
+	ConnectDatabase(context.Background(), path, false)
+
+	var transactions []Transaction
+	assert.NoError(t, DB.Order("id").Find(&transactions).Error)
+	if assert.Len(t, transactions, 2) {
+		assert.Equal(t, "up", transactions[0].Source)
+		assert.Equal(t, "", transactions[1].Source)
+	}
+	assert.True(t, DB.Migrator().HasIndex(&Transaction{}, "idx_transactions_source_external"))
+}
@@ -0,0 +1,130 @@
+// Package money holds amounts as integer minor units so they can be stored
+// and compared exactly.
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Currency of amounts that don't name one
+const DefaultCurrency = "AUD"
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ParseCurrency checks s is an ISO 4217 style code, e.g. aud or USD
+func ParseCurrency(s string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(s))
+	if !currencyCode.MatchString(c) {
+		return "", fmt.Errorf("invalid currency %s", s)
+	}
+	return c, nil
+}
+
+// Amount in cents, it is read and written as a decimal such as -994.86
+type Amount int64
+
+// Parse a decimal amount with at most two decimal places, e.g. -994.86 or 4800
+func Parse(s string) (Amount, error) {
+	v := strings.TrimSpace(s)
+	neg := false
+	switch {
+	case strings.HasPrefix(v, "-"):
+		neg, v = true, v[1:]
+	case strings.HasPrefix(v, "+"):
+		v = v[1:]
+	}
+	whole, frac := v, ""
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		whole, frac = v[:i], v[i+1:]
+	}
+	if (whole == "" && frac == "") || len(frac) > 2 || !digits(whole) || !digits(frac) {
+		return 0, fmt.Errorf("invalid amount %s", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	cents, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %s", s)
+	}
+	if neg {
+		cents = -cents
+	}
+	return Amount(cents), nil
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FromFloat rounds f to the nearest cent
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * 100))
+}
+
+func (a Amount) Float() float64 {
+	return float64(a) / 100
+}
+
+// String with two decimal places, e.g. -12.50
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Shortest decimal, e.g. -12.5 or 4800, as float amounts were rendered
+func (a Amount) MarshalJSON() ([]byte, error) {
+	s := strings.TrimSuffix(strings.TrimRight(a.String(), "0"), ".")
+	return []byte(s), nil
+}
+
+// Amounts may be given as JSON numbers or decimal strings
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	v, err := Parse(string(bytes.Trim(b, `"`)))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+// Scan integer cents, floats are rounded so columns with real affinity still
+// read back exactly
+func (a *Amount) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*a = 0
+	case int64:
+		*a = Amount(v)
+	case float64:
+		*a = Amount(math.Round(v))
+	case []byte:
+		return a.Scan(string(v))
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", v)
+		}
+		*a = Amount(math.Round(f))
+	default:
+		return fmt.Errorf("unsupported amount %T", value)
+	}
+	return nil
+}
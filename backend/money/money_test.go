@@ -0,0 +1,79 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect Amount
+		err    string
+	}{
+		{"-994.86", -99486, ""},
+		{"4800", 480000, ""},
+		{"12.5", 1250, ""},
+		{"+0.07", 7, ""},
+		{".5", 50, ""},
+		{" -1.00 ", -100, ""},
+		{"1.005", 0, "invalid amount 1.005"},
+		{"1,000", 0, "invalid amount 1,000"},
+		{"-", 0, "invalid amount -"},
+		{"", 0, "invalid amount "},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(tt *testing.T) {
+			a, err := Parse(test.input)
+			if test.err != "" {
+				if assert.Error(tt, err) {
+					assert.Equal(tt, test.err, err.Error())
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expect, a)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "-994.86", Amount(-99486).String())
+	assert.Equal(t, "-0.05", Amount(-5).String())
+	assert.Equal(t, "4800.00", Amount(480000).String())
+	assert.Equal(t, -994.86, Amount(-99486).Float())
+	assert.Equal(t, Amount(-99486), FromFloat(-994.86))
+}
+
+func TestJSON(t *testing.T) {
+	b, _ := json.Marshal([]Amount{-99486, -1250, 480000, 0, -5})
+	assert.Equal(t, "[-994.86,-12.5,4800,0,-0.05]", string(b))
+
+	var a []Amount
+	assert.NoError(t, json.Unmarshal([]byte(`[-994.86,"12.5",4800]`), &a))
+	assert.Equal(t, []Amount{-99486, 1250, 480000}, a)
+	assert.Error(t, json.Unmarshal([]byte(`[1.005]`), &a))
+}
+
+func TestScan(t *testing.T) {
+	var a Amount
+	assert.NoError(t, a.Scan(int64(-99486)))
+	assert.Equal(t, Amount(-99486), a)
+	assert.NoError(t, a.Scan(-99485.99999))
+	assert.Equal(t, Amount(-99486), a)
+	assert.NoError(t, a.Scan([]byte("1250")))
+	assert.Equal(t, Amount(1250), a)
+	assert.Error(t, a.Scan("x"))
+}
+
+func TestParseCurrency(t *testing.T) {
+	c, err := ParseCurrency("usd")
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", c)
+	_, err = ParseCurrency("dollars")
+	if assert.Error(t, err) {
+		assert.Equal(t, "invalid currency dollars", err.Error())
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Query parameters that control paging, sorting and projection. They are
+// reserved and never treated as filters.
+const (
+	ParamLimit   = "limit"
+	ParamOffset  = "offset"
+	ParamOrderBy = "order_by"
+	ParamFields  = "fields"
+)
+
+// Columns of Transaction that may be sorted on or projected
+var TransactionColumns = []string{"id", "description", "amount", "currency", "account", "created", "category", "tags", "foreign_amount", "foreign_currency", "source", "external_id", "status", "settled_at", "raw_text", "message", "source_category", "source_parent_category", "hold_amount", "round_up", "round_up_boost", "cashback", "cashback_description", "parent_id", "kind"}
+
+type Page struct {
+	Limit   int
+	Offset  int
+	OrderBy []string
+	Fields  []string
+}
+
+func IsPageParam(param string) bool {
+	switch param {
+	case ParamLimit, ParamOffset, ParamOrderBy, ParamFields:
+		return true
+	}
+	return false
+}
+
+// Parse limit, offset, order_by and fields from query values, validating
+// field names against columns
+func ParsePage(values url.Values, columns []string) (page Page, err error) {
+	if v := values.Get(ParamLimit); v != "" {
+		if page.Limit, err = strconv.Atoi(v); err != nil || page.Limit < 0 {
+			return page, fmt.Errorf("invalid limit %s", v)
+		}
+	}
+	if v := values.Get(ParamOffset); v != "" {
+		if page.Offset, err = strconv.Atoi(v); err != nil || page.Offset < 0 {
+			return page, fmt.Errorf("invalid offset %s", v)
+		}
+	}
+	if v := values.Get(ParamOrderBy); v != "" {
+		for _, o := range strings.Split(v, ",") {
+			if !contains(columns, strings.TrimPrefix(o, "-")) {
+				return page, fmt.Errorf("invalid order_by %s", o)
+			}
+			page.OrderBy = append(page.OrderBy, o)
+		}
+	}
+	if v := values.Get(ParamFields); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			if !contains(columns, f) {
+				return page, fmt.Errorf("invalid field %s", f)
+			}
+			page.Fields = append(page.Fields, f)
+		}
+	}
+	return page, nil
+}
+
+// Apply ordering, limit and offset to query
+func (p Page) Apply(query *gorm.DB) *gorm.DB {
+	if len(p.OrderBy) == 0 {
+		query = query.Order("id")
+	}
+	for _, o := range p.OrderBy {
+		if strings.HasPrefix(o, "-") {
+			query = query.Order(strings.TrimPrefix(o, "-") + " DESC")
+		} else {
+			query = query.Order(o)
+		}
+	}
+	if len(p.Fields) > 0 {
+		query = query.Select(p.Fields)
+	}
+	if p.Limit > 0 {
+		query = query.Limit(p.Limit)
+	}
+	if p.Offset > 0 {
+		query = query.Offset(p.Offset)
+	}
+	return query
+}
+
+// Links to the next and previous pages of u, nil when there is no such page
+func (p Page) Links(u *url.URL, total int64) (next, prev *string) {
+	if p.Limit == 0 {
+		return
+	}
+	link := func(offset int) *string {
+		q := u.Query()
+		q.Set(ParamOffset, strconv.Itoa(offset))
+		l := url.URL{Path: u.Path, RawQuery: q.Encode()}
+		s := l.String()
+		return &s
+	}
+	if int64(p.Offset+p.Limit) < total {
+		next = link(p.Offset + p.Limit)
+	}
+	if p.Offset > 0 {
+		o := p.Offset - p.Limit
+		if o < 0 {
+			o = 0
+		}
+		prev = link(o)
+	}
+	return
+}
+
+// Project rows down to the requested fields, rows are returned untouched when
+// no fields were requested
+func (p Page) Project(rows interface{}) (interface{}, error) {
+	if len(p.Fields) == 0 {
+		return rows, nil
+	}
+	raw, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+	var full []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &full); err != nil {
+		return nil, err
+	}
+	projected := make([]map[string]json.RawMessage, len(full))
+	for i, row := range full {
+		projected[i] = map[string]json.RawMessage{}
+		for _, f := range p.Fields {
+			projected[i][f] = row[f]
+		}
+	}
+	return projected, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/codingric/moneyman/backend/fx"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ratesCmd  = kingpin.Command("rates", "Load exchange rates from a date,from,to,rate CSV")
+	ratesFile = ratesCmd.Arg("file", "Rates to load").Required().ExistingFile()
+)
+
+// StoreRates saves rates, replacing any already stored for the same day and
+// pair
+func StoreRates(ctx context.Context, rates []fx.Rate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	return DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "from_currency"}, {Name: "to_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate"}),
+	}).Create(&rates).Error
+}
+
+// LoadRates reads the whole rate table, it is small enough to keep in memory
+// for the duration of a report
+func LoadRates(ctx context.Context) (*fx.Table, error) {
+	var rates []fx.Rate
+	if err := DB.WithContext(ctx).Find(&rates).Error; err != nil {
+		return nil, err
+	}
+	return fx.NewTable(rates), nil
+}
+
+// GET /rates
+// Find all exchange rates
+func FindRates(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "rates.FindRates")
+	defer span.End()
+
+	rates := []fx.Rate{}
+	if err := DB.WithContext(ctx).Order("date, from_currency, to_currency").Find(&rates).Error; err != nil {
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": rates})
+}
+
+// POST /rates
+// Load exchange rates from a CSV uploaded as the multipart `file` field
+func LoadRatesFile(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "rates.LoadRatesFile")
+	defer span.End()
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Missing file")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to read file")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to read file"})
+		return
+	}
+	defer file.Close()
+
+	rates, err := fx.Load(file)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := StoreRates(ctx, rates); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to store rates")
+		log.Error().Err(err).Msg("Unable to store rates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to store rates"})
+		return
+	}
+	span.AddEvent("Rates loaded", trace.WithAttributes(
+		attribute.Int("result.count", len(rates)),
+	))
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"loaded": len(rates)}})
+}
+
+// runRates loads the rates file given on the command line
+func runRates(ctx context.Context) error {
+	file, err := os.Open(*ratesFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rates, err := fx.Load(file)
+	if err != nil {
+		return err
+	}
+	if err := StoreRates(ctx, rates); err != nil {
+		return err
+	}
+	log.Info().Msgf("Loaded %d rates from %s", len(rates), *ratesFile)
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codingric/moneyman/backend/fx"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRatesFile(t *testing.T) {
+	defer useDatabase()()
+
+	tests := []struct {
+		name        string
+		file        string
+		status_code int
+		expect      string
+	}{
+		{
+			"MissingFile",
+			"",
+			400,
+			`{"error":"Missing file"}`,
+		},
+		{
+			"InvalidRate",
+			"date,from,to,rate\n2023-01-01,USD,AUD,x\n",
+			400,
+			`{"error":"line 2: invalid rate x"}`,
+		},
+		{
+			"Loaded",
+			"date,from,to,rate\n2023-01-01,USD,AUD,1.47\n2023-01-02,USD,AUD,1.45\n",
+			200,
+			`{"data":{"loaded":2}}`,
+		},
+		{
+			"Replaced",
+			"date,from,to,rate\n2023-01-02,USD,AUD,1.46\n",
+			200,
+			`{"data":{"loaded":1}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			if test.file != "" {
+				part, _ := writer.CreateFormFile("file", "rates.csv")
+				part.Write([]byte(test.file))
+			}
+			writer.Close()
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/rates", body)
+			c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+
+			LoadRatesFile(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/rates", nil)
+
+	FindRates(c)
+
+	assert.Equal(t, 200, w.Code)
+	b, _ := ioutil.ReadAll(w.Body)
+	assert.Equal(t, `{"data":[{"date":"2023-01-01T00:00:00Z","from":"USD","to":"AUD","rate":1.47},{"date":"2023-01-02T00:00:00Z","from":"USD","to":"AUD","rate":1.46}]}`, string(b))
+}
+
+func TestSummariseTransactionsBase(t *testing.T) {
+	defer useDatabase()()
+
+	loc := time.FixedZone("AEDT", 11*60*60)
+	DB.Create(&Transaction{Md5: "1", Description: "WOOLWORTHS", Amount: -3000, Account: 1, Created: time.Date(2023, time.January, 1, 10, 0, 0, 0, loc)})
+	DB.Create(&Transaction{Md5: "2", Description: "MACYS", Amount: -1000, Currency: "USD", Account: 1, Created: time.Date(2023, time.January, 2, 10, 0, 0, 0, loc)})
+	DB.Create(&Transaction{Md5: "3", Description: "SALARY", Amount: 150000, Account: 2, Created: time.Date(2023, time.January, 3, 10, 0, 0, 0, loc)})
+	DB.Create(&Transaction{Md5: "4", Description: "HARRODS", Amount: -1000, Currency: "GBP", Account: 2, Created: time.Date(2023, time.January, 3, 10, 0, 0, 0, loc)})
+	StoreRates(context.Background(), []fx.Rate{{Date: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), From: "USD", To: "AUD", Rate: 1.5}})
+
+	tests := []struct {
+		name        string
+		url         string
+		status_code int
+		expect      string
+	}{
+		{
+			"NoRates",
+			"x?base=EUR",
+			400,
+			`{"error":"no AUD/EUR rate on 2023-01-01"}`,
+		},
+		{
+			"Base",
+			"x?base=aud&currency__ne=GBP",
+			200,
+			`{"data":[{"avg":485,"count":3,"max":1500,"min":-30,"sum":1455}]}`,
+		},
+		{
+			"BaseGrouped",
+			"x?base=USD&group_by=account&currency__ne=GBP",
+			200,
+			`{"data":[{"account":1,"avg":-15,"count":2,"max":-10,"min":-20,"sum":-30},{"account":2,"avg":1000,"count":1,"max":1000,"min":1000,"sum":1000}]}`,
+		},
+		{
+			"BaseEmpty",
+			"x?base=USD&amount__gt=1000000",
+			200,
+			`{"data":[{"avg":null,"count":0,"max":null,"min":null,"sum":0}]}`,
+		},
+		{
+			"InvalidBase",
+			"x?base=dollars",
+			400,
+			`{"error":"invalid base dollars"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", test.url, nil)
+
+			SummariseTransactions(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DuplicatePending   = "pending"
+	DuplicateConfirmed = "confirmed"
+	DuplicateDismissed = "dismissed"
+
+	// Days apart two reports of the same payment may be dated, bank emails
+	// are sent straight away while settlement can take a few days
+	DefaultReconcileDays = 3
+)
+
+var (
+	reconcileCmd  = kingpin.Command("reconcile", "Link transactions reported by more than one source for review")
+	reconcileDays = reconcileCmd.Flag("days", "Days apart duplicates may be dated").Default(strconv.Itoa(DefaultReconcileDays)).Int()
+)
+
+// Duplicate links two transactions from different sources that look like the
+// same payment. Confirming it deletes Other, dismissing it keeps both and the
+// pair isn't linked again.
+type Duplicate struct {
+	ID            uint         `json:"id" gorm:"primary_key"`
+	TransactionID uint         `json:"transaction_id" gorm:"uniqueIndex:idx_duplicates_pair"`
+	OtherID       uint         `json:"other_id" gorm:"uniqueIndex:idx_duplicates_pair"`
+	Status        string       `json:"status" gorm:"index"`
+	Created       time.Time    `json:"created"`
+	Transaction   *Transaction `json:"transaction,omitempty"`
+	Other         *Transaction `json:"other,omitempty"`
+}
+
+type UpdateDuplicateInput struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// Reconcile links transactions of the same account and amount from different
+// sources dated at most days apart, returning the number of new links. Rows
+// linked to a purchase, e.g. round ups, are left out.
+func Reconcile(ctx context.Context, days int) (int, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "reconcile.Reconcile")
+	defer span.End()
+
+	var pairs []struct {
+		TransactionID uint
+		OtherID       uint
+	}
+	err := DB.WithContext(ctx).Raw(`SELECT a.id AS transaction_id, b.id AS other_id
+		FROM transactions a JOIN transactions b
+		ON b.account = a.account AND b.amount = a.amount AND b.currency = a.currency AND b.id > a.id
+		AND COALESCE(b.source, '') <> COALESCE(a.source, '')
+		AND ABS(julianday(b.created) - julianday(a.created)) <= ?
+		WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL AND a.parent_id IS NULL AND b.parent_id IS NULL`, days).Scan(&pairs).Error
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	duplicates := []Duplicate{}
+	for _, p := range pairs {
+		duplicates = append(duplicates, Duplicate{TransactionID: p.TransactionID, OtherID: p.OtherID, Status: DuplicatePending, Created: now()})
+	}
+	if len(duplicates) == 0 {
+		return 0, nil
+	}
+
+	result := DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&duplicates)
+	if result.Error != nil {
+		span.RecordError(result.Error)
+		return 0, result.Error
+	}
+	span.AddEvent("Duplicates linked", trace.WithAttributes(
+		attribute.Int64("result.count", result.RowsAffected),
+	))
+	return int(result.RowsAffected), nil
+}
+
+// POST /transactions/reconcile
+// Link likely duplicates from different sources, ?days= sets how far apart
+// they may be dated
+func ReconcileTransactions(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "reconcile.ReconcileTransactions")
+	defer span.End()
+
+	days := DefaultReconcileDays
+	if param := c.Query("days"); param != "" {
+		d, err := strconv.Atoi(param)
+		if err != nil || d < 0 {
+			span.SetStatus(codes.Error, "Invalid days")
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid days %s", param)})
+			return
+		}
+		days = d
+	}
+
+	linked, err := Reconcile(ctx, days)
+	if err != nil {
+		span.SetStatus(codes.Error, "Unable to reconcile transactions")
+		log.Error().Err(err).Msg("Unable to reconcile transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to reconcile transactions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"linked": linked}})
+}
+
+// GET /duplicates
+// Find linked duplicates with both transactions, ?status= limits them to one
+// status
+func FindDuplicates(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "reconcile.FindDuplicates")
+	defer span.End()
+
+	query := DB.WithContext(ctx).Preload("Transaction", unscoped).Preload("Other", unscoped).Order("id")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	duplicates := []Duplicate{}
+	if err := query.Find(&duplicates).Error; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": duplicates})
+}
+
+// PATCH /duplicates/:id
+// Confirm or dismiss a duplicate, confirming deletes the other transaction
+func UpdateDuplicate(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "reconcile.UpdateDuplicate")
+	defer span.End()
+
+	var duplicate Duplicate
+	if err := DB.WithContext(ctx).Where("id = ?", c.Param("id")).First(&duplicate).Error; err != nil {
+		span.SetStatus(codes.Error, "Record not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	var input UpdateDuplicateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid request parameters")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request parameters"})
+		return
+	}
+	switch {
+	case input.Status != DuplicatePending && input.Status != DuplicateConfirmed && input.Status != DuplicateDismissed:
+		span.SetStatus(codes.Error, "Invalid status")
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid status %s", input.Status)})
+		return
+	case duplicate.Status == DuplicateConfirmed:
+		span.SetStatus(codes.Error, "Duplicate already confirmed")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate already confirmed"})
+		return
+	}
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		duplicate.Status = input.Status
+		if err := tx.Save(&duplicate).Error; err != nil {
+			return err
+		}
+		if input.Status != DuplicateConfirmed {
+			return nil
+		}
+		var other Transaction
+		if err := tx.Where("id = ?", duplicate.OtherID).Limit(1).Find(&other).Error; err != nil || other.ID == 0 {
+			return err
+		}
+		if err := tx.Delete(&other).Error; err != nil {
+			return err
+		}
+		if err := recordHistory(tx, Actor(c), HistoryDelete, &other, nil); err != nil {
+			return err
+		}
+		return deleteLinked(tx, Actor(c), &other)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update duplicate")
+		log.Error().Caller().Err(err).Msg("Failed to update duplicate")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update duplicate"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": duplicate})
+}
+
+// unscoped preloads transactions even when they've since been deleted
+func unscoped(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// runReconcile links duplicates from the command line
+func runReconcile(ctx context.Context) error {
+	linked, err := Reconcile(ctx, *reconcileDays)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Linked %d duplicates", linked)
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	defer useDatabase()()
+	saved := now
+	defer func() { now = saved }()
+	now = func() time.Time { return time.Date(2023, time.February, 1, 9, 0, 0, 0, time.UTC) }
+
+	day := func(d int) time.Time { return time.Date(2023, time.January, d, 10, 0, 0, 0, time.UTC) }
+	DB.Create(&Transaction{Md5: "1", Description: "Woolworths", Amount: -1250, Account: 1, Created: day(1), Source: "mailparser"})
+	DB.Create(&Transaction{Md5: "2", Description: "WOOLWORTHS 1234", Amount: -1250, Account: 1, Created: day(3), Source: "up", ExternalID: "a"})
+	DB.Create(&Transaction{Md5: "3", Description: "WOOLWORTHS 1234", Amount: -1250, Account: 1, Created: day(10), Source: "up", ExternalID: "b"})
+	DB.Create(&Transaction{Md5: "4", Description: "Woolworths", Amount: -1250, Account: 2, Created: day(1), Source: "mailparser"})
+	DB.Create(&Transaction{Md5: "5", Description: "Coles", Amount: -999, Account: 1, Created: day(1), Source: "import"})
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		id          string
+		body        string
+		handler     gin.HandlerFunc
+		status_code int
+		expect      string
+	}{
+		{
+			"InvalidDays",
+			"POST",
+			"/transactions/reconcile?days=x",
+			"",
+			"",
+			ReconcileTransactions,
+			400,
+			`{"error":"invalid days x"}`,
+		},
+		{
+			"Reconcile",
+			"POST",
+			"/transactions/reconcile",
+			"",
+			"",
+			ReconcileTransactions,
+			200,
+			`{"data":{"linked":1}}`,
+		},
+		{
+			"AlreadyLinked",
+			"POST",
+			"/transactions/reconcile",
+			"",
+			"",
+			ReconcileTransactions,
+			200,
+			`{"data":{"linked":0}}`,
+		},
+		{
+			"Tolerance",
+			"POST",
+			"/transactions/reconcile?days=10",
+			"",
+			"",
+			ReconcileTransactions,
+			200,
+			`{"data":{"linked":1}}`,
+		},
+		{
+			"Pending",
+			"GET",
+			"/duplicates?status=pending",
+			"",
+			"",
+			FindDuplicates,
+			200,
+			`{"data":[` +
+				`{"id":1,"transaction_id":1,"other_id":2,"status":"pending","created":"2023-02-01T09:00:00Z",` +
+				`"transaction":{"id":1,"description":"Woolworths","amount":-12.5,"currency":"AUD","account":1,"created":"2023-01-01T10:00:00Z","source":"mailparser"},` +
+				`"other":{"id":2,"description":"WOOLWORTHS 1234","amount":-12.5,"currency":"AUD","account":1,"created":"2023-01-03T10:00:00Z","source":"up","external_id":"a"}},` +
+				`{"id":2,"transaction_id":1,"other_id":3,"status":"pending","created":"2023-02-01T09:00:00Z",` +
+				`"transaction":{"id":1,"description":"Woolworths","amount":-12.5,"currency":"AUD","account":1,"created":"2023-01-01T10:00:00Z","source":"mailparser"},` +
+				`"other":{"id":3,"description":"WOOLWORTHS 1234","amount":-12.5,"currency":"AUD","account":1,"created":"2023-01-10T10:00:00Z","source":"up","external_id":"b"}}` +
+				`]}`,
+		},
+		{
+			"InvalidStatus",
+			"PATCH",
+			"/duplicates/1",
+			"1",
+			`{"status":"maybe"}`,
+			UpdateDuplicate,
+			400,
+			`{"error":"invalid status maybe"}`,
+		},
+		{
+			"Missing",
+			"PATCH",
+			"/duplicates/9",
+			"9",
+			`{"status":"dismissed"}`,
+			UpdateDuplicate,
+			404,
+			`{"error":"Record not found"}`,
+		},
+		{
+			"Dismiss",
+			"PATCH",
+			"/duplicates/2",
+			"2",
+			`{"status":"dismissed"}`,
+			UpdateDuplicate,
+			200,
+			`{"data":{"id":2,"transaction_id":1,"other_id":3,"status":"dismissed","created":"2023-02-01T09:00:00Z"}}`,
+		},
+		{
+			"Confirm",
+			"PATCH",
+			"/duplicates/1",
+			"1",
+			`{"status":"confirmed"}`,
+			UpdateDuplicate,
+			200,
+			`{"data":{"id":1,"transaction_id":1,"other_id":2,"status":"confirmed","created":"2023-02-01T09:00:00Z"}}`,
+		},
+		{
+			"AlreadyConfirmed",
+			"PATCH",
+			"/duplicates/1",
+			"1",
+			`{"status":"pending"}`,
+			UpdateDuplicate,
+			400,
+			`{"error":"duplicate already confirmed"}`,
+		},
+		{
+			"Reviewed",
+			"POST",
+			"/transactions/reconcile?days=10",
+			"",
+			"",
+			ReconcileTransactions,
+			200,
+			`{"data":{"linked":0}}`,
+		},
+		{
+			"DeletedOther",
+			"GET",
+			"/duplicates?status=confirmed",
+			"",
+			"",
+			FindDuplicates,
+			200,
+			`{"data":[` +
+				`{"id":1,"transaction_id":1,"other_id":2,"status":"confirmed","created":"2023-02-01T09:00:00Z",` +
+				`"transaction":{"id":1,"description":"Woolworths","amount":-12.5,"currency":"AUD","account":1,"created":"2023-01-01T10:00:00Z","source":"mailparser"},` +
+				`"other":{"id":2,"description":"WOOLWORTHS 1234","amount":-12.5,"currency":"AUD","account":1,"created":"2023-01-03T10:00:00Z","source":"up","external_id":"a"}}` +
+				`]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(test.method, test.url, bytes.NewBufferString(test.body))
+			c.Params = []gin.Param{{Key: "id", Value: test.id}}
+
+			test.handler(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	// Confirming deleted the other transaction and recorded it
+	var count int64
+	DB.Model(&Transaction{}).Where("id = ?", 2).Count(&count)
+	assert.Equal(t, int64(0), count)
+	DB.Model(&TransactionHistory{}).Where("transaction_id = ? AND action = ?", 2, HistoryDelete).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestExternalIDPerSource(t *testing.T) {
+	defer useDatabase()()
+
+	assert.NoError(t, DB.Create(&Transaction{Md5: "1", Description: "A", Source: "up", ExternalID: "x"}).Error)
+	assert.NoError(t, DB.Create(&Transaction{Md5: "2", Description: "B", Source: "bank", ExternalID: "x"}).Error)
+	assert.Error(t, DB.Create(&Transaction{Md5: "3", Description: "C", Source: "up", ExternalID: "x"}).Error)
+	// Rows without an external id are keyed by md5 alone
+	assert.NoError(t, DB.Create(&Transaction{Md5: "4", Description: "D", Source: "up"}).Error)
+	assert.NoError(t, DB.Create(&Transaction{Md5: "5", Description: "E", Source: "up"}).Error)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/codingric/moneyman/backend/filter"
+	"github.com/codingric/moneyman/backend/money"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Tags are stored as a comma separated string so they can be filtered with
+// `tags__like`
+type Tags []string
+
+func (t Tags) Value() (driver.Value, error) {
+	return strings.Join(t, ","), nil
+}
+
+func (t *Tags) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("unable to scan %T into Tags", value)
+	}
+	*t = nil
+	if s != "" {
+		*t = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// Add tags that are not already present
+func (t *Tags) Add(tags ...string) {
+	for _, tag := range tags {
+		if !contains(*t, tag) {
+			*t = append(*t, tag)
+		}
+	}
+}
+
+// Rule assigns a category and tags to transactions whose description matches
+// Pattern and, when set, whose amount and account match
+type Rule struct {
+	ID        uint          `json:"id" gorm:"primary_key"`
+	Priority  int           `json:"priority"`
+	Pattern   string        `json:"pattern"`
+	AmountMin *money.Amount `json:"amount_min,omitempty"`
+	AmountMax *money.Amount `json:"amount_max,omitempty"`
+	Account   *int64        `json:"account,omitempty"`
+	Category  string        `json:"category"`
+	Tags      Tags          `json:"tags,omitempty"`
+
+	re *regexp.Regexp
+}
+
+type CreateRuleInput struct {
+	Priority  int           `json:"priority"`
+	Pattern   string        `json:"pattern" binding:"required"`
+	AmountMin *money.Amount `json:"amount_min"`
+	AmountMax *money.Amount `json:"amount_max"`
+	Account   *int64        `json:"account"`
+	Category  string        `json:"category"`
+	Tags      []string      `json:"tags"`
+}
+
+func (r *Rule) Compile() (err error) {
+	r.re, err = regexp.Compile(r.Pattern)
+	return
+}
+
+func (r *Rule) Match(t Transaction) bool {
+	if r.re == nil && r.Compile() != nil {
+		return false
+	}
+	if r.AmountMin != nil && t.Amount < *r.AmountMin {
+		return false
+	}
+	if r.AmountMax != nil && t.Amount > *r.AmountMax {
+		return false
+	}
+	if r.Account != nil && t.Account != *r.Account {
+		return false
+	}
+	return r.re.MatchString(t.Description)
+}
+
+type Rules []Rule
+
+// Load all rules in the order they are applied
+func LoadRules(ctx context.Context) (rules Rules, err error) {
+	if err = DB.WithContext(ctx).Order("priority DESC, id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if err = rules[i].Compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", rules[i].ID, err)
+		}
+	}
+	return rules, nil
+}
+
+// Apply rules to t, the first matching rule sets the category and every
+// matching rule adds its tags. Reports whether t was changed.
+func (rules Rules) Apply(t *Transaction) bool {
+	category := ""
+	var tags Tags
+	for i := range rules {
+		if !rules[i].Match(*t) {
+			continue
+		}
+		if category == "" {
+			category = rules[i].Category
+		}
+		tags.Add(rules[i].Tags...)
+	}
+	if category == "" && len(tags) == 0 {
+		return false
+	}
+
+	changed := false
+	if category != "" && category != t.Category {
+		t.Category = category
+		changed = true
+	}
+	before := len(t.Tags)
+	t.Tags.Add(tags...)
+	return changed || len(t.Tags) != before
+}
+
+// GET /rules
+// Find all rules
+func FindRules(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "rule.FindRules")
+	defer span.End()
+
+	var rules []Rule
+	if err := DB.WithContext(ctx).Order("priority DESC, id").Find(&rules).Error; err != nil {
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": rules})
+}
+
+// POST /rules
+// Create new rule
+func CreateRule(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "rule.CreateRule")
+	defer span.End()
+
+	var input CreateRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid request parameters")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request parameters"})
+		return
+	}
+
+	rule := Rule{
+		Priority:  input.Priority,
+		Pattern:   input.Pattern,
+		AmountMin: input.AmountMin,
+		AmountMax: input.AmountMax,
+		Account:   input.Account,
+		Category:  input.Category,
+		Tags:      input.Tags,
+	}
+	if err := rule.Compile(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid pattern")
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid pattern %s", input.Pattern)})
+		return
+	}
+
+	if err := DB.WithContext(ctx).Create(&rule).Error; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to create rule")
+		log.Error().Caller().Err(err).Msg("Failed to create rule")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create rule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": rule})
+}
+
+// DELETE /rules/:id
+// Delete a rule
+func DeleteRule(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "rule.DeleteRule")
+	defer span.End()
+
+	var rule Rule
+	if err := DB.WithContext(ctx).Where("id = ?", c.Param("id")).First(&rule).Error; err != nil {
+		span.SetStatus(codes.Error, "Record not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	DB.WithContext(ctx).Delete(&rule)
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
+
+// POST /categorise
+// Re-apply rules to existing transactions matching the query filters
+func Categorise(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "rule.Categorise")
+	defer span.End()
+
+	parsed, err := filter.Parse(c.Request.URL.Query(), TransactionFields)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := LoadRules(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to load rules")
+		log.Error().Err(err).Msg("Unable to load rules")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to load rules"})
+		return
+	}
+
+	var total, updated int
+	var batch []Transaction
+	result := filter.Apply(DB.WithContext(ctx), parsed).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			total++
+			old := batch[i]
+			old.Tags = append(Tags{}, old.Tags...)
+			if !rules.Apply(&batch[i]) {
+				continue
+			}
+			err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&batch[i]).Select("category", "tags").Updates(&batch[i]).Error; err != nil {
+					return err
+				}
+				return recordHistory(tx, Actor(c), HistoryUpdate, &old, &batch[i])
+			})
+			if err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	if result.Error != nil {
+		span.RecordError(result.Error)
+		span.SetStatus(codes.Error, "Unable to categorise transactions")
+		log.Error().Err(result.Error).Msg("Unable to categorise transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to categorise transactions"})
+		return
+	}
+
+	span.AddEvent("Transactions categorised", trace.WithAttributes(
+		attribute.Int("result.total", total),
+		attribute.Int("result.updated", updated),
+	))
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"total": total, "updated": updated}})
+}
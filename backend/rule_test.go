@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codingric/moneyman/backend/money"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// useDatabase swaps DB for a fresh in-memory database until the returned
+// function is called
+func useDatabase() func() {
+	saved := DB
+	ConnectDatabase(context.Background(), ":memory:", false)
+	return func() { DB = saved }
+}
+
+func TestRulesApply(t *testing.T) {
+	min, max := money.Amount(-10000), money.Amount(0)
+	account := int64(1234)
+	rules := Rules{
+		{Pattern: "(?i)woolworths", AmountMin: &min, AmountMax: &max, Category: "groceries", Tags: Tags{"food"}},
+		{Pattern: "WOOLWORTHS", Category: "shopping", Tags: Tags{"large"}},
+		{Pattern: "AMAZON", Account: &account, Category: "online"},
+	}
+
+	type E struct {
+		changed  bool
+		category string
+		tags     Tags
+	}
+	tests := []struct {
+		name        string
+		transaction Transaction
+		expect      E
+	}{
+		{"NoMatch", Transaction{Description: "COLES"}, E{}},
+		{"FirstRuleWins", Transaction{Description: "WOOLWORTHS 1234", Amount: -2000}, E{true, "groceries", Tags{"food", "large"}}},
+		{"AmountOutOfRange", Transaction{Description: "WOOLWORTHS 1234", Amount: -20000}, E{true, "shopping", Tags{"large"}}},
+		{"CaseInsensitive", Transaction{Description: "woolworths", Amount: -2000}, E{true, "groceries", Tags{"food"}}},
+		{"Account", Transaction{Description: "AMAZON AU", Account: 1234}, E{true, "online", nil}},
+		{"WrongAccount", Transaction{Description: "AMAZON AU", Account: 5678}, E{}},
+		{"Unchanged", Transaction{Description: "AMAZON AU", Account: 1234, Category: "online"}, E{false, "online", nil}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			changed := rules.Apply(&test.transaction)
+			assert.Equal(tt, test.expect.changed, changed)
+			assert.Equal(tt, test.expect.category, test.transaction.Category)
+			assert.Equal(tt, test.expect.tags, test.transaction.Tags)
+		})
+	}
+}
+
+func TestCreateRule(t *testing.T) {
+	defer useDatabase()()
+
+	tests := []struct {
+		name        string
+		post        []byte
+		status_code int
+		expect      string
+	}{
+		{
+			"InvalidRequest",
+			[]byte(`{}`),
+			400,
+			`{"error":"Invalid request parameters"}`,
+		},
+		{
+			"InvalidPattern",
+			[]byte(`{"pattern":"(","category":"groceries"}`),
+			400,
+			`{"error":"invalid pattern ("}`,
+		},
+		{
+			"Successful",
+			[]byte(`{"pattern":"WOOLWORTHS","amount_max":0,"category":"groceries","tags":["food","weekly"]}`),
+			200,
+			`{"data":{"id":1,"priority":0,"pattern":"WOOLWORTHS","amount_max":0,"category":"groceries","tags":["food","weekly"]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/rules", bytes.NewBuffer(test.post))
+
+			CreateRule(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+}
+
+func TestCategorise(t *testing.T) {
+	defer useDatabase()()
+
+	created := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	DB.Create(&Transaction{Md5: "1", Description: "WOOLWORTHS 1234", Amount: -2000, Created: created})
+	DB.Create(&Transaction{Md5: "2", Description: "AHM HEALTH", Amount: -38791, Created: created})
+	DB.Create(&Rule{Pattern: "WOOLWORTHS", Category: "groceries", Tags: Tags{"food"}})
+
+	tests := []struct {
+		name        string
+		url         string
+		status_code int
+		expect      string
+	}{
+		{
+			"InvalidFilter",
+			"/categorise?md5=1",
+			400,
+			`{"error":"invalid field md5"}`,
+		},
+		{
+			"Filtered",
+			"/categorise?description__like=AHM",
+			200,
+			`{"data":{"total":1,"updated":0}}`,
+		},
+		{
+			"All",
+			"/categorise",
+			200,
+			`{"data":{"total":2,"updated":1}}`,
+		},
+		{
+			"Idempotent",
+			"/categorise",
+			200,
+			`{"data":{"total":2,"updated":0}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", test.url, nil)
+
+			Categorise(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "x?category=groceries&fields=id,category,tags", nil)
+	FindTransactions(c)
+	b, _ := ioutil.ReadAll(w.Body)
+	assert.Equal(t, `{"data":[{"category":"groceries","id":1,"tags":["food"]}],"next":null,"prev":null,"total":1}`, string(b))
+}
+
+func TestCreateTransactionCategorised(t *testing.T) {
+	defer useDatabase()()
+	DB.Create(&Rule{Pattern: "AHM", Category: "health", Tags: Tags{"insurance"}})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/transactions", bytes.NewBufferString(`{"created":"2000-01-01T00:00:01+11:00","amount":"-387.91","description":"AHM HEALTH","account":"1234567890"}`))
+
+	CreateTransaction(c)
+
+	assert.Equal(t, 200, w.Code)
+	b, _ := ioutil.ReadAll(w.Body)
+	assert.Equal(t, `{"data":{"id":1,"description":"AHM HEALTH","amount":-387.91,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00","category":"health","tags":["insurance"]}}`, string(b))
+}
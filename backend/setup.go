@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gorm.io/driver/sqlite"
@@ -16,6 +19,19 @@ import (
 var DB *gorm.DB
 var Debug bool
 
+// SQLite driver with a REGEXP function registered, used by the regex filter
+const driverName = "sqlite3_regexp"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", func(re, s string) (bool, error) {
+				return regexp.MatchString(re, s)
+			}, true)
+		},
+	})
+}
+
 /*
 type Interface interface {
   LogMode(LogLevel) Interface
@@ -48,7 +64,7 @@ func ConnectDatabase(ctx context.Context, path string, debug bool) {
 	Debug = debug
 
 	DB, err = gorm.Open(
-		sqlite.Open(path),
+		&sqlite.Dialector{DriverName: driverName, DSN: path},
 		&gorm.Config{
 			Logger: zerologger{
 				Logger: &log.Logger,
@@ -59,8 +75,9 @@ func ConnectDatabase(ctx context.Context, path string, debug bool) {
 		log.Panic().Msg("Failed to connect to database")
 	}
 
-	DB.AutoMigrate(&Account{})
-	DB.AutoMigrate(&Transaction{})
+	if err := migrate(DB); err != nil {
+		log.Panic().Err(err).Msg("Failed to migrate database")
+	}
 
 	if debug {
 		DB = DB.Debug()
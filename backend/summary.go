@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codingric/moneyman/backend/filter"
+	"github.com/codingric/moneyman/backend/fx"
+	"github.com/codingric/moneyman/backend/money"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	ParamGroupBy = "group_by"
+	// Currency amounts are converted to for reporting
+	ParamBase = "base"
+)
+
+// SQL expressions for each supported grouping. Dates are grouped on the
+// stored local time rather than through strftime, which converts to UTC.
+var SummaryGroups = map[string]string{
+	"day":      "substr(created, 1, 10)",
+	"week":     "strftime('%Y-W%W', substr(created, 1, 10))",
+	"month":    "substr(created, 1, 7)",
+	"year":     "substr(created, 1, 4)",
+	"account":  "account",
+	"category": "category",
+	// Parent in the category tree, top level categories are their own
+	"parent_category": "COALESCE((SELECT NULLIF(parent, '') FROM categories WHERE categories.id = transactions.category), category)",
+	"currency":        "currency",
+	"description":     "description",
+}
+
+// GET /transactions/summary
+// Aggregate transactions matching the filters per group, leaving out transfers
+// between our own accounts unless ?transfers=true
+func SummariseTransactions(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transaction.SummariseTransactions")
+	defer span.End()
+
+	filters := c.Request.URL.Query()
+
+	var groups []string
+	if v := filters.Get(ParamGroupBy); v != "" {
+		groups = strings.Split(v, ",")
+	}
+	filters.Del(ParamGroupBy)
+	base := filters.Get(ParamBase)
+	filters.Del(ParamBase)
+	transfers := false
+	if v := filters.Get(ParamTransfers); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			msg := fmt.Sprintf("invalid transfers %s", v)
+			log.Error().Msg(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			span.SetStatus(codes.Error, msg)
+			return
+		}
+		transfers = b
+	}
+	filters.Del(ParamTransfers)
+
+	selects := []string{}
+	for _, g := range groups {
+		expr, ok := SummaryGroups[g]
+		if !ok {
+			msg := fmt.Sprintf("invalid group_by %s", g)
+			log.Error().Msg(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			span.SetStatus(codes.Error, msg)
+			return
+		}
+		selects = append(selects, fmt.Sprintf("%s AS %s", expr, g))
+	}
+
+	parsed, err := filter.Parse(filters, TransactionFields)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	var rates *fx.Table
+	if base != "" {
+		if base, err = money.ParseCurrency(base); err != nil {
+			msg := fmt.Sprintf("invalid base %s", c.Query(ParamBase))
+			log.Error().Msg(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			span.SetStatus(codes.Error, msg)
+			return
+		}
+		if rates, err = LoadRates(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Unable to retreive data")
+			return
+		}
+	}
+
+	query := filter.Apply(DB.WithContext(ctx).Model(&Transaction{}), parsed)
+	// Moves between our own accounts aren't spend
+	if !transfers {
+		query = excludeTransfers(query)
+	}
+	var summary []map[string]interface{}
+	if rates != nil {
+		summary, err = summariseIn(query, groups, selects, rates, base)
+	} else {
+		summary, err = summarise(query, groups, selects)
+	}
+	var convErr conversionError
+	if errors.As(err, &convErr) {
+		log.Error().Msg(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		return
+	}
+
+	span.AddEvent("Transactions summarised", trace.WithAttributes(
+		attribute.Int("result.count", len(summary)),
+	))
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+// Aggregate in SQL, amounts of different currencies are added as is
+func summarise(query *gorm.DB, groups, selects []string) ([]map[string]interface{}, error) {
+	selects = append(selects,
+		"COUNT(*) AS count",
+		"COALESCE(SUM(amount), 0) AS sum",
+		"MIN(amount) AS min",
+		"MAX(amount) AS max",
+		"AVG(amount) AS avg",
+	)
+	query = query.Select(strings.Join(selects, ", "))
+	for _, g := range groups {
+		query = query.Group(g).Order(g)
+	}
+
+	summary := []map[string]interface{}{}
+	if err := query.Scan(&summary).Error; err != nil {
+		return nil, err
+	}
+
+	// Amounts are stored in cents
+	for _, row := range summary {
+		for _, k := range []string{"sum", "min", "max", "avg"} {
+			switch v := row[k].(type) {
+			case int64:
+				row[k] = money.Amount(v).Float()
+			case float64:
+				row[k] = money.FromFloat(v / 100).Float()
+			}
+		}
+	}
+	return summary, nil
+}
+
+// conversionError is a transaction that can't be converted to the base
+// currency, which the client can fix by loading rates
+type conversionError struct {
+	error
+}
+
+// Aggregate in Go, converting each amount to base with the rate of the day
+// it was made
+func summariseIn(query *gorm.DB, groups, selects []string, rates *fx.Table, base string) ([]map[string]interface{}, error) {
+	selects = append(selects, "amount AS fx_amount", "currency AS fx_currency", "created AS fx_created")
+	query = query.Select(strings.Join(selects, ", "))
+	for _, g := range groups {
+		query = query.Order(g)
+	}
+
+	rows := []map[string]interface{}{}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	type stats struct {
+		count         int64
+		sum, min, max money.Amount
+	}
+	summary := []map[string]interface{}{}
+	var totals []*stats
+	index := map[string]int{}
+	for _, row := range rows {
+		var amount money.Amount
+		if err := amount.Scan(row["fx_amount"]); err != nil {
+			return nil, err
+		}
+		created, err := scanTime(row["fx_created"])
+		if err != nil {
+			return nil, err
+		}
+		currency, _ := row["fx_currency"].(string)
+		if amount, err = rates.Convert(amount, currency, base, created); err != nil {
+			return nil, conversionError{err}
+		}
+
+		key := ""
+		for _, g := range groups {
+			key += fmt.Sprintf("%v\x00", row[g])
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(summary)
+			index[key] = i
+			group := map[string]interface{}{}
+			for _, g := range groups {
+				group[g] = row[g]
+			}
+			summary = append(summary, group)
+			totals = append(totals, &stats{min: amount, max: amount})
+		}
+		t := totals[i]
+		t.count++
+		t.sum += amount
+		if amount < t.min {
+			t.min = amount
+		}
+		if amount > t.max {
+			t.max = amount
+		}
+	}
+
+	// Without groups there is always one row, as there is from SQL
+	if len(groups) == 0 && len(summary) == 0 {
+		summary = append(summary, map[string]interface{}{})
+		totals = append(totals, &stats{})
+	}
+	for i, row := range summary {
+		t := totals[i]
+		row["count"] = t.count
+		row["sum"] = t.sum.Float()
+		if t.count == 0 {
+			row["min"], row["max"], row["avg"] = nil, nil, nil
+			continue
+		}
+		row["min"] = t.min.Float()
+		row["max"] = t.max.Float()
+		row["avg"] = money.FromFloat(t.sum.Float() / float64(t.count)).Float()
+	}
+	return summary, nil
+}
+
+func scanTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+			if d, err := time.Parse(layout, t); err == nil {
+				return d, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid created %v", v)
+}
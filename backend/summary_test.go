@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummariseTransactions(t *testing.T) {
+	defer useDatabase()()
+
+	loc := time.FixedZone("AEDT", 11*60*60)
+	DB.Create(&Transaction{Md5: "1", Description: "WOOLWORTHS", Amount: -2010, Account: 1, Category: "groceries", Created: time.Date(2000, time.January, 1, 0, 30, 0, 0, loc)})
+	DB.Create(&Transaction{Md5: "2", Description: "WOOLWORTHS", Amount: -3020, Account: 1, Category: "groceries", Created: time.Date(2000, time.January, 20, 0, 0, 0, 0, loc)})
+	DB.Create(&Transaction{Md5: "3", Description: "AHM", Amount: -38791, Account: 2, Category: "health", Created: time.Date(2000, time.February, 12, 0, 0, 0, 0, loc)})
+	DB.Create(&Transaction{Md5: "4", Description: "SALARY", Amount: 480000, Account: 2, Created: time.Date(2000, time.February, 15, 0, 0, 0, 0, loc)})
+
+	tests := []struct {
+		name        string
+		url         string
+		status_code int
+		expect      string
+	}{
+		{
+			"NoGroups",
+			"x",
+			200,
+			`{"data":[{"avg":1090.45,"count":4,"max":4800,"min":-387.91,"sum":4361.79}]}`,
+		},
+		{
+			"Month",
+			"x?group_by=month",
+			200,
+			`{"data":[{"avg":-25.15,"count":2,"max":-20.1,"min":-30.2,"month":"2000-01","sum":-50.3},{"avg":2206.05,"count":2,"max":4800,"min":-387.91,"month":"2000-02","sum":4412.09}]}`,
+		},
+		{
+			"MonthCategory",
+			"x?group_by=month,category&amount__lt=0",
+			200,
+			`{"data":[{"avg":-25.15,"category":"groceries","count":2,"max":-20.1,"min":-30.2,"month":"2000-01","sum":-50.3},{"avg":-387.91,"category":"health","count":1,"max":-387.91,"min":-387.91,"month":"2000-02","sum":-387.91}]}`,
+		},
+		{
+			"AccountFiltered",
+			"x?group_by=account&created__gt=2000-01-10",
+			200,
+			`{"data":[{"account":1,"avg":-30.2,"count":1,"max":-30.2,"min":-30.2,"sum":-30.2},{"account":2,"avg":2206.05,"count":2,"max":4800,"min":-387.91,"sum":4412.09}]}`,
+		},
+		{
+			"Empty",
+			"x?group_by=year&amount__gt=10000",
+			200,
+			`{"data":[]}`,
+		},
+		{
+			"InvalidGroup",
+			"x?group_by=md5",
+			400,
+			`{"error":"invalid group_by md5"}`,
+		},
+		{
+			"InvalidFilter",
+			"x?group_by=month&md5=1",
+			400,
+			`{"error":"invalid field md5"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", test.url, nil)
+
+			SummariseTransactions(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -15,7 +16,10 @@ import (
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 
+	"github.com/codingric/moneyman/backend/filter"
+	"github.com/codingric/moneyman/backend/money"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type CreateTransactionInput struct {
@@ -23,15 +27,306 @@ type CreateTransactionInput struct {
 	Amount      string    `json:"amount" binding:"required"`
 	Description string    `json:"description" binding:"required"`
 	Account     string    `json:"account" binding:"required"`
+	// ISO 4217 code, AUD when omitted
+	Currency string `json:"currency"`
+	// Original amount of transactions made in another currency
+	ForeignAmount   string `json:"foreign_amount"`
+	ForeignCurrency string `json:"foreign_currency"`
+	// Where the transaction came from, e.g. up, mailparser or import
+	Source string `json:"source"`
+	// Id at the source, a transaction posted again from the same source with
+	// the same id is updated in place, e.g. when a held Up transaction settles
+	ExternalID string     `json:"external_id"`
+	Status     string     `json:"status"`
+	SettledAt  *time.Time `json:"settled_at"`
+	// Details kept from the source, e.g. Up's raw text, message, category
+	// ids and tags
+	RawText              string   `json:"raw_text"`
+	Message              string   `json:"message"`
+	SourceCategory       string   `json:"source_category"`
+	SourceParentCategory string   `json:"source_parent_category"`
+	Tags                 []string `json:"tags"`
+	// Amount held before settling, and the round up and cashback that are
+	// stored as linked rows
+	HoldAmount          string `json:"hold_amount"`
+	RoundUp             string `json:"round_up"`
+	RoundUpBoost        string `json:"round_up_boost"`
+	Cashback            string `json:"cashback"`
+	CashbackDescription string `json:"cashback_description"`
+}
+
+const (
+	StatusHeld    = "HELD"
+	StatusSettled = "SETTLED"
+)
+
+// Fields of Transaction that may be filtered on
+var TransactionFields = filter.Fields{
+	"id":                     filter.Number,
+	"description":            filter.String,
+	"amount":                 filter.Money,
+	"account":                filter.Number,
+	"created":                filter.Time,
+	"category":               filter.String,
+	"tags":                   filter.String,
+	"currency":               filter.String,
+	"foreign_amount":         filter.Money,
+	"foreign_currency":       filter.String,
+	"source":                 filter.String,
+	"external_id":            filter.String,
+	"status":                 filter.String,
+	"settled_at":             filter.Time,
+	"raw_text":               filter.String,
+	"message":                filter.String,
+	"source_category":        filter.String,
+	"source_parent_category": filter.String,
+	"hold_amount":            filter.Money,
+	"round_up":               filter.Money,
+	"cashback":               filter.Money,
+	"parent_id":              filter.Number,
+	"kind":                   filter.String,
 }
 
 type Transaction struct {
-	ID          uint      `json:"id" gorm:"primary_key"`
-	Md5         string    `json:"-" gorm:"unique"`
-	Description string    `json:"description"`
-	Amount      float64   `json:"amount"`
-	Account     int64     `json:"account"`
-	Created     time.Time `json:"created"`
+	ID          uint         `json:"id" gorm:"primary_key"`
+	Md5         string       `json:"-" gorm:"unique"`
+	Description string       `json:"description"`
+	Amount      money.Amount `json:"amount"`
+	Currency    string       `json:"currency"`
+	Account     int64        `json:"account"`
+	Created     time.Time    `json:"created"`
+	Category    string       `json:"category,omitempty" gorm:"index"`
+	Tags        Tags         `json:"tags,omitempty"`
+
+	ForeignAmount   *money.Amount `json:"foreign_amount,omitempty"`
+	ForeignCurrency string        `json:"foreign_currency,omitempty"`
+
+	// External ids are unique per source, rows without one are keyed by md5
+	Source     string     `json:"source,omitempty" gorm:"index:idx_transactions_source_external,unique,where:external_id <> ''"`
+	ExternalID string     `json:"external_id,omitempty" gorm:"index:idx_transactions_source_external,unique,where:external_id <> ''"`
+	Status     string     `json:"status,omitempty"`
+	SettledAt  *time.Time `json:"settled_at,omitempty"`
+
+	RawText string `json:"raw_text,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Category the source gave the transaction, used as its category when no
+	// rule matches
+	SourceCategory       string `json:"source_category,omitempty" gorm:"index"`
+	SourceParentCategory string `json:"source_parent_category,omitempty"`
+
+	HoldAmount          *money.Amount `json:"hold_amount,omitempty"`
+	RoundUp             *money.Amount `json:"round_up,omitempty"`
+	RoundUpBoost        *money.Amount `json:"round_up_boost,omitempty"`
+	Cashback            *money.Amount `json:"cashback,omitempty"`
+	CashbackDescription string        `json:"cashback_description,omitempty"`
+	// Rows derived from another, e.g. its round up, link to it with their kind
+	ParentID *uint  `json:"parent_id,omitempty" gorm:"index"`
+	Kind     string `json:"kind,omitempty" gorm:"index"`
+
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Fields to change, omitted fields are left as they are. The md5 key is kept
+// so the original row is still recognised as a duplicate when re-imported.
+type UpdateTransactionInput struct {
+	Created     *time.Time `json:"created"`
+	Amount      *string    `json:"amount"`
+	Currency    *string    `json:"currency"`
+	Description *string    `json:"description"`
+	Account     *string    `json:"account"`
+	Category    *string    `json:"category"`
+	Tags        *[]string  `json:"tags"`
+	// Empty strings clear the foreign amount
+	ForeignAmount   *string `json:"foreign_amount"`
+	ForeignCurrency *string `json:"foreign_currency"`
+}
+
+// Transactions created without a currency are in the default one
+func (t *Transaction) BeforeCreate(tx *gorm.DB) error {
+	if t.Currency == "" {
+		t.Currency = money.DefaultCurrency
+	}
+	return nil
+}
+
+// Transaction for input, keyed by the md5 of
+// `created!account!amount!description` so the same row is only stored once
+func (input CreateTransactionInput) Transaction() (Transaction, error) {
+	b := []string{fmt.Sprint(input.Created.Unix()), input.Account, input.Amount, input.Description}
+	h := md5.Sum([]byte(strings.Join(b, "!")))
+
+	amount, err := money.Parse(input.Amount)
+	if err != nil {
+		return Transaction{}, err
+	}
+	currency := money.DefaultCurrency
+	if input.Currency != "" {
+		if currency, err = money.ParseCurrency(input.Currency); err != nil {
+			return Transaction{}, err
+		}
+	}
+	a, _ := strconv.ParseInt(input.Account, 10, 64)
+	t := Transaction{Md5: fmt.Sprintf("%x", h), Created: input.Created, Amount: amount, Currency: currency, Description: input.Description, Account: a}
+
+	if input.ForeignAmount != "" || input.ForeignCurrency != "" {
+		foreign, err := money.Parse(input.ForeignAmount)
+		if err != nil {
+			return Transaction{}, fmt.Errorf("invalid foreign_amount %s", input.ForeignAmount)
+		}
+		if t.ForeignCurrency, err = money.ParseCurrency(input.ForeignCurrency); err != nil {
+			return Transaction{}, fmt.Errorf("invalid foreign_currency %s", input.ForeignCurrency)
+		}
+		t.ForeignAmount = &foreign
+	}
+
+	switch strings.ToUpper(input.Status) {
+	case "", StatusHeld, StatusSettled:
+		t.Status = strings.ToUpper(input.Status)
+	default:
+		return Transaction{}, fmt.Errorf("invalid status %s", input.Status)
+	}
+	t.Source = input.Source
+	t.ExternalID = input.ExternalID
+	t.SettledAt = input.SettledAt
+	t.RawText = input.RawText
+	t.Message = input.Message
+	t.SourceCategory = input.SourceCategory
+	t.SourceParentCategory = input.SourceParentCategory
+	t.Tags.Add(input.Tags...)
+
+	optional := []struct {
+		name  string
+		value string
+		into  **money.Amount
+	}{
+		{"hold_amount", input.HoldAmount, &t.HoldAmount},
+		{"round_up", input.RoundUp, &t.RoundUp},
+		{"round_up_boost", input.RoundUpBoost, &t.RoundUpBoost},
+		{"cashback", input.Cashback, &t.Cashback},
+	}
+	for _, o := range optional {
+		if o.value == "" {
+			continue
+		}
+		amount, err := money.Parse(o.value)
+		if err != nil {
+			return Transaction{}, fmt.Errorf("invalid %s %s", o.name, o.value)
+		}
+		*o.into = &amount
+	}
+	t.CashbackDescription = input.CashbackDescription
+	return t, nil
+}
+
+// Categorise t with rules, falling back to the source's category when none
+// matches
+func (t *Transaction) Categorise(rules Rules) {
+	rules.Apply(t)
+	if t.Category == "" {
+		t.Category = t.SourceCategory
+	}
+}
+
+// Settle copies what the bank may change about a transaction after it was
+// first reported onto t, keeping its key, category and tags. A category
+// taken from the source follows the source's, and the source's tags are
+// added.
+func (t *Transaction) Settle(from Transaction) {
+	if t.Category == t.SourceCategory && from.SourceCategory != "" {
+		t.Category = from.SourceCategory
+	}
+	t.RawText = from.RawText
+	t.Message = from.Message
+	t.SourceCategory = from.SourceCategory
+	t.SourceParentCategory = from.SourceParentCategory
+	t.Tags.Add(from.Tags...)
+	t.HoldAmount = from.HoldAmount
+	t.RoundUp = from.RoundUp
+	t.RoundUpBoost = from.RoundUpBoost
+	t.Cashback = from.Cashback
+	t.CashbackDescription = from.CashbackDescription
+	t.Source = from.Source
+	t.ExternalID = from.ExternalID
+	t.Created = from.Created
+	t.Amount = from.Amount
+	t.Currency = from.Currency
+	t.ForeignAmount = from.ForeignAmount
+	t.ForeignCurrency = from.ForeignCurrency
+	t.Description = from.Description
+	t.Account = from.Account
+	t.Status = from.Status
+	t.SettledAt = from.SettledAt
+}
+
+// existing finds the stored transaction t is a later report of, by source and
+// external id or, for rows stored before they had one, by md5. Deleted rows
+// are found too, as they still hold the md5 and id
+func existing(tx *gorm.DB, t Transaction) (*Transaction, error) {
+	if t.ExternalID == "" {
+		return nil, nil
+	}
+	var found []Transaction
+	err := tx.Unscoped().Where("(COALESCE(source, '') = ? AND external_id = ?) OR (md5 = ? AND COALESCE(external_id, '') = '')", t.Source, t.ExternalID, t.Md5).Limit(1).Find(&found).Error
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+// Apply the changes in input to t
+func (input UpdateTransactionInput) Apply(t *Transaction) (err error) {
+	if input.Created != nil {
+		t.Created = *input.Created
+	}
+	if input.Amount != nil {
+		if t.Amount, err = money.Parse(*input.Amount); err != nil {
+			return err
+		}
+	}
+	if input.Currency != nil {
+		if t.Currency, err = money.ParseCurrency(*input.Currency); err != nil {
+			return err
+		}
+	}
+	if input.Description != nil {
+		if *input.Description == "" {
+			return errors.New("missing description")
+		}
+		t.Description = *input.Description
+	}
+	if input.Account != nil {
+		if t.Account, err = strconv.ParseInt(*input.Account, 10, 64); err != nil {
+			return fmt.Errorf("invalid account %s", *input.Account)
+		}
+	}
+	if input.Category != nil {
+		t.Category = *input.Category
+	}
+	if input.Tags != nil {
+		t.Tags = Tags(*input.Tags)
+	}
+	if input.ForeignAmount != nil {
+		t.ForeignAmount = nil
+		if *input.ForeignAmount != "" {
+			foreign, err := money.Parse(*input.ForeignAmount)
+			if err != nil {
+				return fmt.Errorf("invalid foreign_amount %s", *input.ForeignAmount)
+			}
+			t.ForeignAmount = &foreign
+		}
+	}
+	if input.ForeignCurrency != nil {
+		t.ForeignCurrency = ""
+		if *input.ForeignCurrency != "" {
+			if t.ForeignCurrency, err = money.ParseCurrency(*input.ForeignCurrency); err != nil {
+				return fmt.Errorf("invalid foreign_currency %s", *input.ForeignCurrency)
+			}
+		}
+	}
+	if (t.ForeignAmount == nil) != (t.ForeignCurrency == "") {
+		return errors.New("foreign_amount and foreign_currency must be set together")
+	}
+	return nil
 }
 
 // GET /transactions
@@ -46,49 +341,45 @@ func FindTransactions(c *gin.Context) {
 	defer span.End()
 
 	filters := c.Request.URL.Query()
-	query := DB
-	for filter, value := range filters {
-		p := strings.Split(filter, "__")
-		op := "eq"
-		field := filter
-		val := value[0]
-
-		if len(p) > 1 {
-			field = p[0]
-			op = p[1]
-		}
-		switch field {
-		case "created":
-			d, _ := time.Parse("2006-01-02T15:04:05", val)
-			val = d.Format("2006-01-02 15:04:05")
-		}
-		log.Debug().Msgf("Filter: %v %v %v", field, op, val)
-
-		switch op {
-		case "like":
-			query = query.Where(field+" LIKE ?", "%"+val+"%")
-		case "gt":
-			query = query.Where(field+" > ?", val)
-		case "ge":
-			query = query.Where(field+" >= ?", val)
-		case "lt":
-			query = query.Where(field+" < ?", val)
-		case "le":
-			query = query.Where(field+" <= ?", val)
-		case "eq":
-			query = query.Where(field+" = ?", val)
-		case "ne":
-			query = query.Where(field+" != ?", val)
-		default:
-			msg := fmt.Sprintf("invalid operator %s", op)
-			log.Error().Msg(msg)
-			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
-			span.SetStatus(codes.Error, "msg")
-			return
+	page, err := ParsePage(filters, TransactionColumns)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	deleted := filters.Get("deleted") == "true"
+	for param := range filters {
+		if IsPageParam(param) || param == "deleted" {
+			filters.Del(param)
 		}
 	}
+	parsed, err := filter.Parse(filters, TransactionFields)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	for _, f := range parsed {
+		log.Debug().Msgf("Filter: %v %v %v", f.Field, f.Op, f.Values)
+	}
+
+	db := DB.WithContext(ctx)
+	if deleted {
+		db = db.Unscoped()
+	}
+	query := filter.Apply(db.Model(&Transaction{}), parsed)
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		return
+	}
+
 	var transactions []Transaction
-	if err := query.WithContext(ctx).Find(&transactions).Error; err != nil {
+	if err := page.Apply(query.Session(&gorm.Session{})).Find(&transactions).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
 		//span.RecordError(err)
 		span.SetStatus(codes.Error, "Unable to retreive data")
@@ -96,8 +387,17 @@ func FindTransactions(c *gin.Context) {
 	}
 	span.AddEvent("Transactions found", trace.WithAttributes(
 		attribute.Int("result.count", len(transactions)),
+		attribute.Int64("result.total", total),
 	))
-	c.JSON(http.StatusOK, gin.H{"data": transactions})
+
+	data, err := page.Project(transactions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		return
+	}
+	next, prev := page.Links(c.Request.URL, total)
+	c.JSON(http.StatusOK, gin.H{"data": data, "total": total, "next": next, "prev": prev})
 }
 
 // GET /transactions/:id
@@ -124,7 +424,7 @@ func FindTransaction(c *gin.Context) {
 }
 
 // POST /transactions
-// Create new transaction
+// Create new transaction, or update the one with the same external_id
 func CreateTransaction(c *gin.Context) {
 	ctx := context.Background()
 	if c.Request != nil {
@@ -144,27 +444,229 @@ func CreateTransaction(c *gin.Context) {
 		return
 	}
 
-	b := []string{fmt.Sprint(input.Created.Unix()), input.Account, input.Amount, input.Description}
-	d := strings.Join(b, "!")
-	h := md5.Sum([]byte(d))
-	log.Debug().Caller().Msgf("Hash: %x", h)
+	transaction, err := input.Transaction()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Debug().Caller().Msgf("Hash: %s", transaction.Md5)
 
-	// Create transaction
-	f, _ := strconv.ParseFloat(input.Amount, 64)
-	a, _ := strconv.ParseInt(input.Account, 10, 64)
-	//t, _ := time.Parse("2006-01-02", input.Created)
-	transaction := Transaction{Md5: fmt.Sprintf("%x", h), Created: input.Created, Amount: f, Description: input.Description, Account: a}
-	result := DB.WithContext(ctx).Create(&transaction)
+	stored, err := existing(DB.WithContext(ctx), transaction)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+	if stored != nil && stored.DeletedAt.Valid {
+		// Deleted transactions stay deleted when their source posts them again
+		span.AddEvent("Transaction deleted", trace.WithAttributes(
+			attribute.String("data", fmt.Sprintf("%v", *stored)),
+		))
+		c.JSON(http.StatusOK, gin.H{"data": stored})
+		return
+	}
+	if stored != nil {
+		old := *stored
+		stored.Settle(transaction)
+		err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(stored).Error; err != nil {
+				return err
+			}
+			if err := recordHistory(tx, Actor(c), HistoryUpdate, &old, stored); err != nil {
+				return err
+			}
+			return saveLinked(tx, Actor(c), stored)
+		})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to update transaction")
+			log.Error().Caller().Err(err).Msg("Failed to update transaction")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update transaction"})
+			return
+		}
+		detectTransfers(ctx, stored.ID)
+		span.AddEvent("Transaction updated", trace.WithAttributes(
+			attribute.String("data", fmt.Sprintf("%v", *stored)),
+		))
+		c.JSON(http.StatusOK, gin.H{"data": stored})
+		return
+	}
+
+	rules, err := LoadRules(ctx)
+	if err != nil {
+		span.RecordError(err)
+		log.Error().Caller().Err(err).Msg("Unable to load rules, transaction not categorised")
+	}
+	transaction.Categorise(rules)
 
-	if result.Error != nil {
-		span.RecordError(result.Error)
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&transaction).Error; err != nil {
+			return err
+		}
+		if err := recordHistory(tx, Actor(c), HistoryCreate, nil, &transaction); err != nil {
+			return err
+		}
+		return saveLinked(tx, Actor(c), &transaction)
+	})
+	if err != nil {
+		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to create transaction")
-		log.Error().Caller().Err(result.Error).Msg("Failed to create transaction")
+		log.Error().Caller().Err(err).Msg("Failed to create transaction")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create transaction"})
 		return
 	}
+	detectTransfers(ctx, transaction.ID)
 	span.AddEvent("Transaction created", trace.WithAttributes(
 		attribute.String("data", fmt.Sprintf("%v", transaction)),
 	))
 	c.JSON(http.StatusOK, gin.H{"data": transaction})
 }
+
+// PATCH /transaction/:id
+// Update a transaction
+func UpdateTransaction(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transaction.UpdateTransaction")
+	defer span.End()
+
+	var transaction Transaction
+	if err := DB.WithContext(ctx).Where("id = ?", c.Param("id")).First(&transaction).Error; err != nil {
+		span.SetStatus(codes.Error, "Record not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	var input UpdateTransactionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid request parameters")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request parameters"})
+		return
+	}
+
+	old := transaction
+	if err := input.Apply(&transaction); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&transaction).Error; err != nil {
+			return err
+		}
+		return recordHistory(tx, Actor(c), HistoryUpdate, &old, &transaction)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update transaction")
+		log.Error().Caller().Err(err).Msg("Failed to update transaction")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update transaction"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": transaction})
+}
+
+// DELETE /transaction/:id
+// Soft delete a transaction, it is kept along with its history
+func DeleteTransaction(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transaction.DeleteTransaction")
+	defer span.End()
+
+	var transaction Transaction
+	if err := DB.WithContext(ctx).Where("id = ?", c.Param("id")).First(&transaction).Error; err != nil {
+		span.SetStatus(codes.Error, "Record not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&transaction).Error; err != nil {
+			return err
+		}
+		if err := recordHistory(tx, Actor(c), HistoryDelete, &transaction, nil); err != nil {
+			return err
+		}
+		return deleteLinked(tx, Actor(c), &transaction)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete transaction")
+		log.Error().Caller().Err(err).Msg("Failed to delete transaction")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete transaction"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
+
+// DELETE /transactions?source=X&external_id=Y
+// Soft delete the transaction with an external id from its source
+func DeleteTransactions(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transaction.DeleteTransactions")
+	defer span.End()
+
+	// Only ever an exact pair, a filter could match far more than intended
+	params := c.Request.URL.Query()
+	source, externalID := params.Get("source"), params.Get("external_id")
+	if len(params) != 2 || source == "" || externalID == "" {
+		msg := "source and external_id required"
+		span.SetStatus(codes.Error, msg)
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	span.SetAttributes(
+		attribute.String("source", source),
+		attribute.String("external_id", externalID),
+	)
+
+	var transactions []Transaction
+	if err := DB.WithContext(ctx).Where("source = ? AND external_id = ?", source, externalID).Find(&transactions).Error; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range transactions {
+			if err := tx.Delete(&transactions[i]).Error; err != nil {
+				return err
+			}
+			if err := recordHistory(tx, Actor(c), HistoryDelete, &transactions[i], nil); err != nil {
+				return err
+			}
+			if err := deleteLinked(tx, Actor(c), &transactions[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete transactions")
+		log.Error().Caller().Err(err).Msg("Failed to delete transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete transactions"})
+		return
+	}
+	span.AddEvent("Transactions deleted", trace.WithAttributes(
+		attribute.Int("result.deleted", len(transactions)),
+	))
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"deleted": len(transactions)}})
+}
@@ -37,7 +37,7 @@ func TestCreateTransaction(t *testing.T) {
 			"Successful",
 			[]byte(`{"created":"2000-01-01T00:00:01+11:00","amount":"12.50","description":"test","account":"1234567890"}`),
 			200,
-			`{"data":{"id":1,"description":"test","amount":12.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}}`,
+			`{"data":{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}}`,
 		},
 		{
 			"Duplicate",
@@ -45,11 +45,23 @@ func TestCreateTransaction(t *testing.T) {
 			400,
 			`{"error":"Failed to create transaction"}`,
 		},
+		{
+			"InvalidAmount",
+			[]byte(`{"created":"2000-01-01T00:00:01+11:00","amount":"12.505","description":"test","account":"1234567890"}`),
+			400,
+			`{"error":"invalid amount 12.505"}`,
+		},
+		{
+			"InvalidCurrency",
+			[]byte(`{"created":"2000-01-01T00:00:01+11:00","amount":"12.50","description":"test","account":"1234567890","currency":"dollars"}`),
+			400,
+			`{"error":"invalid currency dollars"}`,
+		},
 		{
 			"SameTime",
 			[]byte(`{"created":"2000-01-01T00:00:01+11:00","amount":"20.50","description":"test two","account":"1234567890"}`),
 			200,
-			`{"data":{"id":2,"description":"test two","amount":20.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}}`,
+			`{"data":{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}}`,
 		},
 	}
 
@@ -86,9 +98,9 @@ func TestFindTransaction(t *testing.T) {
 		},
 		{
 			"Successful",
-			[]gin.Param{{"id", "1"}},
+			[]gin.Param{{Key: "id", Value: "1"}},
 			200,
-			`{"data":{"id":1,"description":"test","amount":12.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}}`,
+			`{"data":{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}}`,
 		},
 	}
 
@@ -118,50 +130,56 @@ func TestFindTransactions(t *testing.T) {
 		{
 			"InvalidRequest",
 			`x?=&=`,
-			500,
-			`{"error":"Unable to retreive data"}`,
+			400,
+			`{"error":"invalid field "}`,
+		},
+		{
+			"UnknownField",
+			`x?md5=abc`,
+			400,
+			`{"error":"invalid field md5"}`,
 		},
 		{
 			"Like",
 			`x?description__like=two`,
 			200,
-			`{"data":[{"id":2,"description":"test two","amount":20.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}]}`,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
 		},
 		{
 			"GreaterThan",
 			`x?amount__gt=15`,
 			200,
-			`{"data":[{"id":2,"description":"test two","amount":20.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}]}`,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
 		},
 		{
 			"LessThan",
 			`x?amount__lt=15`,
 			200,
-			`{"data":[{"id":1,"description":"test","amount":12.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}]}`,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
 		},
 		{
 			"GreaterEqual",
 			`x?amount__ge=20.5`,
 			200,
-			`{"data":[{"id":2,"description":"test two","amount":20.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}]}`,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
 		},
 		{
 			"LessEqual",
 			`x?amount__le=12.5`,
 			200,
-			`{"data":[{"id":1,"description":"test","amount":12.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}]}`,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
 		},
 		{
 			"Date",
 			`x?created__gt=2000-01-01T00:00:00`,
 			200,
-			`{"data":[{"id":1,"description":"test","amount":12.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"},{"id":2,"description":"test two","amount":20.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}]}`,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"},{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":2}`,
 		},
 		{
 			"NotEqual",
 			`x?id__ne=2`,
 			200,
-			`{"data":[{"id":1,"description":"test","amount":12.5,"account":1234567890,"created":"2000-01-01T00:00:01+11:00"}]}`,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
 		},
 		{
 			"InvalidOperator",
@@ -169,6 +187,132 @@ func TestFindTransactions(t *testing.T) {
 			400,
 			`{"error":"invalid operator xx"}`,
 		},
+		{
+			"InvalidNumber",
+			`x?amount__gt=abc`,
+			400,
+			`{"error":"invalid amount abc"}`,
+		},
+		{
+			"InvalidDate",
+			`x?created__gt=yesterday`,
+			400,
+			`{"error":"invalid time yesterday"}`,
+		},
+		{
+			"In",
+			`x?amount__in=20.5,99`,
+			200,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
+		},
+		{
+			"Between",
+			`x?amount__between=10,15`,
+			200,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
+		},
+		{
+			"InvalidBetween",
+			`x?amount__between=10`,
+			400,
+			`{"error":"invalid value 10 for between"}`,
+		},
+		{
+			"IsNull",
+			`x?description__isnull=true`,
+			200,
+			`{"data":[],"next":null,"prev":null,"total":0}`,
+		},
+		{
+			"IsNotNull",
+			`x?description__isnull=false`,
+			200,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"},{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":2}`,
+		},
+		{
+			"ILike",
+			`x?description__ilike=TWO`,
+			200,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
+		},
+		{
+			"StartsWith",
+			`x?description__startswith=test%20t`,
+			200,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
+		},
+		{
+			"Regex",
+			`x?description__regex=^test$`,
+			200,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":1}`,
+		},
+		{
+			"InvalidRegex",
+			`x?description__regex=(`,
+			400,
+			`{"error":"invalid regex ("}`,
+		},
+		{
+			"Or",
+			`x?id=1&id=2`,
+			200,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"},{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":2}`,
+		},
+		{
+			"Limit",
+			`x?limit=1`,
+			200,
+			`{"data":[{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":"x?limit=1\u0026offset=1","prev":null,"total":2}`,
+		},
+		{
+			"Offset",
+			`x?limit=1&offset=1`,
+			200,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":"x?limit=1\u0026offset=0","total":2}`,
+		},
+		{
+			"InvalidLimit",
+			`x?limit=abc`,
+			400,
+			`{"error":"invalid limit abc"}`,
+		},
+		{
+			"InvalidOffset",
+			`x?offset=-1`,
+			400,
+			`{"error":"invalid offset -1"}`,
+		},
+		{
+			"OrderBy",
+			`x?order_by=-amount`,
+			200,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"},{"id":1,"description":"test","amount":12.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":null,"prev":null,"total":2}`,
+		},
+		{
+			"OrderByMultiple",
+			`x?order_by=created,-id&limit=1`,
+			200,
+			`{"data":[{"id":2,"description":"test two","amount":20.5,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00"}],"next":"x?limit=1\u0026offset=1\u0026order_by=created%2C-id","prev":null,"total":2}`,
+		},
+		{
+			"InvalidOrderBy",
+			`x?order_by=md5`,
+			400,
+			`{"error":"invalid order_by md5"}`,
+		},
+		{
+			"Fields",
+			`x?fields=id,amount&amount__gt=15`,
+			200,
+			`{"data":[{"amount":20.5,"id":2}],"next":null,"prev":null,"total":1}`,
+		},
+		{
+			"InvalidFields",
+			`x?fields=id,md5`,
+			400,
+			`{"error":"invalid field md5"}`,
+		},
 	}
 
 	for _, test := range tests {
@@ -186,3 +330,265 @@ func TestFindTransactions(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateTransactionForeign(t *testing.T) {
+	defer useDatabase()()
+
+	tests := []struct {
+		name        string
+		post        string
+		status_code int
+		expect      string
+	}{
+		{
+			"Foreign",
+			`{"created":"2000-01-01T00:00:01+11:00","amount":"-15.00","description":"MACYS","account":"1234567890","foreign_amount":"-10","foreign_currency":"usd"}`,
+			200,
+			`{"data":{"id":1,"description":"MACYS","amount":-15,"currency":"AUD","account":1234567890,"created":"2000-01-01T00:00:01+11:00","foreign_amount":-10,"foreign_currency":"USD"}}`,
+		},
+		{
+			"MissingForeignCurrency",
+			`{"created":"2000-01-01T00:00:01+11:00","amount":"-15.00","description":"MACYS","account":"1234567890","foreign_amount":"-10"}`,
+			400,
+			`{"error":"invalid foreign_currency "}`,
+		},
+		{
+			"InvalidForeignAmount",
+			`{"created":"2000-01-01T00:00:01+11:00","amount":"-15.00","description":"MACYS","account":"1234567890","foreign_currency":"USD"}`,
+			400,
+			`{"error":"invalid foreign_amount "}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/transactions", bytes.NewBufferString(test.post))
+
+			CreateTransaction(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	var count int64
+	DB.Model(&Transaction{}).Where("foreign_amount = ?", -1000).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestTransactionLifecycle(t *testing.T) {
+	defer useDatabase()()
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		body        string
+		status_code int
+		expect      string
+	}{
+		{
+			"Legacy",
+			"POST",
+			"/transactions",
+			`{"created":"2023-01-01T09:00:00+11:00","amount":"-5.00","description":"LEGACY","account":"1234"}`,
+			200,
+			`{"data":{"id":1,"description":"LEGACY","amount":-5,"currency":"AUD","account":1234,"created":"2023-01-01T09:00:00+11:00"}}`,
+		},
+		{
+			"LegacySettled",
+			"POST",
+			"/transactions",
+			`{"created":"2023-01-01T09:00:00+11:00","amount":"-5.00","description":"LEGACY","account":"1234","external_id":"up-0","status":"SETTLED"}`,
+			200,
+			`{"data":{"id":1,"description":"LEGACY","amount":-5,"currency":"AUD","account":1234,"created":"2023-01-01T09:00:00+11:00","external_id":"up-0","status":"SETTLED"}}`,
+		},
+		{
+			"Held",
+			"POST",
+			"/transactions",
+			`{"created":"2023-02-01T09:00:00+11:00","amount":"-100.00","description":"SHELL","account":"1234","source":"up","external_id":"up-1","status":"HELD"}`,
+			200,
+			`{"data":{"id":2,"description":"SHELL","amount":-100,"currency":"AUD","account":1234,"created":"2023-02-01T09:00:00+11:00","source":"up","external_id":"up-1","status":"HELD"}}`,
+		},
+		{
+			"Settled",
+			"POST",
+			"/transactions",
+			`{"created":"2023-02-01T09:00:00+11:00","amount":"-62.35","description":"SHELL COLES EXPRESS","account":"1234","source":"up","external_id":"up-1","status":"SETTLED","settled_at":"2023-02-02T03:00:00+11:00"}`,
+			200,
+			`{"data":{"id":2,"description":"SHELL COLES EXPRESS","amount":-62.35,"currency":"AUD","account":1234,"created":"2023-02-01T09:00:00+11:00","source":"up","external_id":"up-1","status":"SETTLED","settled_at":"2023-02-02T03:00:00+11:00"}}`,
+		},
+		{
+			"InvalidStatus",
+			"POST",
+			"/transactions",
+			`{"created":"2023-02-01T09:00:00+11:00","amount":"-1.00","description":"X","account":"1234","status":"PENDING"}`,
+			400,
+			`{"error":"invalid status PENDING"}`,
+		},
+		{
+			"DeleteWithoutFilter",
+			"DELETE",
+			"/transactions",
+			"",
+			400,
+			`{"error":"source and external_id required"}`,
+		},
+		{
+			"DeleteWithoutSource",
+			"DELETE",
+			"/transactions?external_id=up-1",
+			"",
+			400,
+			`{"error":"source and external_id required"}`,
+		},
+		{
+			"DeleteByFilter",
+			"DELETE",
+			"/transactions?source=up&external_id=up-1&id__isnull=false",
+			"",
+			400,
+			`{"error":"source and external_id required"}`,
+		},
+		{
+			"DeleteOtherSource",
+			"DELETE",
+			"/transactions?source=import&external_id=up-1",
+			"",
+			200,
+			`{"data":{"deleted":0}}`,
+		},
+		{
+			"Delete",
+			"DELETE",
+			"/transactions?source=up&external_id=up-1",
+			"",
+			200,
+			`{"data":{"deleted":1}}`,
+		},
+		{
+			"DeleteMissing",
+			"DELETE",
+			"/transactions?source=up&external_id=up-1",
+			"",
+			200,
+			`{"data":{"deleted":0}}`,
+		},
+		{
+			"HeldAfterDelete",
+			"POST",
+			"/transactions",
+			`{"created":"2023-02-01T09:00:00+11:00","amount":"-100.00","description":"SHELL","account":"1234","source":"up","external_id":"up-1","status":"HELD"}`,
+			200,
+			`{"data":{"id":2,"description":"SHELL COLES EXPRESS","amount":-62.35,"currency":"AUD","account":1234,"created":"2023-02-01T09:00:00+11:00","source":"up","external_id":"up-1","status":"SETTLED","settled_at":"2023-02-02T03:00:00+11:00"}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(test.method, test.url, bytes.NewBufferString(test.body))
+
+			if test.method == "POST" {
+				CreateTransaction(c)
+			} else {
+				DeleteTransactions(c)
+			}
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	var history []TransactionHistory
+	DB.Where("transaction_id = ?", 2).Order("id").Find(&history)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, Values{"amount": -100.0, "description": "SHELL", "status": "HELD"}, history[1].Old)
+		assert.Equal(t, HistoryDelete, history[2].Action)
+	}
+}
+
+func TestCreateDeletedTransaction(t *testing.T) {
+	defer useDatabase()()
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		body        string
+		handler     gin.HandlerFunc
+		status_code int
+		expect      string
+	}{
+		{
+			"Create",
+			"POST",
+			"/transactions",
+			`{"created":"2023-02-01T09:00:00+11:00","amount":"-100.00","description":"SHELL","account":"1234","source":"up","external_id":"up-1","status":"HELD"}`,
+			CreateTransaction,
+			200,
+			`{"data":{"id":1,"description":"SHELL","amount":-100,"currency":"AUD","account":1234,"created":"2023-02-01T09:00:00+11:00","source":"up","external_id":"up-1","status":"HELD"}}`,
+		},
+		{
+			"Delete",
+			"DELETE",
+			"/transaction/1",
+			"",
+			DeleteTransaction,
+			200,
+			`{"data":true}`,
+		},
+		{
+			"Repost",
+			"POST",
+			"/transactions",
+			`{"created":"2023-02-01T09:00:00+11:00","amount":"-62.35","description":"SHELL COLES EXPRESS","account":"1234","source":"up","external_id":"up-1","status":"SETTLED","settled_at":"2023-02-02T03:00:00+11:00"}`,
+			CreateTransaction,
+			200,
+			`{"data":{"id":1,"description":"SHELL","amount":-100,"currency":"AUD","account":1234,"created":"2023-02-01T09:00:00+11:00","source":"up","external_id":"up-1","status":"HELD"}}`,
+		},
+		{
+			"Find",
+			"GET",
+			"/transactions?source=up&external_id=up-1",
+			"",
+			FindTransactions,
+			200,
+			`{"data":[],"next":null,"prev":null,"total":0}`,
+		},
+		{
+			"FindDeleted",
+			"GET",
+			"/transactions?source=up&external_id=up-1&deleted=true",
+			"",
+			FindTransactions,
+			200,
+			`{"data":[{"id":1,"description":"SHELL","amount":-100,"currency":"AUD","account":1234,"created":"2023-02-01T09:00:00+11:00","source":"up","external_id":"up-1","status":"HELD"}],"next":null,"prev":null,"total":1}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{{Key: "id", Value: "1"}}
+			c.Request, _ = http.NewRequest(test.method, test.url, bytes.NewBufferString(test.body))
+
+			test.handler(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+
+	var count int64
+	DB.Unscoped().Model(&Transaction{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TransferLinked    = "linked"
+	TransferDismissed = "dismissed"
+
+	// Days apart the two sides of a transfer may be dated, moves between
+	// banks can take a business day or two to arrive
+	DefaultTransferDays = 3
+
+	// Includes transfers in summaries when true
+	ParamTransfers = "transfers"
+)
+
+var (
+	transfersCmd  = kingpin.Command("transfers", "Link moves between our own accounts as transfers")
+	transfersDays = transfersCmd.Flag("days", "Days apart both sides may be dated").Default(strconv.Itoa(DefaultTransferDays)).Int()
+)
+
+// Transfer links money leaving one of our accounts, From, to the same amount
+// arriving in another, To. Linked transfers are left out of summaries,
+// dismissing one keeps both counted and the pair isn't linked again.
+type Transfer struct {
+	ID      uint         `json:"id" gorm:"primary_key"`
+	FromID  uint         `json:"from_id" gorm:"uniqueIndex:idx_transfers_pair"`
+	ToID    uint         `json:"to_id" gorm:"uniqueIndex:idx_transfers_pair"`
+	Status  string       `json:"status" gorm:"index"`
+	Created time.Time    `json:"created"`
+	From    *Transaction `json:"from,omitempty"`
+	To      *Transaction `json:"to,omitempty"`
+}
+
+type UpdateTransferInput struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// DetectTransfers links debits to credits of the same amount and currency in
+// another known account dated at most days apart, returning the number of new
+// links. The closest pairs are linked first and each transaction is in at most
+// one transfer. Given ids only pairs involving those transactions, or rows
+// linked to them, are looked at.
+func DetectTransfers(ctx context.Context, days int, ids ...uint) (int, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "transfer.DetectTransfers")
+	defer span.End()
+
+	query := `SELECT a.id AS from_id, b.id AS to_id
+		FROM transactions a JOIN transactions b
+		ON b.amount = -a.amount AND b.currency = a.currency AND b.account <> a.account
+		AND ABS(julianday(b.created) - julianday(a.created)) <= @days
+		JOIN accounts fa ON fa.id = a.account
+		JOIN accounts ta ON ta.id = b.account
+		WHERE a.amount < 0 AND a.deleted_at IS NULL AND b.deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM transfers t WHERE t.status = @linked
+			AND (t.from_id IN (a.id, b.id) OR t.to_id IN (a.id, b.id)))
+		AND NOT EXISTS (SELECT 1 FROM transfers t WHERE t.from_id = a.id AND t.to_id = b.id)`
+	args := map[string]interface{}{"days": days, "linked": TransferLinked}
+	if len(ids) > 0 {
+		query += ` AND (a.id IN @ids OR a.parent_id IN @ids OR b.id IN @ids OR b.parent_id IN @ids)`
+		args["ids"] = ids
+	}
+	query += ` ORDER BY ABS(julianday(b.created) - julianday(a.created)), a.id, b.id`
+
+	var pairs []struct {
+		FromID uint
+		ToID   uint
+	}
+	if err := DB.WithContext(ctx).Raw(query, args).Scan(&pairs).Error; err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	used := map[uint]bool{}
+	transfers := []Transfer{}
+	for _, p := range pairs {
+		if used[p.FromID] || used[p.ToID] {
+			continue
+		}
+		used[p.FromID], used[p.ToID] = true, true
+		transfers = append(transfers, Transfer{FromID: p.FromID, ToID: p.ToID, Status: TransferLinked, Created: now()})
+	}
+	if len(transfers) == 0 {
+		return 0, nil
+	}
+
+	result := DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&transfers)
+	if result.Error != nil {
+		span.RecordError(result.Error)
+		return 0, result.Error
+	}
+	span.AddEvent("Transfers linked", trace.WithAttributes(
+		attribute.Int64("result.count", result.RowsAffected),
+	))
+	return int(result.RowsAffected), nil
+}
+
+// excludeTransfers leaves both sides of linked transfers out of query
+func excludeTransfers(query *gorm.DB) *gorm.DB {
+	return query.Where("id NOT IN (SELECT from_id FROM transfers WHERE status = ? UNION SELECT to_id FROM transfers WHERE status = ?)", TransferLinked, TransferLinked)
+}
+
+// POST /transfers/detect
+// Link moves between our own accounts, ?days= sets how far apart both sides
+// may be dated
+func DetectTransfersHandler(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transfer.DetectTransfersHandler")
+	defer span.End()
+
+	days := DefaultTransferDays
+	if param := c.Query("days"); param != "" {
+		d, err := strconv.Atoi(param)
+		if err != nil || d < 0 {
+			span.SetStatus(codes.Error, "Invalid days")
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid days %s", param)})
+			return
+		}
+		days = d
+	}
+
+	linked, err := DetectTransfers(ctx, days)
+	if err != nil {
+		span.SetStatus(codes.Error, "Unable to detect transfers")
+		log.Error().Err(err).Msg("Unable to detect transfers")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to detect transfers"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"linked": linked}})
+}
+
+// GET /transfers
+// Find transfers with both transactions, ?status= limits them to one status
+func FindTransfers(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transfer.FindTransfers")
+	defer span.End()
+
+	query := DB.WithContext(ctx).Preload("From", unscoped).Preload("To", unscoped).Order("id")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	transfers := []Transfer{}
+	if err := query.Find(&transfers).Error; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to retreive data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": transfers})
+}
+
+// PATCH /transfers/:id
+// Dismiss a transfer that isn't one, or link it again
+func UpdateTransfer(c *gin.Context) {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "transfer.UpdateTransfer")
+	defer span.End()
+
+	var transfer Transfer
+	if err := DB.WithContext(ctx).Where("id = ?", c.Param("id")).First(&transfer).Error; err != nil {
+		span.SetStatus(codes.Error, "Record not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	var input UpdateTransferInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid request parameters")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request parameters"})
+		return
+	}
+	if input.Status != TransferLinked && input.Status != TransferDismissed {
+		span.SetStatus(codes.Error, "Invalid status")
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid status %s", input.Status)})
+		return
+	}
+	if input.Status == TransferLinked && transfer.Status != TransferLinked {
+		var others int64
+		err := DB.WithContext(ctx).Model(&Transfer{}).Where("status = ? AND id <> ?", TransferLinked, transfer.ID).
+			Where("from_id IN ? OR to_id IN ?", []uint{transfer.FromID, transfer.ToID}, []uint{transfer.FromID, transfer.ToID}).
+			Count(&others).Error
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Unable to retreive data")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retreive data"})
+			return
+		}
+		if others > 0 {
+			span.SetStatus(codes.Error, "Transaction already in a transfer")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "transaction already in a transfer"})
+			return
+		}
+	}
+
+	transfer.Status = input.Status
+	if err := DB.WithContext(ctx).Save(&transfer).Error; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update transfer")
+		log.Error().Caller().Err(err).Msg("Failed to update transfer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transfer"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": transfer})
+}
+
+// runTransfers links transfers from the command line
+func runTransfers(ctx context.Context) error {
+	linked, err := DetectTransfers(ctx, *transfersDays)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Linked %d transfers", linked)
+	return nil
+}
+
+// detectTransfers links the transaction with the given id, and the rows linked
+// to it, as transfers where they are one. Failing to isn't fatal, the next
+// detection picks them up.
+func detectTransfers(ctx context.Context, id uint) {
+	if _, err := DetectTransfers(ctx, DefaultTransferDays, id); err != nil {
+		log.Error().Caller().Err(err).Uint("id", id).Msg("Unable to detect transfers")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransfers(t *testing.T) {
+	defer useDatabase()()
+	saved := now
+	defer func() { now = saved }()
+	now = func() time.Time { return time.Date(2023, time.February, 1, 9, 0, 0, 0, time.UTC) }
+
+	DB.Create(&Account{ID: 1, Name: "Spending"})
+	DB.Create(&Account{ID: 2, Name: "Saver"})
+	day := func(d, m int) time.Time { return time.Date(2023, time.January, d, 10, m, 0, 0, time.UTC) }
+	DB.Create(&Transaction{Md5: "1", Description: "Transfer to Saver", Amount: -10000, Currency: "AUD", Account: 1, Created: day(1, 0)})
+	DB.Create(&Transaction{Md5: "2", Description: "Transfer from Spending", Amount: 10000, Currency: "AUD", Account: 2, Created: day(1, 5)})
+	DB.Create(&Transaction{Md5: "3", Description: "Woolworths", Amount: -5000, Currency: "AUD", Account: 1, Created: day(2, 0)})
+	DB.Create(&Transaction{Md5: "4", Description: "Refund", Amount: 5000, Currency: "AUD", Account: 9, Created: day(2, 0)})
+	DB.Create(&Transaction{Md5: "5", Description: "Transfer to Saver", Amount: -2000, Currency: "AUD", Account: 1, Created: day(1, 0)})
+	DB.Create(&Transaction{Md5: "6", Description: "Transfer from Spending", Amount: 2000, Currency: "AUD", Account: 2, Created: day(10, 0)})
+	DB.Create(&Transaction{Md5: "7", Description: "Deposit", Amount: 10000, Currency: "AUD", Account: 2, Created: day(2, 0)})
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		id          string
+		body        string
+		handler     gin.HandlerFunc
+		status_code int
+		expect      string
+	}{
+		{
+			"InvalidDays",
+			"POST",
+			"/transfers/detect?days=x",
+			"",
+			"",
+			DetectTransfersHandler,
+			400,
+			`{"error":"invalid days x"}`,
+		},
+		{
+			"Detect",
+			"POST",
+			"/transfers/detect",
+			"",
+			"",
+			DetectTransfersHandler,
+			200,
+			`{"data":{"linked":1}}`,
+		},
+		{
+			"AlreadyLinked",
+			"POST",
+			"/transfers/detect",
+			"",
+			"",
+			DetectTransfersHandler,
+			200,
+			`{"data":{"linked":0}}`,
+		},
+		{
+			"Summary",
+			"GET",
+			"/transactions/summary?group_by=account",
+			"",
+			"",
+			SummariseTransactions,
+			200,
+			`{"data":[{"account":1,"avg":-35,"count":2,"max":-20,"min":-50,"sum":-70},{"account":2,"avg":60,"count":2,"max":100,"min":20,"sum":120},{"account":9,"avg":50,"count":1,"max":50,"min":50,"sum":50}]}`,
+		},
+		{
+			"SummaryWithTransfers",
+			"GET",
+			"/transactions/summary?group_by=account&transfers=true",
+			"",
+			"",
+			SummariseTransactions,
+			200,
+			`{"data":[{"account":1,"avg":-56.67,"count":3,"max":-20,"min":-100,"sum":-170},{"account":2,"avg":73.33,"count":3,"max":100,"min":20,"sum":220},{"account":9,"avg":50,"count":1,"max":50,"min":50,"sum":50}]}`,
+		},
+		{
+			"InvalidTransfers",
+			"GET",
+			"/transactions/summary?transfers=maybe",
+			"",
+			"",
+			SummariseTransactions,
+			400,
+			`{"error":"invalid transfers maybe"}`,
+		},
+		{
+			"Linked",
+			"GET",
+			"/transfers?status=linked",
+			"",
+			"",
+			FindTransfers,
+			200,
+			`{"data":[` +
+				`{"id":1,"from_id":1,"to_id":2,"status":"linked","created":"2023-02-01T09:00:00Z",` +
+				`"from":{"id":1,"description":"Transfer to Saver","amount":-100,"currency":"AUD","account":1,"created":"2023-01-01T10:00:00Z"},` +
+				`"to":{"id":2,"description":"Transfer from Spending","amount":100,"currency":"AUD","account":2,"created":"2023-01-01T10:05:00Z"}}` +
+				`]}`,
+		},
+		{
+			"InvalidStatus",
+			"PATCH",
+			"/transfers/1",
+			"1",
+			`{"status":"maybe"}`,
+			UpdateTransfer,
+			400,
+			`{"error":"invalid status maybe"}`,
+		},
+		{
+			"Missing",
+			"PATCH",
+			"/transfers/9",
+			"9",
+			`{"status":"dismissed"}`,
+			UpdateTransfer,
+			404,
+			`{"error":"Record not found"}`,
+		},
+		{
+			"Dismiss",
+			"PATCH",
+			"/transfers/1",
+			"1",
+			`{"status":"dismissed"}`,
+			UpdateTransfer,
+			200,
+			`{"data":{"id":1,"from_id":1,"to_id":2,"status":"dismissed","created":"2023-02-01T09:00:00Z"}}`,
+		},
+		{
+			"NextClosest",
+			"POST",
+			"/transfers/detect?days=10",
+			"",
+			"",
+			DetectTransfersHandler,
+			200,
+			`{"data":{"linked":2}}`,
+		},
+		{
+			"Relink",
+			"PATCH",
+			"/transfers/1",
+			"1",
+			`{"status":"linked"}`,
+			UpdateTransfer,
+			400,
+			`{"error":"transaction already in a transfer"}`,
+		},
+		{
+			"All",
+			"GET",
+			"/transfers",
+			"",
+			"",
+			FindTransfers,
+			200,
+			`{"data":[` +
+				`{"id":1,"from_id":1,"to_id":2,"status":"dismissed","created":"2023-02-01T09:00:00Z",` +
+				`"from":{"id":1,"description":"Transfer to Saver","amount":-100,"currency":"AUD","account":1,"created":"2023-01-01T10:00:00Z"},` +
+				`"to":{"id":2,"description":"Transfer from Spending","amount":100,"currency":"AUD","account":2,"created":"2023-01-01T10:05:00Z"}},` +
+				`{"id":2,"from_id":1,"to_id":7,"status":"linked","created":"2023-02-01T09:00:00Z",` +
+				`"from":{"id":1,"description":"Transfer to Saver","amount":-100,"currency":"AUD","account":1,"created":"2023-01-01T10:00:00Z"},` +
+				`"to":{"id":7,"description":"Deposit","amount":100,"currency":"AUD","account":2,"created":"2023-01-02T10:00:00Z"}},` +
+				`{"id":3,"from_id":5,"to_id":6,"status":"linked","created":"2023-02-01T09:00:00Z",` +
+				`"from":{"id":5,"description":"Transfer to Saver","amount":-20,"currency":"AUD","account":1,"created":"2023-01-01T10:00:00Z"},` +
+				`"to":{"id":6,"description":"Transfer from Spending","amount":20,"currency":"AUD","account":2,"created":"2023-01-10T10:00:00Z"}}` +
+				`]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(st *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(test.method, test.url, bytes.NewBufferString(test.body))
+			c.Params = []gin.Param{{Key: "id", Value: test.id}}
+
+			test.handler(c)
+
+			assert.Equal(st, test.status_code, w.Code)
+			b, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(st, test.expect, string(b))
+		})
+	}
+}
+
+func TestCreateTransactionDetectsTransfer(t *testing.T) {
+	defer useDatabase()()
+	DB.Create(&Account{ID: 1234, Name: "Spending"})
+	DB.Create(&Account{ID: 5678, Name: "Saver"})
+
+	for _, body := range []string{
+		`{"created":"2023-01-02T09:00:00+11:00","amount":"-4.50","description":"CAFE","account":"1234","source":"up","external_id":"up-1","round_up":"-0.50"}`,
+		`{"created":"2023-01-02T09:00:01+11:00","amount":"0.50","description":"Round Up","account":"5678","source":"up","external_id":"up-2"}`,
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/transactions", bytes.NewBufferString(body))
+		CreateTransaction(c)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	// The round up row of the purchase pairs with the saver's side
+	var transfers []Transfer
+	DB.Find(&transfers)
+	if assert.Len(t, transfers, 1) {
+		assert.Equal(t, uint(2), transfers[0].FromID)
+		assert.Equal(t, uint(3), transfers[0].ToID)
+		assert.Equal(t, TransferLinked, transfers[0].Status)
+	}
+}
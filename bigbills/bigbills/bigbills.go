@@ -220,7 +220,7 @@ type APIResponse struct {
 type APITransaction struct {
 	Id          int64     `json:"id"`
 	Description string    `json:"description"`
-	Amount      float32   `json:"amount"`
+	Amount      float64   `json:"amount"`
 	Account     int64     `json:"account"`
 	Created     time.Time `json:"created"`
 }
@@ -98,6 +98,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	data["account"] = r.RequestURI[1:]
+	data["source"] = "mailparser"
 	span.AddEvent("Data Parsed", trace.WithAttributes(
 		attribute.String("parsed.data", fmt.Sprintf("%v", data)),
 	))
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/codingric/moneyman/pkg/tracing"
@@ -25,12 +26,44 @@ func init() {
 	httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 }
 
+// Source of the transactions sent to the backend, Up ids are only unique
+// among Up transactions
+const Source = "up"
+
 type BackendTransaction struct {
 	Created     time.Time `json:"created" binding:"required"`
 	Amount      string    `json:"amount" binding:"required"`
 	Description string    `json:"description" binding:"required"`
 	Account     string    `json:"account" binding:"required"`
-	Successful  bool      `json:"-"`
+	Currency    string    `json:"currency,omitempty"`
+	// Original amount and currency of foreign transactions
+	ForeignAmount   string `json:"foreign_amount,omitempty"`
+	ForeignCurrency string `json:"foreign_currency,omitempty"`
+	// Up transaction id, posting it again updates the backend row
+	Source     string     `json:"source,omitempty"`
+	ExternalID string     `json:"external_id,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	SettledAt  *time.Time `json:"settled_at,omitempty"`
+	// Up's own details, its category ids are mirrored by PostCategories
+	RawText              string   `json:"raw_text,omitempty"`
+	Message              string   `json:"message,omitempty"`
+	SourceCategory       string   `json:"source_category,omitempty"`
+	SourceParentCategory string   `json:"source_parent_category,omitempty"`
+	Tags                 []string `json:"tags,omitempty"`
+	// Stored on the backend, round ups and cashback also as linked rows
+	HoldAmount          string `json:"hold_amount,omitempty"`
+	RoundUp             string `json:"round_up,omitempty"`
+	RoundUpBoost        string `json:"round_up_boost,omitempty"`
+	Cashback            string `json:"cashback,omitempty"`
+	CashbackDescription string `json:"cashback_description,omitempty"`
+	Successful          bool   `json:"-"`
+}
+
+type Category struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+	Source string `json:"source,omitempty"`
 }
 
 func (t *BackendTransaction) Post(ctx context.Context) error {
@@ -83,3 +116,130 @@ func (t *BackendTransaction) Post(ctx context.Context) error {
 
 	return nil
 }
+
+// PostCategories creates or updates categories in the backend, whose
+// categories endpoint sits next to its transactions one
+func PostCategories(ctx context.Context, categories []Category) error {
+	ctx, span := tracing.NewSpan("backend.PostCategories", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("count", len(categories)))
+
+	base, err := url.Parse(viper.GetString("backend"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid backend")
+		return err
+	}
+	endpoint := base.ResolveReference(&url.URL{Path: "categories"}).String()
+	payload, _ := json.Marshal(map[string]interface{}{"data": categories})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to create Request")
+		log.Error().Err(err).Msg("Unable to create Request")
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failure calling backend")
+		log.Error().Err(err).Msg("Failure calling backend")
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		raw, _ := ioutil.ReadAll(resp.Body)
+		span.SetStatus(codes.Error, "unsucessful statuscode returned")
+		log.Trace().Msgf("Backend reponse: %s", string(raw))
+		return errors.New("unsucessful statuscode returned")
+	}
+	return nil
+}
+
+// Delete soft deletes the backend rows of the Up transaction externalID
+func Delete(ctx context.Context, externalID string) error {
+	ctx, span := tracing.NewSpan("backend.Delete", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("external_id", externalID))
+
+	endpoint := viper.GetString("backend") + "?" + url.Values{"source": {Source}, "external_id": {externalID}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to create Request")
+		log.Error().Err(err).Msg("Unable to create Request")
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failure calling backend")
+		log.Error().Err(err).Msg("Failure calling backend")
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		raw, _ := ioutil.ReadAll(resp.Body)
+		span.SetStatus(codes.Error, "unsucessful statuscode returned")
+		log.Trace().Msgf("Backend reponse: %s", string(raw))
+		return errors.New("unsucessful statuscode returned")
+	}
+	return nil
+}
+
+// Lookup returns the status of the backend row of the Up transaction
+// externalID, found is false when the backend doesn't have it. Deleted rows
+// are found, the backend keeps them deleted when they're posted again
+func Lookup(ctx context.Context, externalID string) (status string, found bool, err error) {
+	ctx, span := tracing.NewSpan("backend.Lookup", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("external_id", externalID))
+
+	endpoint := viper.GetString("backend") + "?" + url.Values{"source": {Source}, "external_id": {externalID}, "deleted": {"true"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Unable to create Request")
+		log.Error().Err(err).Msg("Unable to create Request")
+		return "", false, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failure calling backend")
+		log.Error().Err(err).Msg("Failure calling backend")
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		span.SetStatus(codes.Error, "unsucessful statuscode returned")
+		log.Trace().Msgf("Backend reponse: %s", string(raw))
+		return "", false, errors.New("unsucessful statuscode returned")
+	}
+
+	var result struct {
+		Data []struct {
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failure parsing backend response")
+		return "", false, err
+	}
+	if len(result.Data) == 0 {
+		return "", false, nil
+	}
+	return result.Data[0].Status, true, nil
+}
@@ -13,6 +13,7 @@ import (
 
 	"bou.ke/monkey"
 	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -75,8 +76,9 @@ func Test_BackendTransaction(t *testing.T) {
 				Amount:      "100.00",
 				Description: "Mock",
 				Account:     "1234567890",
+				Currency:    "AUD",
 			}, statuscode: 200},
-			expected{reqbody: `{"created":"2000-01-01T00:00:00+11:00","amount":"100.00","description":"Mock","account":"1234567890"}`},
+			expected{reqbody: `{"created":"2000-01-01T00:00:00+11:00","amount":"100.00","description":"Mock","account":"1234567890","currency":"AUD"}`},
 		},
 	}
 	for _, test := range tests {
@@ -115,3 +117,158 @@ func Test_BackendTransaction(t *testing.T) {
 		})
 	}
 }
+
+func Test_Delete(t *testing.T) {
+	type setup struct {
+		doerr      bool
+		statuscode int
+	}
+	type expected struct {
+		err string
+	}
+	tests := []struct {
+		name     string
+		setup    setup
+		expected expected
+	}{
+		{
+			"FailedResponse",
+			setup{doerr: true},
+			expected{err: `Delete "http://backend/transactions?external_id=up-1&source=up": http.Client.Do error`},
+		},
+		{
+			"Non200Status",
+			setup{statuscode: 400},
+			expected{err: "unsucessful statuscode returned"},
+		},
+		{
+			"OK",
+			setup{statuscode: 200},
+			expected{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			viper.Set("backend", "http://backend/transactions")
+			httpClient = &http.Client{Transport: MockRoundTripper(func(req *http.Request) (res *http.Response, err error) {
+				assert.Equal(tt, "DELETE", req.Method)
+				assert.Equal(tt, "http://backend/transactions?external_id=up-1&source=up", req.URL.String())
+				if test.setup.doerr {
+					return nil, errors.New("http.Client.Do error")
+				}
+				return &http.Response{StatusCode: test.setup.statuscode, Body: ioutil.NopCloser(bytes.NewBufferString("{}"))}, nil
+			})}
+
+			err := Delete(context.Background(), "up-1")
+
+			if test.expected.err != "" && assert.NotNil(tt, err) {
+				assert.Equal(tt, test.expected.err, err.Error())
+			} else {
+				assert.Nil(tt, err)
+			}
+		})
+	}
+}
+
+func Test_Lookup(t *testing.T) {
+	type setup struct {
+		doerr      bool
+		statuscode int
+		body       string
+	}
+	type expected struct {
+		status string
+		found  bool
+		err    string
+	}
+	tests := []struct {
+		name     string
+		setup    setup
+		expected expected
+	}{
+		{
+			"FailedResponse",
+			setup{doerr: true},
+			expected{err: `Get "http://backend/transactions?deleted=true&external_id=up-1&source=up": http.Client.Do error`},
+		},
+		{
+			"Non200Status",
+			setup{statuscode: 500, body: "{}"},
+			expected{err: "unsucessful statuscode returned"},
+		},
+		{
+			"InvalidBody",
+			setup{statuscode: 200, body: "{"},
+			expected{err: "unexpected end of JSON input"},
+		},
+		{
+			"Missing",
+			setup{statuscode: 200, body: `{"data":[],"total":0}`},
+			expected{},
+		},
+		{
+			"Found",
+			setup{statuscode: 200, body: `{"data":[{"id":1,"amount":-12.5,"status":"HELD"}],"total":1}`},
+			expected{status: "HELD", found: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			viper.Set("backend", "http://backend/transactions")
+			httpClient = &http.Client{Transport: MockRoundTripper(func(req *http.Request) (res *http.Response, err error) {
+				assert.Equal(tt, "GET", req.Method)
+				assert.Equal(tt, "http://backend/transactions?deleted=true&external_id=up-1&source=up", req.URL.String())
+				if test.setup.doerr {
+					return nil, errors.New("http.Client.Do error")
+				}
+				return &http.Response{StatusCode: test.setup.statuscode, Body: ioutil.NopCloser(bytes.NewBufferString(test.setup.body))}, nil
+			})}
+
+			status, found, err := Lookup(context.Background(), "up-1")
+
+			assert.Equal(tt, test.expected.status, status)
+			assert.Equal(tt, test.expected.found, found)
+			if test.expected.err != "" && assert.NotNil(tt, err) {
+				assert.Equal(tt, test.expected.err, err.Error())
+			} else {
+				assert.Nil(tt, err)
+			}
+		})
+	}
+}
+
+func Test_PostCategories(t *testing.T) {
+	tests := []struct {
+		name       string
+		doerr      bool
+		statuscode int
+		err        string
+	}{
+		{"FailedResponse", true, 0, `Post "http://backend/categories": http.Client.Do error`},
+		{"Non200Status", false, 400, "unsucessful statuscode returned"},
+		{"OK", false, 200, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			viper.Set("backend", "http://backend/transactions")
+			httpClient = &http.Client{Transport: MockRoundTripper(func(req *http.Request) (res *http.Response, err error) {
+				assert.Equal(tt, "POST", req.Method)
+				assert.Equal(tt, "http://backend/categories", req.URL.String())
+				body, _ := ioutil.ReadAll(req.Body)
+				assert.Equal(tt, `{"data":[{"id":"takeaway","name":"Takeaway","parent":"good-life","source":"up"}]}`, string(body))
+				if test.doerr {
+					return nil, errors.New("http.Client.Do error")
+				}
+				return &http.Response{StatusCode: test.statuscode, Body: ioutil.NopCloser(bytes.NewBufferString("{}"))}, nil
+			})}
+
+			err := PostCategories(context.Background(), []Category{{ID: "takeaway", Name: "Takeaway", Parent: "good-life", Source: Source}})
+
+			if test.err != "" && assert.NotNil(tt, err) {
+				assert.Equal(tt, test.err, err.Error())
+			} else {
+				assert.Nil(tt, err)
+			}
+		})
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/codingric/moneyman/pkg/tracing"
 	"github.com/codingric/moneyman/up-webhook/webhook"
@@ -26,14 +30,80 @@ func main() {
 	if err := Configure(); err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
+	if len(os.Args) > 1 && os.Args[1] == "webhook" {
+		if err := runWebhookCommand(ctx, os.Args[2:]); err != nil {
+			log.Fatal().Err(err).Msg("Webhook command failed")
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		since, profile, accounts, err := parseBackfill(os.Args[2:])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid backfill arguments")
+		}
+		posted, err := webhook.Backfill(ctx, since, profile, accounts)
+		if err != nil {
+			log.Fatal().Err(err).Int("posted", posted).Msg("Backfill failed")
+		}
+		log.Info().Msgf("Backfilled %d transactions", posted)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "categories" {
+		count, err := webhook.SyncCategories(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Mirroring categories failed")
+		}
+		log.Info().Msgf("Mirrored %d categories", count)
+		return
+	}
 	if err := webhook.RunWebhook(ctx); err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 }
 
+type accountsFlag []string
+
+func (a *accountsFlag) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *accountsFlag) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+// parseBackfill reads the arguments of `up-webhook backfill --since DATE
+// [--profile NAME] [--account ID]...`, since is a date in local time or
+// RFC3339
+func parseBackfill(args []string) (since time.Time, profile string, accounts []string, err error) {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	value := flags.String("since", "", "Backfill transactions created since, YYYY-MM-DD or RFC3339")
+	name := flags.String("profile", "", "Profile to backfill, all profiles when omitted")
+	var account accountsFlag
+	flags.Var(&account, "account", "Up account id, all accounts when omitted")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if *value == "" {
+		return since, "", nil, errors.New("missing --since")
+	}
+	if since, err = time.Parse(time.RFC3339, *value); err != nil {
+		if since, err = time.ParseInLocation("2006-01-02", *value, time.Local); err != nil {
+			return since, "", nil, fmt.Errorf("invalid since %s", *value)
+		}
+	}
+	return since, *name, account, nil
+}
+
 func Configure() error {
 	viper.SetDefault("log_level", "INFO")
 	viper.SetDefault("port", 8080)
+	viper.SetDefault("admin_port", 8081)
+	viper.SetDefault("outbox.path", "outbox.db")
+	viper.SetDefault("max_event_age", "24h")
+	viper.SetDefault("agekey", fmt.Sprintf("/etc/%s/age.key", path.Base(os.Args[0])))
+	viper.BindEnv("outbox.path", "OUTBOX_PATH")
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
@@ -50,5 +120,5 @@ func Configure() error {
 	//log.SetLevel().Msg(log.Stol(viper.GetString("log_level")))
 	log.Debug().Msgf("Config loaded `%s`", viper.ConfigFileUsed())
 
-	return nil
+	return decryptSecrets()
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
 	"github.com/codingric/moneyman/up-webhook/webhook"
@@ -19,8 +20,11 @@ func TestMain(m *testing.M) {
 
 func Test_Main(t *testing.T) {
 	type setup struct {
-		configure bool
-		runserver bool
+		configure  bool
+		runserver  bool
+		args       []string
+		backfill   bool
+		categories bool
 	}
 	type expected struct {
 		fatal bool
@@ -46,6 +50,31 @@ func Test_Main(t *testing.T) {
 			setup{},
 			expected{},
 		},
+		{
+			"InvalidBackfill",
+			setup{args: []string{"backfill"}},
+			expected{fatal: true},
+		},
+		{
+			"FailedBackfill",
+			setup{args: []string{"backfill", "--since", "2023-01-01"}, backfill: true},
+			expected{fatal: true},
+		},
+		{
+			"Backfill",
+			setup{args: []string{"backfill", "--since", "2023-01-01"}},
+			expected{},
+		},
+		{
+			"FailedCategories",
+			setup{args: []string{"categories"}, categories: true},
+			expected{fatal: true},
+		},
+		{
+			"Categories",
+			setup{args: []string{"categories"}},
+			expected{},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(tt *testing.T) {
@@ -71,6 +100,24 @@ func Test_Main(t *testing.T) {
 				return nil
 			})
 
+			monkey.Patch(webhook.Backfill, func(context.Context, time.Time, string, []string) (int, error) {
+				if test.setup.backfill {
+					return 0, errors.New("mock error")
+				}
+				return 1, nil
+			})
+
+			monkey.Patch(webhook.SyncCategories, func(context.Context) (int, error) {
+				if test.setup.categories {
+					return 0, errors.New("mock error")
+				}
+				return 1, nil
+			})
+
+			args := os.Args
+			defer func() { os.Args = args }()
+			os.Args = append([]string{args[0]}, test.setup.args...)
+
 			main()
 
 			assert.Equal(tt, test.expected.fatal, fatal)
@@ -78,3 +125,63 @@ func Test_Main(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseBackfill(t *testing.T) {
+	type expected struct {
+		since    time.Time
+		profile  string
+		accounts []string
+		err      string
+	}
+	tests := []struct {
+		name     string
+		args     []string
+		expected expected
+	}{
+		{
+			"MissingSince",
+			[]string{},
+			expected{err: "missing --since"},
+		},
+		{
+			"InvalidSince",
+			[]string{"--since", "yesterday"},
+			expected{err: "invalid since yesterday"},
+		},
+		{
+			"UnknownFlag",
+			[]string{"--until", "2023-01-01"},
+			expected{err: "flag provided but not defined: -until"},
+		},
+		{
+			"Date",
+			[]string{"--since", "2023-01-01"},
+			expected{since: time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local)},
+		},
+		{
+			"Accounts",
+			[]string{"--since", "2023-01-01T09:00:00+11:00", "--account", "a", "--account", "b"},
+			expected{since: time.Date(2023, 1, 1, 9, 0, 0, 0, time.FixedZone("", 11*60*60)), accounts: []string{"a", "b"}},
+		},
+		{
+			"Profile",
+			[]string{"--since", "2023-01-01", "--profile", "alice"},
+			expected{since: time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local), profile: "alice"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			since, profile, accounts, err := parseBackfill(test.args)
+			if test.expected.err != "" {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.expected.err, err.Error())
+				}
+				return
+			}
+			assert.Nil(tt, err)
+			assert.True(tt, test.expected.since.Equal(since), since)
+			assert.Equal(tt, test.expected.profile, profile)
+			assert.Equal(tt, test.expected.accounts, accounts)
+		})
+	}
+}
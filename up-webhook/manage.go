@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	fage "filippo.io/age"
+	"github.com/codingric/moneyman/pkg/age"
+	"github.com/codingric/moneyman/up-webhook/up"
+	"github.com/codingric/moneyman/up-webhook/webhook"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Output of the management commands, replaced in tests
+var stdout io.Writer = os.Stdout
+
+// Config values that may be stored age encrypted
+var secrets = []string{"secret_key", "bearer"}
+
+// runWebhookCommand manages the Up webhook:
+//
+//	webhook register [--profile NAME] --url URL [--description TEXT]
+//	webhook list [--profile NAME]
+//	webhook ping [--profile NAME] [ID]
+//	webhook logs [--profile NAME] [--size N] [ID]
+//	webhook delete [--profile NAME] ID
+//
+// ping and logs default to the registered webhook. --profile is required
+// when several profiles are configured.
+func runWebhookCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing webhook command, one of register, list, ping, logs or delete")
+	}
+	flags := flag.NewFlagSet("webhook "+args[0], flag.ContinueOnError)
+	name := flags.String("profile", "", "Profile whose Up token is used")
+	switch args[0] {
+	case "register":
+		target := flags.String("url", "", "URL Up delivers events to, e.g. the ingress")
+		description := flags.String("description", "moneyman", "Description of the webhook")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *target == "" {
+			return errors.New("missing --url")
+		}
+		profile, err := selectProfile(*name)
+		if err != nil {
+			return err
+		}
+		return registerWebhook(profile.Context(ctx), profile, *target, *description)
+	case "list":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		profile, err := selectProfile(*name)
+		if err != nil {
+			return err
+		}
+		return listWebhooks(profile.Context(ctx), profile)
+	case "ping":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		profile, err := selectProfile(*name)
+		if err != nil {
+			return err
+		}
+		event, err := up.PingWebhook(profile.Context(ctx), webhookID(flags, profile))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Sent %s event %s\n", event.Data.Attributes.EventType, event.Data.Id)
+		return nil
+	case "logs":
+		size := flags.Int("size", 20, "Number of deliveries to show")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		profile, err := selectProfile(*name)
+		if err != nil {
+			return err
+		}
+		return webhookLogs(profile.Context(ctx), webhookID(flags, profile), *size)
+	case "delete":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.Arg(0) == "" {
+			return errors.New("missing webhook id")
+		}
+		profile, err := selectProfile(*name)
+		if err != nil {
+			return err
+		}
+		if err := up.DeleteWebhook(profile.Context(ctx), flags.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Deleted webhook %s\n", flags.Arg(0))
+		return nil
+	}
+	return fmt.Errorf("unknown webhook command %s", args[0])
+}
+
+// selectProfile returns the profile called name, which may only be omitted
+// when a single profile is configured
+func selectProfile(name string) (webhook.Profile, error) {
+	profiles, err := webhook.SelectProfiles(name)
+	if err != nil {
+		return webhook.Profile{}, err
+	}
+	if len(profiles) > 1 {
+		return webhook.Profile{}, errors.New("missing --profile, several profiles are configured")
+	}
+	return profiles[0], nil
+}
+
+// registerWebhook creates a webhook for target and stores its id and age
+// encrypted secret in the profile's config, or prints them when the config
+// is read only. The previously registered webhook is deleted, so registering
+// again rotates the secret.
+func registerWebhook(ctx context.Context, profile webhook.Profile, target, description string) error {
+	previous := profile.WebhookID
+
+	created, err := up.CreateWebhook(ctx, target, description)
+	if err != nil {
+		return err
+	}
+	secret, err := encryptSecret(created.Attributes.SecretKey)
+	if err == nil {
+		err = updateConfig(profile.Name, map[string]string{"webhook_id": created.Id, "secret_key": secret})
+		if errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS) {
+			// A read only config, e.g. mounted from a secret, is updated by
+			// hand rather than losing the webhook
+			log.Warn().Err(err).Msg("Unable to save webhook to the config")
+			in := "the config"
+			if profile.Name != "" {
+				in = "profile " + profile.Name
+			}
+			fmt.Fprintf(stdout, "Unable to save the config, add to %s:\n  webhook_id: %s\n  secret_key: %s\n", in, created.Id, secret)
+			err = nil
+		}
+	}
+	if err != nil {
+		// Without its secret the webhook's events can't be validated
+		if err := up.DeleteWebhook(ctx, created.Id); err != nil {
+			log.Error().Err(err).Msgf("Failed to delete webhook %s", created.Id)
+		}
+		return err
+	}
+	setProfile(profile.Name, map[string]string{"webhook_id": created.Id, "secret_key": created.Attributes.SecretKey})
+	fmt.Fprintf(stdout, "Registered webhook %s for %s\n", created.Id, created.Attributes.Url)
+
+	if previous != "" && previous != created.Id {
+		if err := up.DeleteWebhook(ctx, previous); err != nil {
+			return fmt.Errorf("deleting previous webhook %s: %w", previous, err)
+		}
+		fmt.Fprintf(stdout, "Deleted previous webhook %s\n", previous)
+	}
+	return nil
+}
+
+func listWebhooks(ctx context.Context, profile webhook.Profile) error {
+	webhooks, err := up.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tURL\tDESCRIPTION\tCREATED")
+	for _, hook := range webhooks {
+		id := hook.Id
+		if id == profile.WebhookID {
+			id += " *"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id, hook.Attributes.Url, hook.Attributes.Description, hook.Attributes.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func webhookLogs(ctx context.Context, id string, size int) error {
+	logs, err := up.WebhookLogs(ctx, id, size)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED\tSTATUS\tRESPONSE")
+	for _, l := range logs {
+		response := "-"
+		if r := l.Attributes.Response; r != nil {
+			response = fmt.Sprintf("%d %s", r.StatusCode, strings.TrimSpace(r.Body))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", l.Attributes.CreatedAt.Format("2006-01-02 15:04:05"), l.Attributes.DeliveryStatus, response)
+	}
+	return w.Flush()
+}
+
+// webhookID is the first argument, or the profile's registered webhook
+func webhookID(flags *flag.FlagSet, profile webhook.Profile) string {
+	if id := flags.Arg(0); id != "" {
+		return id
+	}
+	return profile.WebhookID
+}
+
+// encryptSecret encrypts secret to the age key, in the `age:` format
+// decrypted on start up
+func encryptSecret(secret string) (string, error) {
+	if err := age.Init(viper.GetString("agekey")); err != nil {
+		return "", fmt.Errorf("unable to load agekey: `%s`", viper.GetString("agekey"))
+	}
+	b := &bytes.Buffer{}
+	w, err := fage.Encrypt(b, age.AgeKey.Recipient())
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(w, secret); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return "age:" + base64.StdEncoding.EncodeToString(b.Bytes()), nil
+}
+
+// decryptSecrets replaces the age encrypted config values, including those
+// of each profile, with their plain text
+func decryptSecrets() error {
+	loaded := false
+	decrypt := func(value string) (string, error) {
+		if !strings.HasPrefix(value, "age:") {
+			return value, nil
+		}
+		if !loaded {
+			if err := age.Init(viper.GetString("agekey")); err != nil {
+				return "", fmt.Errorf("unable to load agekey: `%s`", viper.GetString("agekey"))
+			}
+			loaded = true
+		}
+		return age.DecodeAge(value, age.AgeKey), nil
+	}
+
+	for _, key := range secrets {
+		value, err := decrypt(viper.GetString(key))
+		if err != nil {
+			return err
+		}
+		if value != viper.GetString(key) {
+			viper.Set(key, value)
+		}
+	}
+
+	profiles, _ := viper.Get("profiles").([]interface{})
+	for _, p := range profiles {
+		profile, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range secrets {
+			value, ok := profile[key].(string)
+			if !ok {
+				continue
+			}
+			decrypted, err := decrypt(value)
+			if err != nil {
+				return err
+			}
+			profile[key] = decrypted
+		}
+	}
+	if profiles != nil {
+		viper.Set("profiles", profiles)
+	}
+	return nil
+}
+
+// setProfile sets values of the profile called name in the loaded config,
+// the top level values when name is empty
+func setProfile(name string, values map[string]string) {
+	if name == "" {
+		for k, v := range values {
+			viper.Set(k, v)
+		}
+		return
+	}
+	profiles, _ := viper.Get("profiles").([]interface{})
+	for _, p := range profiles {
+		if profile, ok := p.(map[string]interface{}); ok && profile["name"] == name {
+			for k, v := range values {
+				profile[k] = v
+			}
+		}
+	}
+	viper.Set("profiles", profiles)
+}
+
+// updateConfig sets values of the profile called name, or the top level
+// values when name is empty, in the config file keeping the rest of it,
+// comments and order included. config.yaml is created when no config was
+// loaded.
+func updateConfig(name string, values map[string]string) error {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		path = "config.yaml"
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("parsing %s: not a mapping", path)
+	}
+	target := root
+	if name != "" {
+		target = nil
+		if profiles := mappingValue(root, "profiles"); profiles != nil && profiles.Kind == yaml.SequenceNode {
+			for _, profile := range profiles.Content {
+				if n := mappingValue(profile, "name"); n != nil && n.Value == name {
+					target = profile
+				}
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("profile %s not found in %s", name, path)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: values[k]}
+		if existing := mappingValue(target, k); existing != nil {
+			value.HeadComment, value.LineComment, value.FootComment = existing.HeadComment, existing.LineComment, existing.FootComment
+			*existing = *value
+			continue
+		}
+		target.Content = append(target.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, value)
+	}
+
+	b := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0600)
+}
+
+// mappingValue is the value of key in the mapping node, nil when it's
+// missing or node isn't a mapping
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"bou.ke/monkey"
+	fage "filippo.io/age"
+	"github.com/codingric/moneyman/up-webhook/up"
+	"github.com/codingric/moneyman/up-webhook/webhook"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeUp serves Up API responses by method and path and records the
+// requests made
+type fakeUp struct {
+	responses map[string]string
+	requests  []string
+}
+
+func (f *fakeUp) Do(req *http.Request) (*http.Response, error) {
+	call := req.Method + " " + req.URL.Path
+	f.requests = append(f.requests, call)
+	body, ok := f.responses[call]
+	if !ok {
+		return nil, errors.New("unexpected request " + call)
+	}
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+func Test_runWebhookCommand(t *testing.T) {
+	// main() in Test_Main shuts its tracer provider down
+	otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	identity, _ := fage.GenerateX25519Identity()
+	dir := t.TempDir()
+	keypath := filepath.Join(dir, "age.key")
+	os.WriteFile(keypath, []byte("# test key\n"+identity.String()+"\n"), 0600)
+
+	fake := &fakeUp{responses: map[string]string{
+		"POST /api/v1/webhooks":          `{"data":{"type":"webhooks","id":"new","attributes":{"url":"https://example.com/up","description":"moneyman","secretKey":"s3cret","createdAt":"2023-01-01T09:00:00+11:00"}}}`,
+		"DELETE /api/v1/webhooks/old":    ``,
+		"GET /api/v1/webhooks":           `{"data":[{"type":"webhooks","id":"new","attributes":{"url":"https://example.com/up","description":"moneyman","createdAt":"2023-01-01T09:00:00+11:00"}},{"type":"webhooks","id":"other","attributes":{"url":"https://example.com/other","createdAt":"2022-06-01T09:00:00+10:00"}}],"links":{"next":null}}`,
+		"POST /api/v1/webhooks/new/ping": `{"data":{"type":"webhook-events","id":"ev1","attributes":{"eventType":"PING"}}}`,
+		"GET /api/v1/webhooks/new/logs":  `{"data":[{"type":"webhook-delivery-logs","id":"l1","attributes":{"request":{"body":"{}"},"response":{"statusCode":200,"body":"OK\n"},"deliveryStatus":"DELIVERED","createdAt":"2023-01-01T09:00:00+11:00"}},{"type":"webhook-delivery-logs","id":"l2","attributes":{"request":{"body":"{}"},"response":null,"deliveryStatus":"UNDELIVERABLE","createdAt":"2023-01-01T08:00:00+11:00"}}],"links":{"next":null}}`,
+		"DELETE /api/v1/webhooks/other":  ``,
+	}}
+
+	tests := []struct {
+		name     string
+		args     []string
+		err      string
+		output   string
+		requests []string
+	}{
+		{
+			"Missing",
+			[]string{},
+			"missing webhook command, one of register, list, ping, logs or delete",
+			"",
+			nil,
+		},
+		{
+			"Unknown",
+			[]string{"rotate"},
+			"unknown webhook command rotate",
+			"",
+			nil,
+		},
+		{
+			"RegisterMissingUrl",
+			[]string{"register"},
+			"missing --url",
+			"",
+			nil,
+		},
+		{
+			"Register",
+			[]string{"register", "--url", "https://example.com/up"},
+			"",
+			"Registered webhook new for https://example.com/up\nDeleted previous webhook old\n",
+			[]string{"POST /api/v1/webhooks", "DELETE /api/v1/webhooks/old"},
+		},
+		{
+			"List",
+			[]string{"list"},
+			"",
+			"ID     URL                        DESCRIPTION  CREATED\n" +
+				"new *  https://example.com/up     moneyman     2023-01-01 09:00\n" +
+				"other  https://example.com/other               2022-06-01 09:00\n",
+			[]string{"GET /api/v1/webhooks"},
+		},
+		{
+			"Ping",
+			[]string{"ping"},
+			"",
+			"Sent PING event ev1\n",
+			[]string{"POST /api/v1/webhooks/new/ping"},
+		},
+		{
+			"Logs",
+			[]string{"logs", "--size", "2"},
+			"",
+			"CREATED              STATUS         RESPONSE\n" +
+				"2023-01-01 09:00:00  DELIVERED      200 OK\n" +
+				"2023-01-01 08:00:00  UNDELIVERABLE  -\n",
+			[]string{"GET /api/v1/webhooks/new/logs"},
+		},
+		{
+			"DeleteMissingId",
+			[]string{"delete"},
+			"missing webhook id",
+			"",
+			nil,
+		},
+		{
+			"Delete",
+			[]string{"delete", "other"},
+			"",
+			"Deleted webhook other\n",
+			[]string{"DELETE /api/v1/webhooks/other"},
+		},
+		{
+			"UpFailure",
+			[]string{"ping", "gone"},
+			"Failure while requesting https://api.up.com.au/api/v1/webhooks/gone/ping",
+			"",
+			[]string{"POST /api/v1/webhooks/gone/ping"},
+		},
+	}
+
+	config := filepath.Join(dir, "config.yaml")
+	os.WriteFile(config, []byte("backend: http://backend/transactions\nwebhook_id: old\nsecret_key: plain\n"), 0600)
+	viper.Reset()
+	viper.SetConfigFile(config)
+	viper.ReadInConfig()
+	viper.Set("agekey", keypath)
+
+	saved := up.UpService
+	defer func() { up.UpService = saved }()
+	up.UpService = fake
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			out := &bytes.Buffer{}
+			stdout = out
+			fake.requests = nil
+
+			err := runWebhookCommand(context.Background(), test.args)
+
+			if test.err != "" {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.err, err.Error())
+				}
+			} else {
+				assert.Nil(tt, err)
+			}
+			assert.Equal(tt, test.output, out.String())
+			assert.Equal(tt, test.requests, fake.requests)
+		})
+	}
+
+	// The new secret is stored encrypted alongside the rest of the config
+	raw, _ := os.ReadFile(config)
+	stored := map[string]string{}
+	assert.Nil(t, yaml.Unmarshal(raw, &stored))
+	assert.Equal(t, "http://backend/transactions", stored["backend"])
+	assert.Equal(t, "new", stored["webhook_id"])
+	assert.Regexp(t, "^age:", stored["secret_key"])
+
+	viper.Reset()
+	viper.SetConfigFile(config)
+	viper.ReadInConfig()
+	viper.Set("agekey", keypath)
+	assert.Nil(t, decryptSecrets())
+	assert.Equal(t, "s3cret", viper.GetString("secret_key"))
+
+	viper.Set("agekey", filepath.Join(dir, "missing.key"))
+	viper.Set("secret_key", stored["secret_key"])
+	assert.EqualError(t, decryptSecrets(), "unable to load agekey: `"+filepath.Join(dir, "missing.key")+"`")
+}
+
+func Test_registerWebhookRollback(t *testing.T) {
+	otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	dir := t.TempDir()
+	viper.Reset()
+	viper.Set("agekey", filepath.Join(dir, "missing.key"))
+
+	fake := &fakeUp{responses: map[string]string{
+		"POST /api/v1/webhooks":       `{"data":{"type":"webhooks","id":"new","attributes":{"url":"https://example.com/up","secretKey":"s3cret"}}}`,
+		"DELETE /api/v1/webhooks/new": ``,
+	}}
+	saved := up.UpService
+	defer func() { up.UpService = saved }()
+	up.UpService = fake
+
+	err := registerWebhook(context.Background(), webhook.Profile{}, "https://example.com/up", "moneyman")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"POST /api/v1/webhooks", "DELETE /api/v1/webhooks/new"}, fake.requests)
+	assert.Equal(t, "", viper.GetString("webhook_id"))
+}
+
+func Test_registerWebhookReadOnly(t *testing.T) {
+	otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	identity, _ := fage.GenerateX25519Identity()
+	dir := t.TempDir()
+	keypath := filepath.Join(dir, "age.key")
+	os.WriteFile(keypath, []byte("# test key\n"+identity.String()+"\n"), 0600)
+
+	config := filepath.Join(dir, "config.yaml")
+	os.WriteFile(config, []byte("profiles:\n- name: bob\n  bearer: b\n"), 0600)
+	viper.Reset()
+	viper.SetConfigFile(config)
+	viper.ReadInConfig()
+	viper.Set("agekey", keypath)
+
+	defer monkey.UnpatchAll()
+	monkey.Patch(os.WriteFile, func(name string, data []byte, perm os.FileMode) error {
+		return &fs.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	})
+	fake := &fakeUp{responses: map[string]string{
+		"POST /api/v1/webhooks": `{"data":{"type":"webhooks","id":"new","attributes":{"url":"https://example.com/up","secretKey":"s3cret"}}}`,
+	}}
+	saved := up.UpService
+	defer func() { up.UpService = saved }()
+	up.UpService = fake
+	out := &bytes.Buffer{}
+	stdout = out
+
+	err := registerWebhook(context.Background(), webhook.Profile{Name: "bob"}, "https://example.com/up", "moneyman")
+
+	// The webhook is kept and its secret printed to be added by hand
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"POST /api/v1/webhooks"}, fake.requests)
+	assert.Regexp(t, "^Unable to save the config, add to profile bob:\n  webhook_id: new\n  secret_key: age:[^\n]+\nRegistered webhook new for https://example.com/up\n$", out.String())
+	profiles, err := webhook.SelectProfiles("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cret", profiles[0].SecretKey)
+}
+
+func Test_runWebhookCommandProfiles(t *testing.T) {
+	otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	identity, _ := fage.GenerateX25519Identity()
+	dir := t.TempDir()
+	keypath := filepath.Join(dir, "age.key")
+	os.WriteFile(keypath, []byte("# test key\n"+identity.String()+"\n"), 0600)
+
+	config := filepath.Join(dir, "config.yaml")
+	os.WriteFile(config, []byte("# Up accounts\nprofiles:\n- name: alice\n  bearer: a\n  secret_key: sa\n- name: bob\n  bearer: b # personal\n  webhook_id: old\n  secret_key: sb\nlisten: \":8080\"\n"), 0600)
+	viper.Reset()
+	viper.SetConfigFile(config)
+	viper.ReadInConfig()
+	viper.Set("agekey", keypath)
+
+	fake := &fakeUp{responses: map[string]string{
+		"POST /api/v1/webhooks":       `{"data":{"type":"webhooks","id":"new","attributes":{"url":"https://example.com/up/bob","secretKey":"s3cret"}}}`,
+		"DELETE /api/v1/webhooks/old": ``,
+	}}
+	saved := up.UpService
+	defer func() { up.UpService = saved }()
+	up.UpService = fake
+	stdout = &bytes.Buffer{}
+
+	assert.EqualError(t, runWebhookCommand(context.Background(), []string{"ping"}), "missing --profile, several profiles are configured")
+	assert.EqualError(t, runWebhookCommand(context.Background(), []string{"ping", "--profile", "carol"}), "unknown profile carol")
+	assert.Nil(t, fake.requests)
+
+	assert.Nil(t, runWebhookCommand(context.Background(), []string{"register", "--profile", "bob", "--url", "https://example.com/up/bob"}))
+	assert.Equal(t, []string{"POST /api/v1/webhooks", "DELETE /api/v1/webhooks/old"}, fake.requests)
+
+	// The new webhook is in use straight away
+	profiles, err := webhook.SelectProfiles("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, "new", profiles[0].WebhookID)
+	assert.Equal(t, "s3cret", profiles[0].SecretKey)
+
+	raw, _ := os.ReadFile(config)
+	stored := struct {
+		Profiles []map[string]string `yaml:"profiles"`
+	}{}
+	assert.Nil(t, yaml.Unmarshal(raw, &stored))
+	assert.Equal(t, map[string]string{"name": "alice", "bearer": "a", "secret_key": "sa"}, stored.Profiles[0])
+	assert.Equal(t, "new", stored.Profiles[1]["webhook_id"])
+	assert.Regexp(t, "^age:", stored.Profiles[1]["secret_key"])
+	// Comments and order are kept
+	assert.Regexp(t, `^# Up accounts\nprofiles:\n(.|\n)*bearer: b # personal\n    webhook_id: new\n    secret_key: age:[^\n]*\nlisten: ":8080"\n$`, string(raw))
+
+	viper.Reset()
+	viper.SetConfigFile(config)
+	viper.ReadInConfig()
+	viper.Set("agekey", keypath)
+	assert.Nil(t, decryptSecrets())
+	profiles, err = webhook.Profiles()
+	assert.Nil(t, err)
+	assert.Equal(t, "sa", profiles[0].SecretKey)
+	assert.Equal(t, "s3cret", profiles[1].SecretKey)
+
+	assert.EqualError(t, updateConfig("carol", map[string]string{"webhook_id": "x"}), "profile carol not found in "+config)
+}
+
+func Test_setProfile(t *testing.T) {
+	viper.Reset()
+	viper.Set("profiles", []interface{}{
+		map[string]interface{}{"name": "alice", "webhook_id": "a"},
+		map[string]interface{}{"name": "bob", "webhook_id": "b"},
+	})
+
+	setProfile("bob", map[string]string{"webhook_id": "new"})
+	setProfile("", map[string]string{"webhook_id": "top"})
+
+	profiles, err := webhook.Profiles()
+	assert.Nil(t, err)
+	assert.Equal(t, "a", profiles[0].WebhookID)
+	assert.Equal(t, "new", profiles[1].WebhookID)
+	assert.Equal(t, "top", viper.GetString("webhook_id"))
+}
@@ -0,0 +1,77 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Admin serves the outbox on the admin port, which must not be exposed
+// alongside the webhook
+//
+//	GET /outbox                    pending entries
+//	GET /outbox/dead               dead letters
+//	POST /outbox/dead/{id}/replay  retry a dead letter
+func (o *Outbox) Admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/outbox", o.listHandler(o.Pending))
+	mux.HandleFunc("/outbox/dead", o.listHandler(o.DeadLetters))
+	mux.HandleFunc("/outbox/dead/", o.replayHandler)
+	return mux
+}
+
+func (o *Outbox) listHandler(list func() ([]Entry, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "Method not allowed"})
+			return
+		}
+		entries, err := list()
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to read outbox")
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "Unable to read outbox"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": entries})
+	}
+}
+
+func (o *Outbox) replayHandler(w http.ResponseWriter, r *http.Request) {
+	param := strings.TrimPrefix(r.URL.Path, "/outbox/dead/")
+	if !strings.HasSuffix(param, "/replay") {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "Not found"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "Method not allowed"})
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(param, "/replay"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid id"})
+		return
+	}
+
+	entry, err := o.Replay(id)
+	if errors.Is(err, ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "Record not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Uint64("id", id).Msg("Unable to replay dead letter")
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "Unable to replay dead letter"})
+		return
+	}
+	log.Info().Uint64("id", id).Msg("Replaying dead letter")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": entry})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
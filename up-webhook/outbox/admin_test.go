@@ -0,0 +1,106 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Admin(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	o := open(t, &clock, func(b *backend.BackendTransaction) error {
+		return errors.New("mock error")
+	})
+	o.MaxAttempts = 1
+	o.Enqueue(backend.BackendTransaction{ExternalID: "dead"})
+	o.Process(context.Background())
+	o.Enqueue(backend.BackendTransaction{ExternalID: "pending"})
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		resp_code int
+		resp_body string
+	}{
+		{
+			"Pending",
+			"GET",
+			"/outbox",
+			200,
+			`{"data":[{"id":2,"transaction":{"created":"0001-01-01T00:00:00Z","amount":"","description":"","account":"","external_id":"pending"},"attempts":0,"created":"2023-01-01T09:00:00Z","next_attempt":"2023-01-01T09:00:00Z"}]}` + "\n",
+		},
+		{
+			"DeadLetters",
+			"GET",
+			"/outbox/dead",
+			200,
+			`{"data":[{"id":1,"transaction":{"created":"0001-01-01T00:00:00Z","amount":"","description":"","account":"","external_id":"dead"},"attempts":1,"created":"2023-01-01T09:00:00Z","next_attempt":"2023-01-01T09:01:00Z","last_error":"mock error"}]}` + "\n",
+		},
+		{
+			"ListMethod",
+			"POST",
+			"/outbox/dead",
+			405,
+			`{"error":"Method not allowed"}` + "\n",
+		},
+		{
+			"ReplayMethod",
+			"GET",
+			"/outbox/dead/1/replay",
+			405,
+			`{"error":"Method not allowed"}` + "\n",
+		},
+		{
+			"ReplayInvalid",
+			"POST",
+			"/outbox/dead/x/replay",
+			400,
+			`{"error":"Invalid id"}` + "\n",
+		},
+		{
+			"ReplayMissing",
+			"POST",
+			"/outbox/dead/2/replay",
+			404,
+			`{"error":"Record not found"}` + "\n",
+		},
+		{
+			"UnknownPath",
+			"POST",
+			"/outbox/dead/1",
+			404,
+			`{"error":"Not found"}` + "\n",
+		},
+		{
+			"Replay",
+			"POST",
+			"/outbox/dead/1/replay",
+			200,
+			`{"data":{"id":1,"transaction":{"created":"0001-01-01T00:00:00Z","amount":"","description":"","account":"","external_id":"dead"},"attempts":0,"created":"2023-01-01T09:00:00Z","next_attempt":"2023-01-01T09:00:00Z","last_error":"mock error"}}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			request := httptest.NewRequest(test.method, test.url, nil)
+			response := httptest.NewRecorder()
+
+			o.Admin().ServeHTTP(response, request)
+
+			body, _ := ioutil.ReadAll(response.Body)
+			assert.Equal(tt, test.resp_code, response.Code)
+			assert.Equal(tt, test.resp_body, string(body))
+		})
+	}
+
+	dead, _ := o.DeadLetters()
+	assert.Len(t, dead, 0)
+	pending, _ := o.Pending()
+	assert.Len(t, pending, 2)
+}
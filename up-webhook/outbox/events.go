@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Processed reports whether the webhook event id was already handled
+func (o *Outbox) Processed(id string) (bool, error) {
+	seen := false
+	err := o.db.View(func(tx *bolt.Tx) error {
+		seen = tx.Bucket(eventsBucket).Get([]byte(id)) != nil
+		return nil
+	})
+	return seen, err
+}
+
+// MarkProcessed remembers the webhook event id, created at created, as
+// handled so later deliveries of it can be acknowledged straight away
+func (o *Outbox) MarkProcessed(id string, created time.Time) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(eventsBucket).Put([]byte(id), []byte(created.UTC().Format(time.RFC3339Nano)))
+	})
+}
+
+// PruneEvents forgets the processed events created before before, returning
+// how many were removed
+func (o *Outbox) PruneEvents(before time.Time) (int, error) {
+	pruned := 0
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			created, err := time.Parse(time.RFC3339Nano, string(v))
+			if err != nil || created.Before(before) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(expired)
+		return nil
+	})
+	return pruned, err
+}
@@ -0,0 +1,33 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Events(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	o := open(t, &clock, nil)
+
+	seen, err := o.Processed("ev1")
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	assert.Nil(t, o.MarkProcessed("ev1", clock.Add(-2*time.Hour)))
+	assert.Nil(t, o.MarkProcessed("ev2", clock))
+
+	seen, err = o.Processed("ev1")
+	assert.Nil(t, err)
+	assert.True(t, seen)
+
+	pruned, err := o.PruneEvents(clock.Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pruned)
+
+	seen, _ = o.Processed("ev1")
+	assert.False(t, seen)
+	seen, _ = o.Processed("ev2")
+	assert.True(t, seen)
+}
@@ -0,0 +1,299 @@
+package outbox
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/codingric/moneyman/pkg/tracing"
+	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	pendingBucket = []byte("pending")
+	deadBucket    = []byte("dead")
+	eventsBucket  = []byte("events")
+
+	ErrNotFound = errors.New("entry not found")
+)
+
+// Entry is a backend transaction waiting to be delivered
+type Entry struct {
+	ID          uint64                     `json:"id"`
+	Transaction backend.BackendTransaction `json:"transaction"`
+	Attempts    int                        `json:"attempts"`
+	Created     time.Time                  `json:"created"`
+	NextAttempt time.Time                  `json:"next_attempt"`
+	LastError   string                     `json:"last_error,omitempty"`
+	// Delete the transaction's backend rows rather than post it
+	Delete bool `json:"delete,omitempty"`
+}
+
+// Outbox persists backend transactions on disk until they are delivered.
+// Failed deliveries are retried with exponential backoff, entries that still
+// fail after MaxAttempts are moved to the dead letters to be replayed by hand.
+// Entries for the same transaction are delivered in the order they were
+// added, so a delete never overtakes the create it follows. It also
+// remembers the webhook events already processed.
+type Outbox struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Interval the worker checks for due entries when not woken by Enqueue
+	Interval time.Duration
+	// How long processed event ids are remembered
+	EventRetention time.Duration
+
+	db      *bolt.DB
+	wake    chan struct{}
+	now     func() time.Time
+	deliver func(context.Context, *backend.BackendTransaction) error
+	remove  func(context.Context, string) error
+}
+
+// Open the outbox stored at path, creating it if missing
+func Open(path string) (*Outbox, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening outbox %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{pendingBucket, deadBucket, eventsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Outbox{
+		MaxAttempts:    10,
+		BaseDelay:      30 * time.Second,
+		MaxDelay:       6 * time.Hour,
+		Interval:       time.Minute,
+		EventRetention: 24 * time.Hour,
+		db:             db,
+		wake:           make(chan struct{}, 1),
+		now:            time.Now,
+		deliver: func(ctx context.Context, t *backend.BackendTransaction) error {
+			return t.Post(ctx)
+		},
+		remove: backend.Delete,
+	}, nil
+}
+
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+// Enqueue persists t for delivery and wakes the worker
+func (o *Outbox) Enqueue(t backend.BackendTransaction) (Entry, error) {
+	return o.enqueue(Entry{Transaction: t})
+}
+
+// EnqueueDelete persists the deletion of the Up transaction externalID, made
+// once any entries for it ahead in the outbox are delivered
+func (o *Outbox) EnqueueDelete(externalID string) (Entry, error) {
+	return o.enqueue(Entry{Transaction: backend.BackendTransaction{Source: backend.Source, ExternalID: externalID}, Delete: true})
+}
+
+func (o *Outbox) enqueue(entry Entry) (Entry, error) {
+	entry.Created, entry.NextAttempt = o.now(), o.now()
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pendingBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		return put(b, entry)
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return entry, nil
+}
+
+// Pending entries, including those waiting for a retry
+func (o *Outbox) Pending() ([]Entry, error) {
+	return o.list(pendingBucket)
+}
+
+// DeadLetters are the entries that failed MaxAttempts times
+func (o *Outbox) DeadLetters() ([]Entry, error) {
+	return o.list(deadBucket)
+}
+
+// Replay moves the dead letter id back to pending with its attempts reset
+func (o *Outbox) Replay(id uint64) (Entry, error) {
+	var entry Entry
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket(deadBucket)
+		raw := dead.Get(key(id))
+		if raw == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return err
+		}
+		entry.Attempts = 0
+		entry.NextAttempt = o.now()
+		if err := dead.Delete(key(id)); err != nil {
+			return err
+		}
+		return put(tx.Bucket(pendingBucket), entry)
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return entry, nil
+}
+
+// Process attempts delivery of every due entry once, returning how many were
+// delivered
+func (o *Outbox) Process(ctx context.Context) (int, error) {
+	ctx, span := tracing.NewSpan("Outbox.Process", ctx)
+	defer span.End()
+
+	pending, err := o.Pending()
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	delivered := 0
+	// Transactions with an earlier entry still pending
+	waiting := map[string]bool{}
+	for _, entry := range pending {
+		id := entry.Transaction.ExternalID
+		if id != "" && waiting[id] {
+			continue
+		}
+		if entry.NextAttempt.After(o.now()) {
+			waiting[id] = true
+			continue
+		}
+		t := entry.Transaction
+		if entry.Delete {
+			err = o.remove(ctx, t.ExternalID)
+		} else {
+			err = o.deliver(ctx, &t)
+		}
+		if err == nil {
+			if err := o.db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket(pendingBucket).Delete(key(entry.ID))
+			}); err != nil {
+				span.RecordError(err)
+				return delivered, err
+			}
+			delivered++
+			continue
+		}
+
+		waiting[id] = true
+		entry.Attempts++
+		entry.LastError = err.Error()
+		entry.NextAttempt = o.now().Add(o.backoff(entry.Attempts))
+		dead := entry.Attempts >= o.MaxAttempts
+		if err := o.db.Update(func(tx *bolt.Tx) error {
+			if !dead {
+				return put(tx.Bucket(pendingBucket), entry)
+			}
+			if err := tx.Bucket(pendingBucket).Delete(key(entry.ID)); err != nil {
+				return err
+			}
+			return put(tx.Bucket(deadBucket), entry)
+		}); err != nil {
+			span.RecordError(err)
+			return delivered, err
+		}
+		if dead {
+			log.Error().Uint64("id", entry.ID).Int("attempts", entry.Attempts).Str("error", entry.LastError).Msg("Outbox entry dead-lettered")
+		} else {
+			log.Warn().Uint64("id", entry.ID).Int("attempts", entry.Attempts).Time("next_attempt", entry.NextAttempt).Str("error", entry.LastError).Msg("Outbox delivery failed")
+		}
+	}
+	span.SetAttributes(attribute.Int("delivered", delivered))
+	return delivered, nil
+}
+
+// Run delivers entries as they are enqueued and retries failed ones until ctx
+// is done
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := o.Process(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to process outbox")
+		}
+		if _, err := o.PruneEvents(o.now().Add(-o.EventRetention)); err != nil {
+			log.Error().Err(err).Msg("Failed to prune processed events")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// backoff is the delay before the next attempt, doubling from BaseDelay up
+// to MaxDelay
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= o.MaxDelay {
+			return o.MaxDelay
+		}
+	}
+	return delay
+}
+
+func (o *Outbox) list(bucket []byte) ([]Entry, error) {
+	entries := []Entry{}
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func put(b *bolt.Bucket, entry Entry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(entry.ID), raw)
+}
+
+// key of id, big endian so entries are iterated in the order they were added
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.PanicLevel)
+	os.Exit(m.Run())
+}
+
+// open a fresh outbox with a clock and delivery the test controls
+func open(t *testing.T, clock *time.Time, deliver func(*backend.BackendTransaction) error) *Outbox {
+	o, err := Open(filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+	o.MaxAttempts = 3
+	o.BaseDelay = time.Minute
+	o.MaxDelay = 90 * time.Second
+	o.now = func() time.Time { return *clock }
+	o.deliver = func(ctx context.Context, b *backend.BackendTransaction) error { return deliver(b) }
+	return o
+}
+
+func Test_Process(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	failing := true
+	delivered := []string{}
+	o := open(t, &clock, func(b *backend.BackendTransaction) error {
+		if failing && b.ExternalID == "bad" {
+			return errors.New("mock error")
+		}
+		delivered = append(delivered, b.ExternalID)
+		return nil
+	})
+
+	_, err := o.Enqueue(backend.BackendTransaction{ExternalID: "good"})
+	assert.Nil(t, err)
+	bad, err := o.Enqueue(backend.BackendTransaction{ExternalID: "bad"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), bad.ID)
+
+	n, err := o.Process(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"good"}, delivered)
+
+	pending, _ := o.Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Equal(t, "mock error", pending[0].LastError)
+		assert.Equal(t, clock.Add(time.Minute), pending[0].NextAttempt)
+	}
+
+	// Not retried before it is due
+	clock = clock.Add(30 * time.Second)
+	n, _ = o.Process(context.Background())
+	assert.Equal(t, 0, n)
+	pending, _ = o.Pending()
+	assert.Equal(t, 1, pending[0].Attempts)
+
+	// Backoff doubles up to MaxDelay
+	clock = clock.Add(30 * time.Second)
+	o.Process(context.Background())
+	pending, _ = o.Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, 2, pending[0].Attempts)
+		assert.Equal(t, clock.Add(90*time.Second), pending[0].NextAttempt)
+	}
+
+	// Dead-lettered after MaxAttempts
+	clock = clock.Add(90 * time.Second)
+	o.Process(context.Background())
+	pending, _ = o.Pending()
+	assert.Len(t, pending, 0)
+	dead, _ := o.DeadLetters()
+	if assert.Len(t, dead, 1) {
+		assert.Equal(t, uint64(2), dead[0].ID)
+		assert.Equal(t, 3, dead[0].Attempts)
+	}
+
+	// Replayed dead letters are delivered again
+	_, err = o.Replay(9)
+	assert.Equal(t, ErrNotFound, err)
+	entry, err := o.Replay(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, entry.Attempts)
+	failing = false
+	n, _ = o.Process(context.Background())
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"good", "bad"}, delivered)
+	dead, _ = o.DeadLetters()
+	assert.Len(t, dead, 0)
+	pending, _ = o.Pending()
+	assert.Len(t, pending, 0)
+}
+
+func Test_ProcessDeleteAfterCreate(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	down := true
+	calls := []string{}
+	o := open(t, &clock, func(b *backend.BackendTransaction) error {
+		if down {
+			return errors.New("backend down")
+		}
+		calls = append(calls, "post "+b.ExternalID)
+		return nil
+	})
+	o.remove = func(ctx context.Context, externalID string) error {
+		if down {
+			return errors.New("backend down")
+		}
+		calls = append(calls, "delete "+externalID)
+		return nil
+	}
+
+	o.Enqueue(backend.BackendTransaction{ExternalID: "up-1"})
+	deleted, err := o.EnqueueDelete("up-1")
+	assert.Nil(t, err)
+	assert.True(t, deleted.Delete)
+	assert.Equal(t, backend.BackendTransaction{Source: backend.Source, ExternalID: "up-1"}, deleted.Transaction)
+	o.Enqueue(backend.BackendTransaction{ExternalID: "up-2"})
+
+	// The delete waits behind the failed create, other transactions don't
+	n, err := o.Process(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	pending, _ := o.Pending()
+	if assert.Len(t, pending, 3) {
+		assert.Equal(t, []int{1, 0, 1}, []int{pending[0].Attempts, pending[1].Attempts, pending[2].Attempts})
+	}
+
+	// Still waiting while the create is backing off
+	down = false
+	n, _ = o.Process(context.Background())
+	assert.Equal(t, 0, n)
+	assert.Equal(t, []string{}, calls)
+
+	clock = clock.Add(time.Minute)
+	n, _ = o.Process(context.Background())
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"post up-1", "delete up-1", "post up-2"}, calls)
+	pending, _ = o.Pending()
+	assert.Len(t, pending, 0)
+}
+
+func Test_Persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	o, err := Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	o.Enqueue(backend.BackendTransaction{ExternalID: "up-1", Amount: "-12.50"})
+	o.Close()
+
+	o, err = Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer o.Close()
+	pending, _ := o.Pending()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "up-1", pending[0].Transaction.ExternalID)
+		assert.Equal(t, "-12.50", pending[0].Transaction.Amount)
+	}
+}
+
+func Test_Run(t *testing.T) {
+	clock := time.Now()
+	done := make(chan string, 1)
+	o := open(t, &clock, func(b *backend.BackendTransaction) error {
+		done <- b.ExternalID
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.Run(ctx)
+	o.Enqueue(backend.BackendTransaction{ExternalID: "up-1"})
+
+	select {
+	case id := <-done:
+		assert.Equal(t, "up-1", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("entry not delivered")
+	}
+}
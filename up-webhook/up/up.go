@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/codingric/moneyman/pkg/tracing"
@@ -65,17 +67,45 @@ type TransactionAttributes struct {
 	Description string                `json:"description"`
 	Message     string                `json:"message"`
 	Amount      Amount                `json:"amount"`
-	SettledAt   time.Time             `json:"settledAt"`
-	CreatedAt   time.Time             `json:"createdAt"`
+	// Original amount of foreign currency transactions, nil otherwise
+	ForeignAmount *Amount   `json:"foreignAmount"`
+	SettledAt     time.Time `json:"settledAt"`
+	CreatedAt     time.Time `json:"createdAt"`
 	//IsCategorizable bool                  `json:"isCategorizable"`
-	//HoldInfo        HoldInfo              `json:"holdInfo"`
-	//RoundUp         RoundUp               `json:"roundUp"`
-	//Cashback        Cashback              `json:"cashback"`
-	//ForeignAmount   Amount                `json:"foreignAmount"`
+	// Each is nil when it doesn't apply
+	HoldInfo *HoldInfo `json:"holdInfo"`
+	RoundUp  *RoundUp  `json:"roundUp"`
+	Cashback *Cashback `json:"cashback"`
+}
+
+// HoldInfo is the amount of a transaction while it was held
+type HoldInfo struct {
+	Amount        Amount  `json:"amount"`
+	ForeignAmount *Amount `json:"foreignAmount"`
+}
+
+// RoundUp is the negative amount moved to a saver by a purchase, including
+// the boost when one was added
+type RoundUp struct {
+	Amount       Amount  `json:"amount"`
+	BoostPortion *Amount `json:"boostPortion"`
+}
+
+type Cashback struct {
+	Description string `json:"description"`
+	Amount      Amount `json:"amount"`
 }
 
 type TransactionRelationships struct {
 	Account UpTypes `json:"account"`
+	// Data is empty for uncategorised transactions
+	Category       UpTypes    `json:"category"`
+	ParentCategory UpTypes    `json:"parentCategory"`
+	Tags           UpTypeList `json:"tags"`
+}
+
+type UpTypeList struct {
+	Data []UpData `json:"data"`
 }
 
 type Amount struct {
@@ -106,6 +136,21 @@ func init() {
 	UpService = &upService{client: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}}
 }
 
+type bearerKey struct{}
+
+// WithBearer returns a copy of ctx whose Up requests authenticate with token
+// rather than the configured bearer
+func WithBearer(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, bearerKey{}, token)
+}
+
+func bearer(ctx context.Context) string {
+	if token, ok := ctx.Value(bearerKey{}).(string); ok && token != "" {
+		return token
+	}
+	return viper.GetString("bearer")
+}
+
 func (t *UpTransaction) Get(id string, ctx context.Context) error {
 	ctx, span := tracing.NewSpan("UpTransaction.Get", ctx)
 	defer span.End()
@@ -117,7 +162,7 @@ func (t *UpTransaction) Get(id string, ctx context.Context) error {
 	}
 	url := fmt.Sprintf("https://api.up.com.au/api/v1/transactions/%s", id)
 	req, _ := http.NewRequestWithContext(ctx, "GET", url, bytes.NewBuffer([]byte(``)))
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("bearer")))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", bearer(ctx)))
 
 	resp, err := UpService.Do(req)
 	if err != nil {
@@ -154,3 +199,144 @@ func (t *UpTransaction) Get(id string, ctx context.Context) error {
 
 	return nil
 }
+
+// Base of the Up API, list endpoints return absolute links to further pages
+const ApiUrl = "https://api.up.com.au/api/v1"
+
+type PageLinks struct {
+	Prev string `json:"prev"`
+	Next string `json:"next"`
+}
+
+type TransactionList struct {
+	Data  []TransactionResource `json:"data"`
+	Links PageLinks             `json:"links"`
+}
+
+type AccountList struct {
+	Data  []UpData  `json:"data"`
+	Links PageLinks `json:"links"`
+}
+
+// ListAccounts returns the ids of all accounts
+func ListAccounts(ctx context.Context) ([]string, error) {
+	ctx, span := tracing.NewSpan("up.ListAccounts", ctx)
+	defer span.End()
+
+	ids := []string{}
+	next := ApiUrl + "/accounts?page%5Bsize%5D=100"
+	for next != "" {
+		var page AccountList
+		if err := call(ctx, "GET", next, nil, &page); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		for _, a := range page.Data {
+			ids = append(ids, a.Id)
+		}
+		next = page.Links.Next
+	}
+	return ids, nil
+}
+
+// ListTransactions returns the transactions of account created since, paging
+// through the whole list
+func ListTransactions(ctx context.Context, account string, since time.Time) ([]TransactionResource, error) {
+	ctx, span := tracing.NewSpan("up.ListTransactions", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("account", account), attribute.String("since", since.Format(time.RFC3339)))
+
+	if account == "" {
+		return nil, errors.New("ListTransactions requires account")
+	}
+	params := url.Values{"filter[since]": {since.Format(time.RFC3339)}, "page[size]": {"100"}}
+	next := fmt.Sprintf("%s/accounts/%s/transactions?%s", ApiUrl, url.PathEscape(account), params.Encode())
+
+	transactions := []TransactionResource{}
+	for next != "" {
+		var page TransactionList
+		if err := call(ctx, "GET", next, nil, &page); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		transactions = append(transactions, page.Data...)
+		next = page.Links.Next
+	}
+	return transactions, nil
+}
+
+type Category struct {
+	Type       string `json:"type"`
+	Id         string `json:"id"`
+	Attributes struct {
+		Name string `json:"name"`
+	} `json:"attributes"`
+	Relationships struct {
+		// Data is empty for top level categories
+		Parent UpTypes `json:"parent"`
+	} `json:"relationships"`
+}
+
+type CategoryList struct {
+	Data []Category `json:"data"`
+}
+
+// ListCategories returns Up's category tree, parents and children alike
+func ListCategories(ctx context.Context) ([]Category, error) {
+	ctx, span := tracing.NewSpan("up.ListCategories", ctx)
+	defer span.End()
+
+	// Categories are not paginated
+	var list CategoryList
+	if err := call(ctx, "GET", ApiUrl+"/categories", nil, &list); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// call sends an authorised request with payload as its JSON body, if any,
+// and decodes the JSON response into v, if given
+func call(ctx context.Context, method, endpoint string, payload interface{}, v interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequestWithContext(ctx, method, endpoint, body)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", bearer(ctx)))
+	if payload != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	resp, err := UpService.Do(req)
+	if err != nil {
+		log.Error().Msgf("Failed to request %s: %s", endpoint, err.Error())
+		return fmt.Errorf("Failure while requesting %s", endpoint)
+	}
+	defer resp.Body.Close()
+
+	resp_bytes, _ := ioutil.ReadAll(resp.Body)
+	log.Debug().Msgf("Response: %s", string(resp_bytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Error().Msgf("Failed StatusCode %s: %d", endpoint, resp.StatusCode)
+		return fmt.Errorf("Failure StatusCode while requesting %s: %d", endpoint, resp.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(resp_bytes, v); err != nil {
+		log.Error().Msgf("Failure parsing response: %s - %s", endpoint, err.Error())
+		return fmt.Errorf("Failure parsing response for %s", endpoint)
+	}
+	return nil
+}
@@ -8,8 +8,10 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -112,3 +114,130 @@ func Test_UpTransactionGet(t *testing.T) {
 		})
 	}
 }
+
+func Test_ListTransactions(t *testing.T) {
+	since := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := "https://api.up.com.au/api/v1/accounts/acc/transactions?filter%5Bsince%5D=2023-01-01T00%3A00%3A00Z&page%5Bsize%5D=100"
+	second := "https://api.up.com.au/api/v1/accounts/acc/transactions?page%5Bafter%5D=x"
+
+	type setup struct {
+		account string
+		pages   map[string]string
+		do_err  bool
+	}
+	type expected struct {
+		ids []string
+		err string
+	}
+	tests := []struct {
+		name     string
+		setup    setup
+		expected expected
+	}{
+		{
+			"EmptyAccount",
+			setup{},
+			expected{err: "ListTransactions requires account"},
+		},
+		{
+			"FailedDo",
+			setup{account: "acc", do_err: true},
+			expected{err: "Failure while requesting " + first},
+		},
+		{
+			"FailedStatusCode",
+			setup{account: "acc", pages: map[string]string{}},
+			expected{err: "Failure StatusCode while requesting " + first + ": 404"},
+		},
+		{
+			"FailedUnmarshal",
+			setup{account: "acc", pages: map[string]string{first: "{"}},
+			expected{err: "Failure parsing response for " + first},
+		},
+		{
+			"Paged",
+			setup{account: "acc", pages: map[string]string{
+				first:  `{"data":[{"id":"a"},{"id":"b"}],"links":{"prev":null,"next":"` + second + `"}}`,
+				second: `{"data":[{"id":"c"}],"links":{"prev":"` + first + `","next":null}}`,
+			}},
+			expected{ids: []string{"a", "b", "c"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			UpService = &mockService{Returner: func(req *http.Request) (*http.Response, error) {
+				assert.Equal(tt, "Bearer token", req.Header.Get("Authorization"))
+				if test.setup.do_err {
+					return nil, errors.New("Failure from client.Do")
+				}
+				body, ok := test.setup.pages[req.URL.String()]
+				if !ok {
+					return &http.Response{StatusCode: 404, Body: ioutil.NopCloser(bytes.NewBufferString("{}"))}, nil
+				}
+				return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+			}}
+			viper.Set("bearer", "token")
+
+			transactions, err := ListTransactions(context.Background(), test.setup.account, since)
+			if test.expected.err != "" {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.expected.err, err.Error())
+				}
+				return
+			}
+			assert.Nil(tt, err)
+			ids := []string{}
+			for _, r := range transactions {
+				ids = append(ids, r.Id)
+			}
+			assert.Equal(tt, test.expected.ids, ids)
+		})
+	}
+}
+
+func Test_ListAccounts(t *testing.T) {
+	UpService = &mockService{Returner: func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "https://api.up.com.au/api/v1/accounts?page%5Bsize%5D=100", req.URL.String())
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(`{"data":[{"type":"accounts","id":"a"},{"type":"accounts","id":"b"}],"links":{"prev":null,"next":null}}`))}, nil
+	}}
+
+	ids, err := ListAccounts(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids)
+}
+
+func Test_WithBearer(t *testing.T) {
+	headers := []string{}
+	UpService = &mockService{Returner: func(req *http.Request) (*http.Response, error) {
+		headers = append(headers, req.Header.Get("Authorization"))
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(`{"data":{"id":"t1"}}`))}, nil
+	}}
+	viper.Set("bearer", "token")
+
+	var trans UpTransaction
+	assert.Nil(t, trans.Get("t1", context.Background()))
+	assert.Nil(t, trans.Get("t1", WithBearer(context.Background(), "other")))
+	assert.Nil(t, trans.Get("t1", WithBearer(context.Background(), "")))
+
+	assert.Equal(t, []string{"Bearer token", "Bearer other", "Bearer token"}, headers)
+}
+
+func Test_ListCategories(t *testing.T) {
+	UpService = &mockService{Returner: func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "https://api.up.com.au/api/v1/categories", req.URL.String())
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(`{"data":[` +
+			`{"type":"categories","id":"good-life","attributes":{"name":"Good Life"},"relationships":{"parent":{"data":null}}},` +
+			`{"type":"categories","id":"takeaway","attributes":{"name":"Takeaway"},"relationships":{"parent":{"data":{"type":"categories","id":"good-life"}}}}` +
+			`]}`))}, nil
+	}}
+
+	categories, err := ListCategories(context.Background())
+	assert.Nil(t, err)
+	if assert.Len(t, categories, 2) {
+		assert.Equal(t, "Good Life", categories[0].Attributes.Name)
+		assert.Equal(t, "", categories[0].Relationships.Parent.Data.Id)
+		assert.Equal(t, "takeaway", categories[1].Id)
+		assert.Equal(t, "good-life", categories[1].Relationships.Parent.Data.Id)
+	}
+}
@@ -0,0 +1,159 @@
+package up
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/codingric/moneyman/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type Webhook struct {
+	Type       string            `json:"type"`
+	Id         string            `json:"id"`
+	Attributes WebhookAttributes `json:"attributes"`
+}
+
+type WebhookAttributes struct {
+	Url         string `json:"url"`
+	Description string `json:"description,omitempty"`
+	// Only returned when the webhook is created
+	SecretKey string    `json:"secretKey,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookList struct {
+	Data  []Webhook `json:"data"`
+	Links PageLinks `json:"links"`
+}
+
+type WebhookDeliveryLog struct {
+	Type       string `json:"type"`
+	Id         string `json:"id"`
+	Attributes struct {
+		Request struct {
+			Body string `json:"body"`
+		} `json:"request"`
+		// Missing when no response was received
+		Response *struct {
+			StatusCode int    `json:"statusCode"`
+			Body       string `json:"body"`
+		} `json:"response"`
+		DeliveryStatus string    `json:"deliveryStatus"`
+		CreatedAt      time.Time `json:"createdAt"`
+	} `json:"attributes"`
+}
+
+type WebhookDeliveryLogList struct {
+	Data  []WebhookDeliveryLog `json:"data"`
+	Links PageLinks            `json:"links"`
+}
+
+// CreateWebhook registers a webhook delivering events to target, the returned
+// webhook carries the secret key used to sign them
+func CreateWebhook(ctx context.Context, target, description string) (Webhook, error) {
+	ctx, span := tracing.NewSpan("up.CreateWebhook", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("url", target))
+
+	if target == "" {
+		return Webhook{}, errors.New("CreateWebhook requires url")
+	}
+	payload := map[string]interface{}{
+		"data": map[string]interface{}{
+			"attributes": map[string]string{"url": target, "description": description},
+		},
+	}
+	var created struct {
+		Data Webhook `json:"data"`
+	}
+	if err := call(ctx, "POST", ApiUrl+"/webhooks", payload, &created); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return Webhook{}, err
+	}
+	return created.Data, nil
+}
+
+// ListWebhooks returns every registered webhook
+func ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	ctx, span := tracing.NewSpan("up.ListWebhooks", ctx)
+	defer span.End()
+
+	webhooks := []Webhook{}
+	next := ApiUrl + "/webhooks?page%5Bsize%5D=100"
+	for next != "" {
+		var page WebhookList
+		if err := call(ctx, "GET", next, nil, &page); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		webhooks = append(webhooks, page.Data...)
+		next = page.Links.Next
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook unregisters the webhook id
+func DeleteWebhook(ctx context.Context, id string) error {
+	ctx, span := tracing.NewSpan("up.DeleteWebhook", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("id", id))
+
+	if id == "" {
+		return errors.New("DeleteWebhook requires id")
+	}
+	if err := call(ctx, "DELETE", fmt.Sprintf("%s/webhooks/%s", ApiUrl, url.PathEscape(id)), nil, nil); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// PingWebhook asks Up to deliver a PING event to the webhook id
+func PingWebhook(ctx context.Context, id string) (UpWebhookEvent, error) {
+	ctx, span := tracing.NewSpan("up.PingWebhook", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("id", id))
+
+	var event UpWebhookEvent
+	if id == "" {
+		return event, errors.New("PingWebhook requires id")
+	}
+	if err := call(ctx, "POST", fmt.Sprintf("%s/webhooks/%s/ping", ApiUrl, url.PathEscape(id)), nil, &event); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return event, err
+	}
+	return event, nil
+}
+
+// WebhookLogs returns up to size of the most recent deliveries of the
+// webhook id, newest first
+func WebhookLogs(ctx context.Context, id string, size int) ([]WebhookDeliveryLog, error) {
+	ctx, span := tracing.NewSpan("up.WebhookLogs", ctx)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("id", id))
+
+	if id == "" {
+		return nil, errors.New("WebhookLogs requires id")
+	}
+	params := url.Values{"page[size]": {fmt.Sprint(size)}}
+	var page WebhookDeliveryLogList
+	if err := call(ctx, "GET", fmt.Sprintf("%s/webhooks/%s/logs?%s", ApiUrl, url.PathEscape(id), params.Encode()), nil, &page); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	return page.Data, nil
+}
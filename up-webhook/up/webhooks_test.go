@@ -0,0 +1,139 @@
+package up
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Webhooks(t *testing.T) {
+	type request struct {
+		method string
+		url    string
+		body   string
+	}
+	type response struct {
+		code int
+		body string
+	}
+	tests := []struct {
+		name     string
+		call     func() (interface{}, error)
+		request  request
+		response response
+		result   interface{}
+		err      string
+	}{
+		{
+			"CreateMissingUrl",
+			func() (interface{}, error) {
+				w, err := CreateWebhook(context.Background(), "", "")
+				return w.Id, err
+			},
+			request{},
+			response{},
+			"",
+			"CreateWebhook requires url",
+		},
+		{
+			"Create",
+			func() (interface{}, error) {
+				w, err := CreateWebhook(context.Background(), "https://example.com/up", "moneyman")
+				return w.Attributes.SecretKey, err
+			},
+			request{"POST", "https://api.up.com.au/api/v1/webhooks", `{"data":{"attributes":{"description":"moneyman","url":"https://example.com/up"}}}`},
+			response{201, `{"data":{"type":"webhooks","id":"wh1","attributes":{"url":"https://example.com/up","description":"moneyman","secretKey":"s3cret","createdAt":"2023-01-01T09:00:00+11:00"}}}`},
+			"s3cret",
+			"",
+		},
+		{
+			"List",
+			func() (interface{}, error) {
+				webhooks, err := ListWebhooks(context.Background())
+				ids := []string{}
+				for _, w := range webhooks {
+					ids = append(ids, w.Id)
+				}
+				return ids, err
+			},
+			request{"GET", "https://api.up.com.au/api/v1/webhooks?page%5Bsize%5D=100", ""},
+			response{200, `{"data":[{"type":"webhooks","id":"wh1","attributes":{"url":"https://example.com/up"}}],"links":{"prev":null,"next":null}}`},
+			[]string{"wh1"},
+			"",
+		},
+		{
+			"Delete",
+			func() (interface{}, error) {
+				return nil, DeleteWebhook(context.Background(), "wh1")
+			},
+			request{"DELETE", "https://api.up.com.au/api/v1/webhooks/wh1", ""},
+			response{204, ""},
+			nil,
+			"",
+		},
+		{
+			"DeleteFailure",
+			func() (interface{}, error) {
+				return nil, DeleteWebhook(context.Background(), "wh1")
+			},
+			request{"DELETE", "https://api.up.com.au/api/v1/webhooks/wh1", ""},
+			response{404, `{"errors":[]}`},
+			nil,
+			"Failure StatusCode while requesting https://api.up.com.au/api/v1/webhooks/wh1: 404",
+		},
+		{
+			"Ping",
+			func() (interface{}, error) {
+				e, err := PingWebhook(context.Background(), "wh1")
+				return e.Data.Attributes.EventType, err
+			},
+			request{"POST", "https://api.up.com.au/api/v1/webhooks/wh1/ping", ""},
+			response{201, `{"data":{"type":"webhook-events","id":"ev1","attributes":{"eventType":"PING","createdAt":"2023-01-01T09:00:00+11:00"}}}`},
+			"PING",
+			"",
+		},
+		{
+			"Logs",
+			func() (interface{}, error) {
+				logs, err := WebhookLogs(context.Background(), "wh1", 2)
+				statuses := []string{}
+				for _, l := range logs {
+					statuses = append(statuses, l.Attributes.DeliveryStatus)
+				}
+				return statuses, err
+			},
+			request{"GET", "https://api.up.com.au/api/v1/webhooks/wh1/logs?page%5Bsize%5D=2", ""},
+			response{200, `{"data":[{"type":"webhook-delivery-logs","id":"l1","attributes":{"request":{"body":"{}"},"response":{"statusCode":200,"body":"OK"},"deliveryStatus":"DELIVERED"}},{"type":"webhook-delivery-logs","id":"l2","attributes":{"request":{"body":"{}"},"response":null,"deliveryStatus":"UNDELIVERABLE"}}],"links":{"prev":null,"next":null}}`},
+			[]string{"DELIVERED", "UNDELIVERABLE"},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			UpService = &mockService{Returner: func(req *http.Request) (*http.Response, error) {
+				assert.Equal(tt, test.request.method, req.Method)
+				assert.Equal(tt, test.request.url, req.URL.String())
+				if req.Body != nil {
+					body, _ := ioutil.ReadAll(req.Body)
+					assert.Equal(tt, test.request.body, string(body))
+				}
+				return &http.Response{StatusCode: test.response.code, Body: ioutil.NopCloser(bytes.NewBufferString(test.response.body))}, nil
+			}}
+
+			result, err := test.call()
+			if test.err != "" {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.err, err.Error())
+				}
+				return
+			}
+			assert.Nil(tt, err)
+			assert.Equal(tt, test.result, result)
+		})
+	}
+}
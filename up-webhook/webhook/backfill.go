@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/codingric/moneyman/pkg/tracing"
+	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/codingric/moneyman/up-webhook/up"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Backfill posts the Up transactions of accounts, or of every account when
+// none are given, created since that the backend is missing or holds with a
+// stale status, e.g. after webhook events were lost. Every profile is
+// backfilled unless one is named. It returns the number of transactions
+// posted.
+func Backfill(ctx context.Context, since time.Time, profile string, accounts []string) (int, error) {
+	ctx, span := tracing.NewSpan("Backfill", ctx)
+	defer span.End()
+
+	profiles, err := SelectProfiles(profile)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to select profiles")
+		return 0, err
+	}
+	// Account ids belong to a single customer
+	if len(accounts) > 0 && len(profiles) > 1 {
+		return 0, errors.New("accounts require a profile when several are configured")
+	}
+
+	posted, failed := 0, 0
+	for _, p := range profiles {
+		ctx := p.Context(ctx)
+		accounts := accounts
+		if len(accounts) == 0 {
+			if accounts, err = up.ListAccounts(ctx); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "Failed to list accounts")
+				return posted, err
+			}
+		}
+
+		for _, account := range accounts {
+			transactions, err := up.ListTransactions(ctx, account, since)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "Failed to list transactions")
+				return posted, err
+			}
+
+			for _, resource := range transactions {
+				status, found, err := backend.Lookup(ctx, resource.Id)
+				if err != nil {
+					log.Error().Err(err).Str("id", resource.Id).Msg("Failed to look up backend Transaction")
+					failed++
+					continue
+				}
+				if found && status == string(resource.Attributes.Status) {
+					continue
+				}
+
+				b := ToBackend(up.UpTransaction{Data: resource})
+				b.Account = p.Account(b.Account)
+				if err := b.Post(ctx); err != nil {
+					log.Error().Err(err).Str("id", resource.Id).Msg("Failed to save backend Transaction")
+					failed++
+					continue
+				}
+				log.Info().Str("id", resource.Id).Str("profile", p.Name).Msg("Backfilled transaction")
+				posted++
+			}
+		}
+	}
+
+	span.SetAttributes(attribute.Int("posted", posted), attribute.Int("failed", failed))
+	if failed > 0 {
+		span.SetStatus(codes.Error, "Failed to backfill transactions")
+		return posted, fmt.Errorf("%d transactions failed to backfill", failed)
+	}
+	return posted, nil
+}
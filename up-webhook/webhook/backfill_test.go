@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/codingric/moneyman/up-webhook/up"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeUp serves Up API responses by path
+type fakeUp struct {
+	pages map[string]string
+}
+
+func (f *fakeUp) Do(req *http.Request) (*http.Response, error) {
+	body, ok := f.pages[req.URL.Path]
+	if !ok {
+		return nil, errors.New("unexpected request " + req.URL.String())
+	}
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+func Test_Backfill(t *testing.T) {
+	since := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeUp{pages: map[string]string{
+		"/api/v1/accounts": `{"data":[{"type":"accounts","id":"acc1"},{"type":"accounts","id":"acc2"}],"links":{"next":null}}`,
+		"/api/v1/accounts/acc1/transactions": `{"data":[` +
+			`{"id":"stored","attributes":{"status":"SETTLED","description":"Coles","amount":{"currencyCode":"AUD","value":"-10.00"}},"relationships":{"account":{"data":{"id":"acc1"}}}},` +
+			`{"id":"missing","attributes":{"status":"SETTLED","description":"Shell","amount":{"currencyCode":"AUD","value":"-62.35"},"createdAt":"2023-01-02T09:00:00+11:00"},"relationships":{"account":{"data":{"id":"acc1"}}}},` +
+			`{"id":"held","attributes":{"status":"SETTLED","description":"Woolworths","amount":{"currencyCode":"AUD","value":"-12.50"}},"relationships":{"account":{"data":{"id":"acc1"}}}}` +
+			`],"links":{"next":null}}`,
+		"/api/v1/accounts/acc2/transactions": `{"data":[` +
+			`{"id":"lookup_err","attributes":{"status":"HELD","description":"Uber","amount":{"currencyCode":"AUD","value":"-20.00"}},"relationships":{"account":{"data":{"id":"acc2"}}}}` +
+			`],"links":{"next":null}}`,
+	}}
+
+	profiles := []map[string]interface{}{
+		{"name": "alice", "bearer": "a", "secret_key": "sa", "accounts": map[string]string{"acc1": "joint"}},
+		{"name": "bob", "bearer": "b", "secret_key": "sb"},
+	}
+
+	type setup struct {
+		profiles []map[string]interface{}
+		profile  string
+		accounts []string
+		post_err bool
+	}
+	type expected struct {
+		posted   int
+		err      string
+		created  []string
+		accounts []string
+	}
+	tests := []struct {
+		name     string
+		setup    setup
+		expected expected
+	}{
+		{
+			"AllAccounts",
+			setup{},
+			expected{posted: 2, err: "1 transactions failed to backfill", created: []string{"held", "missing"}},
+		},
+		{
+			"Account",
+			setup{accounts: []string{"acc1"}},
+			expected{posted: 2, created: []string{"held", "missing"}},
+		},
+		{
+			"PostFailure",
+			setup{accounts: []string{"acc1"}, post_err: true},
+			expected{posted: 0, err: "2 transactions failed to backfill"},
+		},
+		{
+			"UnknownAccount",
+			setup{accounts: []string{"acc3"}},
+			expected{err: "Failure while requesting https://api.up.com.au/api/v1/accounts/acc3/transactions?filter%5Bsince%5D=2023-01-01T00%3A00%3A00Z&page%5Bsize%5D=100"},
+		},
+		{
+			"Profile",
+			setup{profiles: profiles, profile: "alice", accounts: []string{"acc1"}},
+			expected{posted: 2, created: []string{"held", "missing"}, accounts: []string{"joint", "joint"}},
+		},
+		{
+			"AllProfiles",
+			setup{profiles: profiles},
+			expected{posted: 4, err: "2 transactions failed to backfill", created: []string{"held", "held", "missing", "missing"}, accounts: []string{"acc1", "acc1", "joint", "joint"}},
+		},
+		{
+			"UnknownProfile",
+			setup{profiles: profiles, profile: "carol"},
+			expected{err: "unknown profile carol"},
+		},
+		{
+			"AccountsWithoutProfile",
+			setup{profiles: profiles, accounts: []string{"acc1"}},
+			expected{err: "accounts require a profile when several are configured"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			saved := up.UpService
+			up.UpService = fake
+			defer func() { up.UpService = saved }()
+			viper.Set("profiles", test.setup.profiles)
+			defer viper.Set("profiles", nil)
+
+			monkey.Patch(backend.Lookup, func(ctx context.Context, id string) (string, bool, error) {
+				switch id {
+				case "stored":
+					return "SETTLED", true, nil
+				case "held":
+					return "HELD", true, nil
+				case "lookup_err":
+					return "", false, errors.New("mock error")
+				}
+				return "", false, nil
+			})
+			created, accounts := []string{}, []string{}
+			var be *backend.BackendTransaction
+			monkey.PatchInstanceMethod(reflect.TypeOf(be), "Post", func(b *backend.BackendTransaction, c context.Context) error {
+				if test.setup.post_err {
+					return errors.New("mock error")
+				}
+				assert.Equal(tt, backend.Source, b.Source)
+				created = append(created, b.ExternalID)
+				accounts = append(accounts, b.Account)
+				return nil
+			})
+			defer monkey.UnpatchAll()
+
+			posted, err := Backfill(context.Background(), since, test.setup.profile, test.setup.accounts)
+
+			assert.Equal(tt, test.expected.posted, posted)
+			if test.expected.err != "" {
+				if assert.NotNil(tt, err) {
+					assert.Equal(tt, test.expected.err, err.Error())
+				}
+			} else {
+				assert.Nil(tt, err)
+			}
+			sort.Strings(created)
+			if test.expected.created != nil {
+				assert.Equal(tt, test.expected.created, created)
+			}
+			sort.Strings(accounts)
+			if test.expected.accounts != nil {
+				assert.Equal(tt, test.expected.accounts, accounts)
+			}
+		})
+	}
+}
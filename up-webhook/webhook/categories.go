@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/codingric/moneyman/pkg/tracing"
+	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/codingric/moneyman/up-webhook/up"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SyncCategories mirrors Up's category tree into the backend, so the
+// categories Up gives transactions can be rolled up to their parents. Up's
+// categories are the same for every customer, the first profile's token is
+// used. It returns the number of categories mirrored.
+func SyncCategories(ctx context.Context) (int, error) {
+	ctx, span := tracing.NewSpan("SyncCategories", ctx)
+	defer span.End()
+
+	profiles, err := Profiles()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to select profiles")
+		return 0, err
+	}
+	categories, err := up.ListCategories(profiles[0].Context(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list categories")
+		return 0, err
+	}
+
+	mirrored := make([]backend.Category, 0, len(categories))
+	for _, c := range categories {
+		mirrored = append(mirrored, backend.Category{
+			ID:     c.Id,
+			Name:   c.Attributes.Name,
+			Parent: c.Relationships.Parent.Data.Id,
+			Source: backend.Source,
+		})
+	}
+	if err := backend.PostCategories(ctx, mirrored); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to save categories")
+		return 0, err
+	}
+	log.Info().Int("count", len(mirrored)).Msg("Mirrored Up categories")
+	return len(mirrored), nil
+}
@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"bou.ke/monkey"
+	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/codingric/moneyman/up-webhook/up"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SyncCategories(t *testing.T) {
+	fake := &fakeUp{pages: map[string]string{
+		"/api/v1/categories": `{"data":[` +
+			`{"type":"categories","id":"good-life","attributes":{"name":"Good Life"},"relationships":{"parent":{"data":null}}},` +
+			`{"type":"categories","id":"takeaway","attributes":{"name":"Takeaway"},"relationships":{"parent":{"data":{"type":"categories","id":"good-life"}}}}` +
+			`]}`,
+	}}
+
+	tests := []struct {
+		name     string
+		pages    map[string]string
+		post_err bool
+		count    int
+		err      string
+	}{
+		{"OK", fake.pages, false, 2, ""},
+		{"UpFailure", map[string]string{}, false, 0, "Failure while requesting https://api.up.com.au/api/v1/categories"},
+		{"BackendFailure", fake.pages, true, 0, "mock error"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			saved := up.UpService
+			up.UpService = &fakeUp{pages: test.pages}
+			defer func() { up.UpService = saved }()
+
+			var posted []backend.Category
+			monkey.Patch(backend.PostCategories, func(ctx context.Context, categories []backend.Category) error {
+				if test.post_err {
+					return errors.New("mock error")
+				}
+				posted = categories
+				return nil
+			})
+			defer monkey.UnpatchAll()
+
+			count, err := SyncCategories(context.Background())
+
+			assert.Equal(tt, test.count, count)
+			if test.err != "" {
+				assert.EqualError(tt, err, test.err)
+				return
+			}
+			assert.Nil(tt, err)
+			assert.Equal(tt, []backend.Category{
+				{ID: "good-life", Name: "Good Life", Source: "up"},
+				{ID: "takeaway", Name: "Takeaway", Parent: "good-life", Source: "up"},
+			}, posted)
+		})
+	}
+}
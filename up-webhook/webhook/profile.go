@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/codingric/moneyman/up-webhook/up"
+	"github.com/spf13/viper"
+)
+
+// Profile holds the credentials of one Up customer. A household lists one
+// per customer under profiles, otherwise the top level bearer, secret_key,
+// webhook_id and accounts form a single unnamed profile.
+type Profile struct {
+	Name      string `mapstructure:"name"`
+	Bearer    string `mapstructure:"bearer"`
+	SecretKey string `mapstructure:"secret_key"`
+	WebhookID string `mapstructure:"webhook_id"`
+	// Backend account by Up account id, unmapped accounts keep their id
+	Accounts map[string]string `mapstructure:"accounts"`
+}
+
+// Profiles configured, in the order they are tried
+func Profiles() ([]Profile, error) {
+	var profiles []Profile
+	if err := viper.UnmarshalKey("profiles", &profiles); err != nil {
+		return nil, fmt.Errorf("invalid profiles: %w", err)
+	}
+	if len(profiles) == 0 {
+		return []Profile{{
+			Bearer:    viper.GetString("bearer"),
+			SecretKey: viper.GetString("secret_key"),
+			WebhookID: viper.GetString("webhook_id"),
+			Accounts:  viper.GetStringMapString("accounts"),
+		}}, nil
+	}
+
+	names := map[string]bool{}
+	for _, p := range profiles {
+		if p.Name == "" {
+			return nil, errors.New("profile missing name")
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate profile %s", p.Name)
+		}
+		names[p.Name] = true
+	}
+	return profiles, nil
+}
+
+// SelectProfiles returns the profile called name, or every profile when name
+// is empty
+func SelectProfiles(name string) ([]Profile, error) {
+	profiles, err := Profiles()
+	if err != nil || name == "" {
+		return profiles, err
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return []Profile{p}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown profile %s", name)
+}
+
+// Context for requests to Up with the profile's bearer
+func (p Profile) Context(ctx context.Context) context.Context {
+	return up.WithBearer(ctx, p.Bearer)
+}
+
+// Account is the backend account of the Up account id
+func (p Profile) Account(id string) string {
+	if account, ok := p.Accounts[id]; ok && account != "" {
+		return account
+	}
+	return id
+}
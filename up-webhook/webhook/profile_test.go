@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(body, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
+
+func Test_Profiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles interface{}
+		expected []Profile
+		err      string
+	}{
+		{
+			"Default",
+			nil,
+			[]Profile{{Bearer: "token", SecretKey: "secret", WebhookID: "hook", Accounts: map[string]string{"acc1": "joint"}}},
+			"",
+		},
+		{
+			"Profiles",
+			[]map[string]interface{}{
+				{"name": "alice", "bearer": "a", "secret_key": "sa", "accounts": map[string]string{"acc1": "joint"}},
+				{"name": "bob", "bearer": "b", "secret_key": "sb", "webhook_id": "hb"},
+			},
+			[]Profile{
+				{Name: "alice", Bearer: "a", SecretKey: "sa", Accounts: map[string]string{"acc1": "joint"}},
+				{Name: "bob", Bearer: "b", SecretKey: "sb", WebhookID: "hb"},
+			},
+			"",
+		},
+		{
+			"MissingName",
+			[]map[string]interface{}{{"bearer": "a"}},
+			nil,
+			"profile missing name",
+		},
+		{
+			"Duplicate",
+			[]map[string]interface{}{{"name": "alice"}, {"name": "alice"}},
+			nil,
+			"duplicate profile alice",
+		},
+		{
+			"Invalid",
+			"alice",
+			nil,
+			"invalid profiles: 1 error(s) decoding:\n\n* '[0]' expected a map, got 'string'",
+		},
+	}
+	viper.Set("bearer", "token")
+	viper.Set("secret_key", "secret")
+	viper.Set("webhook_id", "hook")
+	viper.Set("accounts", map[string]string{"acc1": "joint"})
+	defer func() {
+		for _, key := range []string{"bearer", "secret_key", "webhook_id", "accounts", "profiles"} {
+			viper.Set(key, nil)
+		}
+	}()
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			viper.Set("profiles", test.profiles)
+
+			profiles, err := Profiles()
+
+			if test.err != "" {
+				assert.EqualError(tt, err, test.err)
+				return
+			}
+			assert.Nil(tt, err)
+			assert.Equal(tt, test.expected, profiles)
+		})
+	}
+}
+
+func Test_SelectProfiles(t *testing.T) {
+	viper.Set("profiles", []map[string]interface{}{{"name": "alice"}, {"name": "bob"}})
+	defer viper.Set("profiles", nil)
+
+	profiles, err := SelectProfiles("")
+	assert.Nil(t, err)
+	assert.Len(t, profiles, 2)
+
+	profiles, err = SelectProfiles("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, []Profile{{Name: "bob"}}, profiles)
+
+	_, err = SelectProfiles("carol")
+	assert.EqualError(t, err, "unknown profile carol")
+}
+
+func Test_ProfileAccount(t *testing.T) {
+	p := Profile{Accounts: map[string]string{"acc1": "joint", "acc2": ""}}
+	assert.Equal(t, "joint", p.Account("acc1"))
+	assert.Equal(t, "acc2", p.Account("acc2"))
+	assert.Equal(t, "acc3", p.Account("acc3"))
+}
+
+func Test_matchProfile(t *testing.T) {
+	body := `{"data":{}}`
+	profiles := []map[string]interface{}{
+		{"name": "alice", "secret_key": "sa"},
+		{"name": "nosecret"},
+		{"name": "bob", "secret_key": "sb"},
+	}
+
+	type expected struct {
+		profile string
+		valid   bool
+		err     string
+	}
+	tests := []struct {
+		name     string
+		profiles []map[string]interface{}
+		path     string
+		sig      []byte
+		expected expected
+	}{
+		{
+			"TriesEachSecret",
+			profiles,
+			"/",
+			sign(body, "sb"),
+			expected{profile: "bob", valid: true},
+		},
+		{
+			"PathSegment",
+			profiles,
+			"/up/alice",
+			sign(body, "sa"),
+			expected{profile: "alice", valid: true},
+		},
+		{
+			"PathSegmentOtherSecret",
+			profiles,
+			"/up/alice",
+			sign(body, "sb"),
+			expected{valid: false},
+		},
+		{
+			"UnknownSegment",
+			profiles,
+			"/up/carol",
+			sign(body, "sa"),
+			expected{profile: "alice", valid: true},
+		},
+		{
+			"NoMatch",
+			profiles,
+			"/",
+			sign(body, "other"),
+			expected{valid: false},
+		},
+		{
+			"PathSegmentWithoutSecret",
+			profiles,
+			"/nosecret",
+			sign(body, "sa"),
+			expected{err: "missing secret_key"},
+		},
+		{
+			"NoSecrets",
+			[]map[string]interface{}{{"name": "alice"}, {"name": "bob"}},
+			"/",
+			sign(body, "sa"),
+			expected{err: "missing secret_key"},
+		},
+	}
+	defer viper.Set("profiles", nil)
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			viper.Set("profiles", test.profiles)
+
+			profile, valid, err := matchProfile(test.path, []byte(body), test.sig)
+
+			if test.expected.err != "" {
+				assert.EqualError(tt, err, test.expected.err)
+				return
+			}
+			assert.Nil(tt, err)
+			assert.Equal(tt, test.expected.valid, valid)
+			assert.Equal(tt, test.expected.profile, profile.Name)
+		})
+	}
+}
@@ -9,17 +9,62 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"path"
+	"time"
 
 	"github.com/codingric/moneyman/pkg/tracing"
 	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/codingric/moneyman/up-webhook/outbox"
 	"github.com/codingric/moneyman/up-webhook/up"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 )
 
+// Queue of backend transactions still to be delivered, events are only
+// acknowledged once their transaction is stored in it
+var Queue *outbox.Outbox
+
+// Clock events are checked against, replaced in tests
+var now = time.Now
+
 func RunWebhook(ctx context.Context) error {
+	queue, err := outbox.Open(viper.GetString("outbox.path"))
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+	if viper.IsSet("outbox.max_attempts") {
+		queue.MaxAttempts = viper.GetInt("outbox.max_attempts")
+	}
+	if viper.IsSet("outbox.base_delay") {
+		queue.BaseDelay = viper.GetDuration("outbox.base_delay")
+	}
+	if viper.IsSet("outbox.max_delay") {
+		queue.MaxDelay = viper.GetDuration("outbox.max_delay")
+	}
+	// Older events are rejected, so there is no need to remember them
+	if age := viper.GetDuration("max_event_age"); age > 0 {
+		queue.EventRetention = age
+	}
+	Queue = queue
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go Queue.Run(ctx)
+
+	if port := viper.GetString("admin_port"); port != "" {
+		admin := &http.Server{Addr: "127.0.0.1:" + port, Handler: Queue.Admin()}
+		go func() {
+			if err := admin.ListenAndServe(); err != nil {
+				log.Error().Err(err).Msg("Admin server failed")
+			}
+		}()
+		log.Info().Msg("Admin listening on port " + port)
+	}
+
 	http.Handle("/", otelhttp.NewHandler(http.HandlerFunc(WebhookHandler), "incoming.up-webhook"))
 	log.Info().Msg("Webhook listening on port " + viper.GetString("port"))
 	return http.ListenAndServe("0.0.0.0:"+viper.GetString("port"), nil)
@@ -45,11 +90,11 @@ func WebhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Trace().Msgf("Body:\n\n%s", string(body))
 
-	valid_sig, err := validateSignature(body, sig)
+	profile, valid_sig, err := matchProfile(r.URL.Path, body, sig)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failure validating signature")
-		log.Error().Msg("Failure validating signature")
+		log.Error().Err(err).Msg("Failure validating signature")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -61,6 +106,8 @@ func WebhookHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	span.SetAttributes(attribute.String("profile", profile.Name))
+	ctx = profile.Context(ctx)
 
 	var event up.UpWebhookEvent
 	err = json.Unmarshal(body, &event)
@@ -74,10 +121,43 @@ func WebhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug().Msgf("WebhookEvent: %v", event)
 
-	if event.Data.Attributes.EventType == "TRANSACTION_CREATED" {
+	if event.Data.Attributes.EventType == "PING" {
+		log.Info().Str("event", event.Data.Id).Msg("Received PING")
+		w.Write([]byte("OK\n"))
+		return
+	}
+
+	// A captured payload keeps its valid signature, so old events are
+	// rejected and ones already processed are only acknowledged
+	created := event.Data.Attributes.CreatedAt
+	if age := viper.GetDuration("max_event_age"); age > 0 && (now().Sub(created) > age || created.Sub(now()) > age) {
+		span.SetStatus(codes.Error, "Stale WebhookEvent")
+		log.Warn().Str("event", event.Data.Id).Time("created", created).Msg("Rejected stale WebhookEvent")
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	processed, err := Queue.Processed(event.Data.Id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check processed events")
+		log.Error().Err(err).Msg("Failed to check processed events")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if processed {
+		log.Info().Str("event", event.Data.Id).Msg("Acknowledged duplicate WebhookEvent")
+		w.Write([]byte("OK\n"))
+		return
+	}
 
+	id := event.Data.Relationships.Transaction.Data.Id
+	switch event.Data.Attributes.EventType {
+	case "TRANSACTION_CREATED", "TRANSACTION_SETTLED":
+		// Settled transactions are posted again, the backend updates the held
+		// row with the same Up id in place. Up gives up retrying eventually so
+		// the transaction is queued rather than posted straight away.
 		var trans up.UpTransaction
-		if err := trans.Get(event.Data.Relationships.Transaction.Data.Id, ctx); err != nil {
+		if err := trans.Get(id, ctx); err != nil {
 			span.RecordError(err)
 			span.SetStatus(codes.Error, "Failed to get Transaction")
 			log.Error().Msgf("Failed to get Transaction: %v", err)
@@ -85,29 +165,119 @@ func WebhookHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		backend := backend.BackendTransaction{
-			Created:     trans.Data.Attributes.CreatedAt,
-			Amount:      trans.Data.Attributes.Amount.Value,
-			Description: trans.Data.Attributes.Description,
-			Account:     trans.Data.Relationships.Account.Data.Id,
+		b := ToBackend(trans)
+		b.Account = profile.Account(b.Account)
+		if _, err := Queue.Enqueue(b); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to queue backend transaction")
+			log.Error().Msgf("Failed to queue backend Transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
-		if err := backend.Post(ctx); err != nil {
+	case "TRANSACTION_DELETED":
+		// Deleted transactions can no longer be fetched from Up. The delete
+		// is queued behind any create of the transaction still waiting to be
+		// delivered, rather than overtaking it.
+		if _, err := Queue.EnqueueDelete(id); err != nil {
 			span.RecordError(err)
-			span.SetStatus(codes.Error, "Failed to save backend transaction")
-			log.Error().Msgf("Failed to save backend Transaction: %v", err)
+			span.SetStatus(codes.Error, "Failed to queue backend delete")
+			log.Error().Msgf("Failed to queue backend delete: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 	}
 
+	if err := Queue.MarkProcessed(event.Data.Id, created); err != nil {
+		// The event was handled, a redelivery is harmless
+		span.RecordError(err)
+		log.Error().Err(err).Msg("Failed to mark WebhookEvent processed")
+	}
 	w.Write([]byte("OK\n"))
 }
 
-func validateSignature(body []byte, signature []byte) (bool, error) {
-	if viper.GetString("secret_key") == "" {
+// ToBackend converts an Up transaction into the backend's representation
+func ToBackend(trans up.UpTransaction) backend.BackendTransaction {
+	attrs, rels := trans.Data.Attributes, trans.Data.Relationships
+	b := backend.BackendTransaction{
+		Created:              attrs.CreatedAt,
+		Amount:               attrs.Amount.Value,
+		Description:          attrs.Description,
+		Account:              rels.Account.Data.Id,
+		Currency:             attrs.Amount.CurrencyCode,
+		Source:               backend.Source,
+		ExternalID:           trans.Data.Id,
+		Status:               string(attrs.Status),
+		RawText:              attrs.RawText,
+		Message:              attrs.Message,
+		SourceCategory:       rels.Category.Data.Id,
+		SourceParentCategory: rels.ParentCategory.Data.Id,
+	}
+	for _, tag := range rels.Tags.Data {
+		b.Tags = append(b.Tags, tag.Id)
+	}
+	if foreign := attrs.ForeignAmount; foreign != nil {
+		b.ForeignAmount = foreign.Value
+		b.ForeignCurrency = foreign.CurrencyCode
+	}
+	if !attrs.SettledAt.IsZero() {
+		settled := attrs.SettledAt
+		b.SettledAt = &settled
+	}
+	if hold := attrs.HoldInfo; hold != nil {
+		b.HoldAmount = hold.Amount.Value
+	}
+	if roundUp := attrs.RoundUp; roundUp != nil {
+		b.RoundUp = roundUp.Amount.Value
+		if roundUp.BoostPortion != nil {
+			b.RoundUpBoost = roundUp.BoostPortion.Value
+		}
+	}
+	if cashback := attrs.Cashback; cashback != nil {
+		b.Cashback = cashback.Amount.Value
+		b.CashbackDescription = cashback.Description
+	}
+	return b
+}
+
+// matchProfile finds the profile whose secret signed body. When the last
+// segment of the path names a profile, e.g. /alice, only it is tried.
+func matchProfile(urlPath string, body []byte, signature []byte) (Profile, bool, error) {
+	profiles, err := Profiles()
+	if err != nil {
+		return Profile{}, false, err
+	}
+	for _, p := range profiles {
+		if p.Name != "" && p.Name == path.Base(urlPath) {
+			profiles = []Profile{p}
+			break
+		}
+	}
+	checked := 0
+	for _, p := range profiles {
+		if p.SecretKey == "" {
+			log.Warn().Str("profile", p.Name).Msg("Profile missing secret_key")
+			continue
+		}
+		checked++
+		valid, err := validateSignature(body, signature, p.SecretKey)
+		if err != nil {
+			return Profile{}, false, err
+		}
+		if valid {
+			return p, true, nil
+		}
+	}
+	if checked == 0 {
+		return Profile{}, false, errors.New("missing secret_key")
+	}
+	return Profile{}, false, nil
+}
+
+func validateSignature(body []byte, signature []byte, secret string) (bool, error) {
+	if secret == "" {
 		return false, errors.New("missing secret_key")
 	}
-	mac := hmac.New(sha256.New, []byte(viper.GetString("secret_key")))
+	mac := hmac.New(sha256.New, []byte(secret))
 	mac.Write(body)
 	generated := mac.Sum(nil)
 	log.Debug().Msgf("Signatures: received=%x, generated=%x", signature, generated)
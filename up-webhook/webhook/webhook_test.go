@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -11,11 +12,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
 	"github.com/codingric/moneyman/up-webhook/backend"
+	"github.com/codingric/moneyman/up-webhook/outbox"
 	"github.com/codingric/moneyman/up-webhook/up"
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
@@ -63,10 +67,9 @@ func Test_validateSignature(t *testing.T) {
 			// setup
 			body := []byte(test.inputs.body)
 			sig, _ := hex.DecodeString(test.inputs.sig)
-			viper.Set("secret_key", test.inputs.secret)
 
 			// run test
-			result, err := validateSignature(body, sig)
+			result, err := validateSignature(body, sig, test.inputs.secret)
 
 			//validate results
 			assert.Equal(tt, test.expected.result, result)
@@ -81,6 +84,25 @@ func Test_validateSignature(t *testing.T) {
 }
 
 func Test_WebhookHandler(t *testing.T) {
+	created := time.Date(2022, 2, 27, 8, 28, 54, 0, time.Local)
+	saved := now
+	defer func() { now = saved }()
+	now = func() time.Time { return created.Add(time.Minute) }
+	viper.Set("max_event_age", "1h")
+	viper.Set("secret_key", "secretkey")
+	settledAt := created.Add(36 * time.Hour)
+	settled := up.UpTransaction{Data: up.TransactionResource{
+		Id: "mock_ok",
+		Attributes: up.TransactionAttributes{
+			Status:      "SETTLED",
+			Description: "Shell",
+			Amount:      up.Amount{CurrencyCode: "AUD", Value: "-62.35", ValueInBaseUnits: -6235},
+			CreatedAt:   created,
+			SettledAt:   settledAt,
+		},
+		Relationships: up.TransactionRelationships{Account: up.UpTypes{Data: up.UpData{Id: "1234567890"}}},
+	}}
+
 	type setup struct {
 		body         string
 		validsig     bool
@@ -88,13 +110,16 @@ func Test_WebhookHandler(t *testing.T) {
 		ioutilerr    bool
 		trans_err    bool
 		trans_result *up.UpTransaction
-		backend_err  bool
+		queue_err    bool
+		processed    bool
 	}
 	type expected struct {
 		trans_id    string
 		backend_obj *backend.BackendTransaction
+		deleted_id  string
 		resp_body   string
 		resp_code   int
+		processed   bool
 	}
 	tests := []struct {
 		name     string
@@ -116,6 +141,26 @@ func Test_WebhookHandler(t *testing.T) {
 			setup{ioutilerr: true},
 			expected{resp_body: "Bad Request\n", resp_code: 400},
 		},
+		{
+			"Ping",
+			setup{validsig: true, trans_err: true, body: `{"data":{"type":"webhook-events","id":"ping-id","attributes":{"eventType":"PING","createdAt":"2020-01-01T00:00:00+11:00"},"relationships":{"webhook":{"data":{"type":"webhooks","id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad"}}}}}`},
+			expected{resp_body: "OK\n", resp_code: 200},
+		},
+		{
+			"Stale",
+			setup{validsig: true, trans_err: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T06:28:54+11:00","eventType":"TRANSACTION_CREATED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"}},"transaction":{"data":{"id":"mock_ok","type":"transactions"}}}}}`},
+			expected{resp_body: "Bad Request\n", resp_code: 400},
+		},
+		{
+			"FromTheFuture",
+			setup{validsig: true, trans_err: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T10:28:54+11:00","eventType":"TRANSACTION_CREATED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"}},"transaction":{"data":{"id":"mock_ok","type":"transactions"}}}}}`},
+			expected{resp_body: "Bad Request\n", resp_code: 400},
+		},
+		{
+			"Duplicate",
+			setup{validsig: true, trans_err: true, processed: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T08:28:54+11:00","eventType":"TRANSACTION_CREATED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"}},"transaction":{"data":{"id":"mock_ok","type":"transactions"}}}}}`},
+			expected{resp_body: "OK\n", resp_code: 200, processed: true},
+		},
 		{
 			"InvalidBody",
 			setup{validsig: true, body: `{:"{/"';]`},
@@ -127,14 +172,39 @@ func Test_WebhookHandler(t *testing.T) {
 			expected{resp_body: "Internal Server Error\n", resp_code: 500, trans_id: "mock_fail"},
 		},
 		{
-			"BackendFailure",
-			setup{validsig: true, backend_err: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T08:28:54+11:00","eventType":"TRANSACTION_CREATED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"},"links":{"related":"https://api.up.com.au/api/v1/webhooks/f7910b7e-23a4-4d37-bb0b-2a5975edc9ad"}},"transaction":{"data":{"id":"mock_fail","type":"transactions"},"links":{"related":"https://api.up.com.au/api/v1/transactions/9fa021d6-e26a-400a-b2a1-2daa4cc71ead"}}}}}`},
+			"QueueFailure",
+			setup{validsig: true, queue_err: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T08:28:54+11:00","eventType":"TRANSACTION_CREATED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"},"links":{"related":"https://api.up.com.au/api/v1/webhooks/f7910b7e-23a4-4d37-bb0b-2a5975edc9ad"}},"transaction":{"data":{"id":"mock_fail","type":"transactions"},"links":{"related":"https://api.up.com.au/api/v1/transactions/9fa021d6-e26a-400a-b2a1-2daa4cc71ead"}}}}}`},
 			expected{resp_body: "Internal Server Error\n", resp_code: 500, trans_id: "mock_fail"},
 		},
 		{
 			"OK",
 			setup{validsig: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T08:28:54+11:00","eventType":"TRANSACTION_CREATED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"},"links":{"related":"https://api.up.com.au/api/v1/webhooks/f7910b7e-23a4-4d37-bb0b-2a5975edc9ad"}},"transaction":{"data":{"id":"mock_ok","type":"transactions"},"links":{"related":"https://api.up.com.au/api/v1/transactions/9fa021d6-e26a-400a-b2a1-2daa4cc71ead"}}}}}`},
-			expected{resp_body: "OK\n", resp_code: 200, trans_id: "mock_id"},
+			expected{resp_body: "OK\n", resp_code: 200, trans_id: "mock_id", processed: true},
+		},
+		{
+			"Settled",
+			setup{validsig: true, trans_result: &settled, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T08:28:54+11:00","eventType":"TRANSACTION_SETTLED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"}},"transaction":{"data":{"id":"mock_ok","type":"transactions"}}}}}`},
+			expected{resp_body: "OK\n", resp_code: 200, trans_id: "mock_ok", processed: true, backend_obj: &backend.BackendTransaction{
+				Created:     created,
+				Amount:      "-62.35",
+				Description: "Shell",
+				Account:     "1234567890",
+				Currency:    "AUD",
+				Source:      "up",
+				ExternalID:  "mock_ok",
+				Status:      "SETTLED",
+				SettledAt:   &settledAt,
+			}},
+		},
+		{
+			"Deleted",
+			setup{validsig: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T08:28:54+11:00","eventType":"TRANSACTION_DELETED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"}},"transaction":{"data":{"id":"mock_ok","type":"transactions"}}}}}`},
+			expected{resp_body: "OK\n", resp_code: 200, deleted_id: "mock_ok", processed: true},
+		},
+		{
+			"DeleteFailure",
+			setup{validsig: true, queue_err: true, body: `{"data":{"id":"133ee2bf-0b09-4bb6-946f-fc36e8bb0936","type":"webhook-events","attributes":{"createdAt":"2022-02-27T08:28:54+11:00","eventType":"TRANSACTION_DELETED"},"relationships":{"webhook":{"data":{"id":"f7910b7e-23a4-4d37-bb0b-2a5975edc9ad","type":"webhooks"}},"transaction":{"data":{"id":"mock_ok","type":"transactions"}}}}}`},
+			expected{resp_body: "Internal Server Error\n", resp_code: 500},
 		},
 	}
 	for _, test := range tests {
@@ -142,7 +212,7 @@ func Test_WebhookHandler(t *testing.T) {
 			// setup
 			request, _ := http.NewRequest("POST", "/create", bytes.NewBuffer([]byte(test.setup.body)))
 			response := httptest.NewRecorder()
-			monkey.Patch(validateSignature, func([]byte, []byte) (v bool, e error) {
+			monkey.Patch(validateSignature, func([]byte, []byte, string) (v bool, e error) {
 				if test.setup.validsigerr {
 					e = errors.New("mock error")
 				}
@@ -160,21 +230,23 @@ func Test_WebhookHandler(t *testing.T) {
 					return fmt.Errorf("Get(t=%v, id=%s)", t, id)
 				}
 				if test.setup.trans_result != nil {
-					t = test.setup.trans_result
+					*t = *test.setup.trans_result
 				}
 				return nil
 			})
 
-			var be *backend.BackendTransaction
-			monkey.PatchInstanceMethod(reflect.TypeOf(be), "Post", func(b *backend.BackendTransaction, c context.Context) error {
-				if test.expected.backend_obj != nil {
-					assert.Equal(tt, *test.expected.backend_obj, *b)
-				}
-				if test.setup.backend_err {
-					return fmt.Errorf("Post(%v)", b)
-				}
-				return nil
-			})
+			queue, err := outbox.Open(filepath.Join(tt.TempDir(), "outbox.db"))
+			if err != nil {
+				tt.Fatal(err)
+			}
+			defer queue.Close()
+			if test.setup.processed {
+				queue.MarkProcessed("133ee2bf-0b09-4bb6-946f-fc36e8bb0936", created)
+			}
+			if test.setup.queue_err {
+				queue.Close()
+			}
+			Queue = queue
 
 			// run test
 			WebhookHandler(response, request)
@@ -185,6 +257,23 @@ func Test_WebhookHandler(t *testing.T) {
 			//validate results
 			assert.Equal(tt, test.expected.resp_code, response.Code)
 			assert.Equal(tt, test.expected.resp_body, string(resp_body))
+			if !test.setup.queue_err {
+				processed, _ := queue.Processed("133ee2bf-0b09-4bb6-946f-fc36e8bb0936")
+				assert.Equal(tt, test.expected.processed, processed)
+			}
+			if test.expected.backend_obj != nil {
+				pending, _ := queue.Pending()
+				if assert.Len(tt, pending, 1) {
+					assert.Equal(tt, *test.expected.backend_obj, pending[0].Transaction)
+				}
+			}
+			if test.expected.deleted_id != "" {
+				pending, _ := queue.Pending()
+				if assert.Len(tt, pending, 1) {
+					assert.True(tt, pending[0].Delete)
+					assert.Equal(tt, test.expected.deleted_id, pending[0].Transaction.ExternalID)
+				}
+			}
 		})
 	}
 }
@@ -217,6 +306,7 @@ func Test_RunWebhook(t *testing.T) {
 		t.Run(test.name, func(tt *testing.T) {
 			monkey.Patch(http.Handle, func(pattern string, handler http.Handler) {})
 			viper.Set("port", test.inputs.port)
+			viper.Set("outbox.path", filepath.Join(tt.TempDir(), "outbox.db"))
 			monkey.Patch(http.ListenAndServe, func(addr string, handler http.Handler) error {
 				assert.Equal(tt, test.expected.addr, addr)
 				if test.inputs.err {
@@ -237,3 +327,55 @@ func Test_RunWebhook(t *testing.T) {
 		})
 	}
 }
+
+func Test_ToBackend(t *testing.T) {
+	var trans up.UpTransaction
+	err := json.Unmarshal([]byte(`{"data":{"type":"transactions","id":"t1","attributes":{"status":"SETTLED","rawText":"CAFE 123 MELBOURNE","description":"Cafe","message":"lunch","amount":{"currencyCode":"AUD","value":"-12.00","valueInBaseUnits":-1200},"createdAt":"2023-01-02T09:00:00+11:00"},`+
+		`"relationships":{"account":{"data":{"type":"accounts","id":"acc1"}},"category":{"data":{"type":"categories","id":"restaurants-and-cafes"}},"parentCategory":{"data":{"type":"categories","id":"good-life"}},"tags":{"data":[{"type":"tags","id":"work"},{"type":"tags","id":"Holiday"}]}}}}`), &trans)
+	assert.Nil(t, err)
+
+	b := ToBackend(trans)
+
+	assert.Equal(t, "CAFE 123 MELBOURNE", b.RawText)
+	assert.Equal(t, "lunch", b.Message)
+	assert.Equal(t, "restaurants-and-cafes", b.SourceCategory)
+	assert.Equal(t, "good-life", b.SourceParentCategory)
+	assert.Equal(t, []string{"work", "Holiday"}, b.Tags)
+	assert.Equal(t, "acc1", b.Account)
+
+	// Uncategorised transactions have null relationships
+	trans = up.UpTransaction{}
+	err = json.Unmarshal([]byte(`{"data":{"id":"t2","attributes":{"amount":{"value":"1.00"}},"relationships":{"account":{"data":{"id":"acc1"}},"category":{"data":null},"parentCategory":{"data":null},"tags":{"data":[]}}}}`), &trans)
+	assert.Nil(t, err)
+	b = ToBackend(trans)
+	assert.Equal(t, "", b.SourceCategory)
+	assert.Equal(t, "", b.SourceParentCategory)
+	assert.Nil(t, b.Tags)
+}
+
+func Test_ToBackendRoundUp(t *testing.T) {
+	var trans up.UpTransaction
+	err := json.Unmarshal([]byte(`{"data":{"id":"t1","attributes":{"status":"SETTLED","description":"Cafe","amount":{"currencyCode":"AUD","value":"-4.60"},`+
+		`"holdInfo":{"amount":{"currencyCode":"AUD","value":"-4.50"},"foreignAmount":null},`+
+		`"roundUp":{"amount":{"currencyCode":"AUD","value":"-1.40"},"boostPortion":{"currencyCode":"AUD","value":"-1.00"}},`+
+		`"cashback":{"description":"Cafe cashback","amount":{"currencyCode":"AUD","value":"0.45"}}},`+
+		`"relationships":{"account":{"data":{"id":"acc1"}}}}}`), &trans)
+	assert.Nil(t, err)
+
+	b := ToBackend(trans)
+
+	assert.Equal(t, "-4.50", b.HoldAmount)
+	assert.Equal(t, "-1.40", b.RoundUp)
+	assert.Equal(t, "-1.00", b.RoundUpBoost)
+	assert.Equal(t, "0.45", b.Cashback)
+	assert.Equal(t, "Cafe cashback", b.CashbackDescription)
+
+	trans = up.UpTransaction{}
+	err = json.Unmarshal([]byte(`{"data":{"id":"t2","attributes":{"amount":{"value":"-1.00"},"holdInfo":null,"roundUp":{"amount":{"value":"-0.50"},"boostPortion":null},"cashback":null}}}`), &trans)
+	assert.Nil(t, err)
+	b = ToBackend(trans)
+	assert.Equal(t, "", b.HoldAmount)
+	assert.Equal(t, "-0.50", b.RoundUp)
+	assert.Equal(t, "", b.RoundUpBoost)
+	assert.Equal(t, "", b.Cashback)
+}